@@ -13,15 +13,108 @@ const (
 	ActionCancel
 	ActionCancelReject
 	ActionExecute
+	ActionRemainderCancel
+	ActionExpire
+	ActionReplace
+	ActionReplaceReject
+	ActionMassCancelAck
+	ActionBBO
+	ActionBookUpdate
+	ActionTrade
 )
 
+// RejectReason represents the reason an order, cancel or replace was rejected by the exchange
+type RejectReason uint8
+
+// Define the reject reasons reported on order, cancel and replace rejection actions
+const (
+	RejectNone                   RejectReason = iota
+	RejectEmptySymbol                         // The order has no symbol
+	RejectPriceOutOfBand                      // The order price is outside MinPrice and MaxPrice
+	RejectZeroSize                            // The order size is zero
+	RejectBadSide                             // The order side is neither Bid nor Ask
+	RejectBadTrader                           // The order trader is not valid
+	RejectBadTimeInForce                      // The time in force is unknown, or a GTD order has no future expiry
+	RejectUnknownOrder                        // The OrderID is not known to the exchange
+	RejectAlreadyCancelled                    // The order has already been cancelled (or has expired)
+	RejectAlreadyFilled                       // The order has already been completely filled
+	RejectDuplicateClientOrderID              // The trader already has a working order with the same client order ID
+	RejectRiskLimit                           // The order fails a pre-trade risk check (the rule is reported with the reject)
+	RejectPriceCollar                         // The order price is outside the symbol's price collar
+	RejectEmptyFilter                         // The mass cancel filter selects neither a trader nor a symbol
+	RejectTooLong                             // The symbol or client order ID is longer than MaxSymbolLength or MaxClientOrderIDLength
+)
+
+// String returns a string representation of the reject reason, used for logging
+func (reason RejectReason) String() string {
+	switch reason {
+	case RejectNone:
+		return "none"
+	case RejectEmptySymbol:
+		return "empty symbol"
+	case RejectPriceOutOfBand:
+		return "price out of band"
+	case RejectZeroSize:
+		return "zero size"
+	case RejectBadSide:
+		return "bad side"
+	case RejectBadTrader:
+		return "bad trader"
+	case RejectBadTimeInForce:
+		return "bad time in force"
+	case RejectUnknownOrder:
+		return "unknown order"
+	case RejectAlreadyCancelled:
+		return "already cancelled"
+	case RejectAlreadyFilled:
+		return "already filled"
+	case RejectDuplicateClientOrderID:
+		return "duplicate client order ID"
+	case RejectRiskLimit:
+		return "risk limit"
+	case RejectPriceCollar:
+		return "outside price collar"
+	case RejectEmptyFilter:
+		return "empty mass cancel filter"
+	case RejectTooLong:
+		return "symbol or client order ID too long"
+	default:
+		return fmt.Sprintf("unknown reject reason %d", uint8(reason))
+	}
+}
+
+// RejectError is returned to the caller when the exchange rejects an order, cancel or replace
+// The same reject reason is reported on the corresponding reject action
+type RejectError struct {
+	Reason RejectReason
+	Rule   string // Name of the failing risk rule (RejectRiskLimit)
+}
+
+// Error returns a string representation of the reject error
+func (err *RejectError) Error() string {
+	if err.Rule != "" {
+		return "exchange: rejected: " + err.Reason.String() + " (" + err.Rule + ")"
+	}
+	return "exchange: rejected: " + err.Reason.String()
+}
+
 // Action represents an action event passed by the exchange
 type Action struct {
 	action_type ActionType
-	order       Order // Used to represent an action performed on the incoming order
-	cross_order Order // Used to represent an action performed on the existing book order
-	fill_size   Size  // Number of shares filled in the execution
-	fill_price  Price // Price at which the execution occurrs
+	order       Order        // Used to represent an action performed on the incoming order
+	cross_order Order        // Used to represent an action performed on the existing book order
+	fill_size   Size         // Number of shares filled in the execution
+	fill_price  Price        // Price at which the execution occurrs
+	reason      RejectReason // Reason for an order, cancel or replace rejection
+	rule        string       // Name of the risk rule that rejected the order (RejectRiskLimit)
+	cancelled   Size         // Number of orders cancelled by a mass cancel
+	bbo         BBO          // Top of book, after a change to it
+	update      BookUpdate   // Incremental change to a price level
+	trade       Trade        // Execution, as reported publicly (and privately, along with the orders)
+	sequence    uint64       // Position of the action in its orderbook's stream (zero if not published by an orderbook)
+
+	exchangeSequence uint64 // Position of the action in the exchange's stream of actions
+	timestamp        int64  // Time the action was published, in nanoseconds since the Unix epoch
 }
 
 // newOrderAction creates a new order action based on the order side (Bid or Ask)
@@ -39,11 +132,33 @@ func newOrderAction(order *Order) *Action {
 	}
 }
 
-// newOrderRejectAction creates a new order rejection action
+// newOrderRejectAction creates a new order rejection action, carrying the offending order and the reject reason
 // This is used in cases of the incoming failing validation (eg. order.price > MAX_PRICE)
-func newOrderRejectAction() *Action {
+func newOrderRejectAction(order *Order, reason RejectReason) *Action {
+	return &Action{
+		action_type: ActionOrderReject,
+		order:       *order,
+		reason:      reason,
+	}
+}
+
+// newRiskRejectAction creates a new order rejection action for an order failing a pre-trade risk check, carrying the rule name
+func newRiskRejectAction(order *Order, rule string) *Action {
 	return &Action{
 		action_type: ActionOrderReject,
+		order:       *order,
+		reason:      RejectRiskLimit,
+		rule:        rule,
+	}
+}
+
+// newRiskReplaceRejectAction creates a new replace rejection action for an amendment failing a pre-trade risk check, carrying the rule name
+func newRiskReplaceRejectAction(order *Order, rule string) *Action {
+	return &Action{
+		action_type: ActionReplaceReject,
+		order:       *order,
+		reason:      RejectRiskLimit,
+		rule:        rule,
 	}
 }
 
@@ -55,18 +170,87 @@ func newCancelAction(order *Order) *Action {
 	}
 }
 
-// newCancelRejectAction creates a new cancel rejection action
-// This is used in cases of the cancel OrderID not being found, so the cancel is rejected
-func newCancelRejectAction() *Action {
+// newCancelRejectAction creates a new cancel rejection action, carrying the order (as far as known) and the reject reason
+// This is used in cases of the cancel OrderID not being found, or the order no longer working, so the cancel is rejected
+func newCancelRejectAction(order *Order, reason RejectReason) *Action {
 	return &Action{
 		action_type: ActionCancelReject,
+		order:       *order,
+		reason:      reason,
+	}
+}
+
+// newRemainderCancelAction creates a new remainder cancel action, based on the unfilled part of an order
+// This is used for orders that never rest in the book (eg. market, IOC and killed FOK orders)
+// The order.size is the cancelled remainder
+func newRemainderCancelAction(order *Order) *Action {
+	return &Action{
+		action_type: ActionRemainderCancel,
+		order:       *order,
+	}
+}
+
+// newExpireAction creates a new expiry action, based on the DAY or GTD order that has expired
+// The order.size is the remaining size that was expired
+func newExpireAction(order *Order) *Action {
+	return &Action{
+		action_type: ActionExpire,
+		order:       *order,
+	}
+}
+
+// newReplaceAction creates a new replace action, based on the order after its price and/or size was amended
+func newReplaceAction(order *Order) *Action {
+	return &Action{
+		action_type: ActionReplace,
+		order:       *order,
+	}
+}
+
+// newReplaceRejectAction creates a new replace rejection action, carrying the requested amendment and the reject reason
+// This is used in cases of the OrderID not being found, or the amended price or size being invalid
+func newReplaceRejectAction(order *Order, reason RejectReason) *Action {
+	return &Action{
+		action_type: ActionReplaceReject,
+		order:       *order,
+		reason:      reason,
+	}
+}
+
+// newMassCancelAckAction creates a new mass cancel acknowledgement, summarising the orders cancelled
+// The order carries the filter's trader, symbol and side (the side is only meaningful if the filter was by side)
+func newMassCancelAckAction(filter MassCancelFilter, cancelled Size) *Action {
+	return &Action{
+		action_type: ActionMassCancelAck,
+		order:       Order{symbol: filter.Symbol, side: filter.Side, trader: filter.Trader},
+		cancelled:   cancelled,
+	}
+}
+
+// newBBOAction creates a new top of book action, reporting the best bid and ask after a change
+func newBBOAction(bbo BBO) *Action {
+	return &Action{
+		action_type: ActionBBO,
+		order:       Order{symbol: bbo.Symbol},
+		bbo:         bbo,
+	}
+}
+
+// newBookUpdateAction creates a new incremental book update action, reporting the change to a single price level
+func newBookUpdateAction(update BookUpdate) *Action {
+	return &Action{
+		action_type: ActionBookUpdate,
+		order:       Order{symbol: update.Symbol, side: update.Side, price: update.Price, size: update.Size},
+		update:      update,
 	}
 }
 
 // newExecuteAction creates a new execution action, based on the two orders being executed
 // The fill_size is the number of shares filled in the execution
 // Execution occurs at entry.price for 'price improvement'
-func newExecuteAction(order *Order, entry *Order, fill_size Size) *Action {
+func newExecuteAction(order *Order, entry *Order, fill_size Size, tradeID TradeID) *Action {
+	// The incoming order is the aggressor, having crossed the spread to trade with the resting entry
+	trade := Trade{TradeID: tradeID, Symbol: order.symbol, Price: entry.price, Size: fill_size, AggressorSide: order.side}
 	if order.side == Bid {
 		return &Action{
 			action_type: ActionExecute,
@@ -74,6 +258,7 @@ func newExecuteAction(order *Order, entry *Order, fill_size Size) *Action {
 			cross_order: *entry,
 			fill_size:   fill_size,
 			fill_price:  entry.price,
+			trade:       trade,
 		}
 	} else {
 		return &Action{
@@ -82,10 +267,175 @@ func newExecuteAction(order *Order, entry *Order, fill_size Size) *Action {
 			cross_order: *order,
 			fill_size:   fill_size,
 			fill_price:  entry.price,
+			trade:       trade,
 		}
 	}
 }
 
+// newTradeAction creates a new public trade action, reporting an execution without the orders or traders involved
+func newTradeAction(trade Trade) *Action {
+	return &Action{
+		action_type: ActionTrade,
+		order:       Order{symbol: trade.Symbol, side: trade.AggressorSide, price: trade.Price},
+		fill_size:   trade.Size,
+		fill_price:  trade.Price,
+		trade:       trade,
+	}
+}
+
+// Type returns the type of the action
+func (action *Action) Type() ActionType {
+	return action.action_type
+}
+
+// Order returns the order the action was performed on (the Bid order for executions)
+func (action *Action) Order() Order {
+	return action.order
+}
+
+// CrossOrder returns the Ask order of an execution (the zero Order for other action types)
+func (action *Action) CrossOrder() Order {
+	return action.cross_order
+}
+
+// OrderID returns the OrderID of the order the action was performed on (the Bid order for executions)
+func (action *Action) OrderID() OrderID {
+	return action.order.orderID
+}
+
+// Symbol returns the symbol the action was performed on
+func (action *Action) Symbol() string {
+	return action.order.symbol
+}
+
+// Side returns the side of the order the action was performed on
+func (action *Action) Side() Side {
+	return action.order.side
+}
+
+// Price returns the limit price of the order the action was performed on
+func (action *Action) Price() Price {
+	return action.order.price
+}
+
+// Size returns the size of the order as reported by the action
+// For executions this is the size before the fill; for remainder cancels and expiries it is the size removed
+func (action *Action) Size() Size {
+	return action.order.size
+}
+
+// RemainingSize returns the size of the order still working on the exchange after the action
+func (action *Action) RemainingSize() Size {
+	switch action.action_type {
+	case ActionBid, ActionAsk, ActionReplace:
+		return action.order.size
+	case ActionExecute:
+		return action.order.size - action.fill_size
+	default:
+		// Cancels, remainder cancels, expiries and rejections leave nothing working
+		return 0
+	}
+}
+
+// CrossRemainingSize returns the size of the Ask order still working after an execution
+func (action *Action) CrossRemainingSize() Size {
+	if action.action_type != ActionExecute {
+		return 0
+	}
+	return action.cross_order.size - action.fill_size
+}
+
+// Trader returns the trader of the order the action was performed on (the Bid trader for executions)
+func (action *Action) Trader() TraderID {
+	return action.order.trader
+}
+
+// ClientOrderID returns the client order ID of the order the action was performed on
+func (action *Action) ClientOrderID() string {
+	return action.order.clientOrderID
+}
+
+// FillPrice returns the price at which an execution occurred (zero for other action types)
+func (action *Action) FillPrice() Price {
+	return action.fill_price
+}
+
+// FillSize returns the number of shares filled in an execution (zero for other action types)
+func (action *Action) FillSize() Size {
+	return action.fill_size
+}
+
+// CancelledCount returns the number of orders cancelled by a mass cancel (zero for other action types)
+func (action *Action) CancelledCount() Size {
+	return action.cancelled
+}
+
+// BBO returns the top of book reported by a top of book action (the zero BBO for other action types)
+func (action *Action) BBO() BBO {
+	return action.bbo
+}
+
+// BookUpdate returns the price level change reported by an incremental book update action (the zero BookUpdate for other action types)
+func (action *Action) BookUpdate() BookUpdate {
+	return action.update
+}
+
+// TradeID returns the unique identifier of an execution or public trade (zero for other action types)
+func (action *Action) TradeID() TradeID {
+	return action.trade.TradeID
+}
+
+// AggressorSide returns the side of the incoming order that caused an execution or public trade
+func (action *Action) AggressorSide() Side {
+	return action.trade.AggressorSide
+}
+
+// Trade returns the public view of an execution or public trade, timestamped when the action was published
+// (the zero Trade for other action types)
+func (action *Action) Trade() Trade {
+	if action.action_type != ActionExecute && action.action_type != ActionTrade {
+		return Trade{}
+	}
+	trade := action.trade
+	trade.Timestamp = action.timestamp
+	return trade
+}
+
+// ExchangeSequence returns the position of the action in the stream of every action published by the exchange
+// Sequences start at 1 and increase by 1 per action, so a gap shows that an action was missed
+func (action *Action) ExchangeSequence() uint64 {
+	return action.exchangeSequence
+}
+
+// Timestamp returns the time the action was published, in nanoseconds since the Unix epoch
+func (action *Action) Timestamp() int64 {
+	return action.timestamp
+}
+
+// Sequence returns the position of the action in the stream of actions published by its symbol's orderbook
+// Sequences start at 1 and increase by 1 per action. Actions not published by an orderbook (eg. validation rejects) have a zero sequence
+func (action *Action) Sequence() uint64 {
+	return action.sequence
+}
+
+// RejectReason returns the reason for an order, cancel or replace rejection (RejectNone otherwise)
+func (action *Action) RejectReason() RejectReason {
+	return action.reason
+}
+
+// RiskRule returns the name of the risk rule that rejected the order or amendment (empty unless the reason is RejectRiskLimit)
+func (action *Action) RiskRule() string {
+	return action.rule
+}
+
+// rejectText returns the reject reason for logging, along with the failing risk rule (if any)
+func (action *Action) rejectText() string {
+	if action.rule != "" {
+		return action.reason.String() + " (" + action.rule + ")"
+	}
+	return action.reason.String()
+}
+
 // String returns a string representation of the action, used for logging
 func (action *Action) String() string {
 	switch action.action_type {
@@ -115,7 +465,15 @@ func (action *Action) String() string {
 
 	// String reporting for an order rejection
 	case ActionOrderReject:
-		return "ORDER REJECTED"
+		return fmt.Sprintf(
+			"ORDER REJECTED. Symbol: %v, Price: %v, Size: %v, Trader: %v, ClientOrderID: %v, Reason: %v",
+			action.order.symbol,
+			action.order.price,
+			action.order.size,
+			action.order.trader,
+			action.order.clientOrderID,
+			action.rejectText(),
+		)
 
 	// String reporting for a cancel action
 	case ActionCancel:
@@ -123,7 +481,12 @@ func (action *Action) String() string {
 
 	// String reporting for a cancel rejection
 	case ActionCancelReject:
-		return "CANCEL REJECTED"
+		return fmt.Sprintf(
+			"CANCEL REJECTED. ID: %v, ClientOrderID: %v, Reason: %v",
+			action.order.orderID,
+			action.order.clientOrderID,
+			action.reason,
+		)
 
 	// String reporting for an execution action
 	case ActionExecute:
@@ -139,6 +502,84 @@ func (action *Action) String() string {
 			action.cross_order.trader, // Ask trader
 		)
 
+	// String reporting for a public trade (without the orders or traders involved)
+	case ActionTrade:
+		aggressor := "Bid"
+		if action.trade.AggressorSide == Ask {
+			aggressor = "Ask"
+		}
+		return fmt.Sprintf(
+			"TRADE. ID: %v, Symbol: %v, Price: %v, Size: %v, Aggressor: %v",
+			action.trade.TradeID,
+			action.trade.Symbol,
+			action.trade.Price,
+			action.trade.Size,
+			aggressor,
+		)
+
+	// String reporting for the cancelled remainder of a non-resting order
+	case ActionRemainderCancel:
+		return fmt.Sprintf("REMAINDER CANCELLED. ID: %v, Size: %v", action.order.orderID, action.order.size)
+
+	// String reporting for an expired order
+	case ActionExpire:
+		return fmt.Sprintf("EXPIRED. ID: %v, Size: %v", action.order.orderID, action.order.size)
+
+	// String reporting for a replace (amend) action
+	case ActionReplace:
+		return fmt.Sprintf(
+			"REPLACE. ID: %v, Symbol: %v, Price: %v, Size: %v",
+			action.order.orderID,
+			action.order.symbol,
+			action.order.price,
+			action.order.size,
+		)
+
+	// String reporting for a replace rejection
+	case ActionReplaceReject:
+		return fmt.Sprintf(
+			"REPLACE REJECTED. ID: %v, ClientOrderID: %v, Reason: %v",
+			action.order.orderID,
+			action.order.clientOrderID,
+			action.rejectText(),
+		)
+
+	// String reporting for a mass cancel acknowledgement
+	case ActionMassCancelAck:
+		return fmt.Sprintf(
+			"MASS CANCEL. Symbol: %v, Trader: %v, Cancelled: %v",
+			action.order.symbol,
+			action.order.trader,
+			action.cancelled,
+		)
+
+	// String reporting for a top of book change
+	case ActionBBO:
+		return fmt.Sprintf(
+			"BBO. Symbol: %v, Bid: %v x %v, Ask: %v x %v",
+			action.bbo.Symbol,
+			action.bbo.BidSize,
+			action.bbo.BidPrice,
+			action.bbo.AskSize,
+			action.bbo.AskPrice,
+		)
+
+	// String reporting for an incremental book update
+	case ActionBookUpdate:
+		side := "Bid"
+		if action.update.Side == Ask {
+			side = "Ask"
+		}
+		return fmt.Sprintf(
+			"BOOK UPDATE. Symbol: %v, Side: %v, Update: %v, Price: %v, Size: %v, Orders: %v",
+			action.update.Symbol,
+			side,
+			action.update.Update,
+			action.update.Price,
+			action.update.Size,
+			action.update.Orders,
+		)
+
 	// Default case for unknown action types
 	default:
 		return fmt.Sprintf("Unknown Action Type: %v", action.action_type)
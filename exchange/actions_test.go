@@ -1,6 +1,7 @@
 package exchange
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -30,17 +31,140 @@ func TestNewCancelAction(t *testing.T) {
 }
 
 func TestNewCancelRejectAction(t *testing.T) {
-	action := newCancelRejectAction()
+	order := &Order{orderID: 1, clientOrderID: "abc"}
+	action := newCancelRejectAction(order, RejectUnknownOrder)
 	if action.action_type != ActionCancelReject {
 		t.Errorf("Expected action type to be %v, got %v", ActionCancelReject, action.action_type)
 	}
+	if action.order != *order {
+		t.Errorf("Expected order to be %v, got %v", *order, action.order)
+	}
+	if action.reason != RejectUnknownOrder {
+		t.Errorf("Expected reason to be %v, got %v", RejectUnknownOrder, action.reason)
+	}
+}
+
+func TestRejectError(t *testing.T) {
+	err := &RejectError{Reason: RejectUnknownOrder}
+	if err.Error() != "exchange: rejected: unknown order" {
+		t.Errorf("Expected 'exchange: rejected: unknown order', got %v", err.Error())
+	}
+}
+
+func TestNewOrderRejectAction(t *testing.T) {
+	order := &Order{symbol: "AAPL", side: Bid, price: 150, size: 0, trader: 1, clientOrderID: "abc"}
+	action := newOrderRejectAction(order, RejectZeroSize)
+	if action.action_type != ActionOrderReject {
+		t.Errorf("Expected action type to be %v, got %v", ActionOrderReject, action.action_type)
+	}
+	if action.order != *order {
+		t.Errorf("Expected order to be %v, got %v", *order, action.order)
+	}
+	if action.reason != RejectZeroSize {
+		t.Errorf("Expected reason to be %v, got %v", RejectZeroSize, action.reason)
+	}
+}
+
+func TestNewRiskRejectAction(t *testing.T) {
+	order := &Order{symbol: "AAPL", side: Bid, price: 150, size: 10, trader: 1}
+	action := newRiskRejectAction(order, RiskRuleMaxNotional)
+	if action.action_type != ActionOrderReject || action.RejectReason() != RejectRiskLimit {
+		t.Errorf("Expected a risk limit order reject, got %v", action)
+	}
+	if action.RiskRule() != RiskRuleMaxNotional {
+		t.Errorf("Expected the rule to be %v, got %v", RiskRuleMaxNotional, action.RiskRule())
+	}
+	if !strings.HasSuffix(action.String(), "risk limit (max notional)") {
+		t.Errorf("Expected the rule to be logged, got %v", action.String())
+	}
+}
+
+func TestRejectErrorRule(t *testing.T) {
+	err := &RejectError{Reason: RejectRiskLimit, Rule: RiskRuleMaxOrderSize}
+	if err.Error() != "exchange: rejected: risk limit (max order size)" {
+		t.Errorf("Expected 'exchange: rejected: risk limit (max order size)', got %v", err.Error())
+	}
+}
+
+func TestRejectReasonString(t *testing.T) {
+	if RejectPriceOutOfBand.String() != "price out of band" {
+		t.Errorf("Expected 'price out of band', got %v", RejectPriceOutOfBand.String())
+	}
+	if RejectReason(255).String() != "unknown reject reason 255" {
+		t.Errorf("Expected unknown reject reason, got %v", RejectReason(255).String())
+	}
+}
+
+func TestNewRemainderCancelAction(t *testing.T) {
+	order := &Order{orderID: 1, symbol: "AAPL", side: Bid, price: MaxPrice, size: 5, trader: 1}
+	action := newRemainderCancelAction(order)
+	if action.action_type != ActionRemainderCancel {
+		t.Errorf("Expected action type to be %v, got %v", ActionRemainderCancel, action.action_type)
+	}
+	if action.order.size != 5 {
+		t.Errorf("Expected remainder size to be %v, got %v", 5, action.order.size)
+	}
+}
+
+func TestNewExpireAction(t *testing.T) {
+	order := &Order{orderID: 1, symbol: "AAPL", side: Bid, price: 150, size: 10, trader: 1, tif: DAY}
+	action := newExpireAction(order)
+	if action.action_type != ActionExpire {
+		t.Errorf("Expected action type to be %v, got %v", ActionExpire, action.action_type)
+	}
+	if action.order != *order {
+		t.Errorf("Expected order to be %v, got %v", *order, action.order)
+	}
+}
+
+func TestNewReplaceAction(t *testing.T) {
+	order := &Order{orderID: 1, symbol: "AAPL", side: Bid, price: 151, size: 5, trader: 1}
+	action := newReplaceAction(order)
+	if action.action_type != ActionReplace {
+		t.Errorf("Expected action type to be %v, got %v", ActionReplace, action.action_type)
+	}
+	if action.order != *order {
+		t.Errorf("Expected order to be %v, got %v", *order, action.order)
+	}
+}
+
+func TestNewMassCancelAckAction(t *testing.T) {
+	action := newMassCancelAckAction(MassCancelFilter{Trader: 1, Side: Ask, BySide: true}, 5)
+	if action.action_type != ActionMassCancelAck {
+		t.Errorf("Expected action type to be %v, got %v", ActionMassCancelAck, action.action_type)
+	}
+	if action.CancelledCount() != 5 || action.Trader() != 1 || action.Side() != Ask {
+		t.Errorf("Expected the filter and cancelled count on the acknowledgement, got %v", action)
+	}
+}
+
+func TestNewBBOAction(t *testing.T) {
+	top := BBO{Symbol: "AAPL", BidPrice: 100, BidSize: 10, AskPrice: 101, AskSize: 5}
+	action := newBBOAction(top)
+	if action.action_type != ActionBBO {
+		t.Errorf("Expected action type to be %v, got %v", ActionBBO, action.action_type)
+	}
+	if action.BBO() != top || action.Symbol() != "AAPL" {
+		t.Errorf("Expected the top of book on the action, got %v", action.BBO())
+	}
+}
+
+func TestNewBookUpdateAction(t *testing.T) {
+	update := BookUpdate{Symbol: "AAPL", Side: Ask, Update: LevelChange, Price: 101, Size: 7, Orders: 2}
+	action := newBookUpdateAction(update)
+	if action.action_type != ActionBookUpdate {
+		t.Errorf("Expected action type to be %v, got %v", ActionBookUpdate, action.action_type)
+	}
+	if action.BookUpdate() != update || action.Symbol() != "AAPL" || action.Side() != Ask {
+		t.Errorf("Expected the price level change on the action, got %v", action.BookUpdate())
+	}
 }
 
 func TestNewExecuteAction(t *testing.T) {
 	order := &Order{orderID: 1, symbol: "AAPL", side: Bid, price: 150, size: 10, trader: 1}
 	entry := &Order{orderID: 2, symbol: "AAPL", side: Ask, price: 150, size: 10, trader: 2}
 	fill_size := Size(10)
-	action := newExecuteAction(order, entry, fill_size)
+	action := newExecuteAction(order, entry, fill_size, 1)
 	if action.action_type != ActionExecute {
 		t.Errorf("Expected action type to be %v, got %v", ActionExecute, action.action_type)
 	}
@@ -55,6 +179,63 @@ func TestNewExecuteAction(t *testing.T) {
 	}
 }
 
+func TestNewTradeAction(t *testing.T) {
+	order := &Order{orderID: 1, symbol: "AAPL", side: Ask, price: 148, size: 10, trader: 1}
+	entry := &Order{orderID: 2, symbol: "AAPL", side: Bid, price: 150, size: 10, trader: 2}
+
+	// The execution records the trade ID and the incoming order as the aggressor
+	execute := newExecuteAction(order, entry, 4, 7)
+	if execute.TradeID() != 7 || execute.AggressorSide() != Ask {
+		t.Errorf("Expected trade 7 with an Ask aggressor, got %v and %v", execute.TradeID(), execute.AggressorSide())
+	}
+
+	// The public trade carries the same trade, without the orders or traders
+	action := newTradeAction(execute.Trade())
+	if action.action_type != ActionTrade {
+		t.Errorf("Expected action type to be %v, got %v", ActionTrade, action.action_type)
+	}
+	want := Trade{TradeID: 7, Symbol: "AAPL", Price: 150, Size: 4, AggressorSide: Ask}
+	if action.Trade() != want {
+		t.Errorf("Expected trade %+v, got %+v", want, action.Trade())
+	}
+	if action.OrderID() != 0 || action.Trader() != 0 || action.CrossOrder() != (Order{}) {
+		t.Errorf("Expected no orders or traders on the public trade, got %v", action)
+	}
+	if newCancelAction(order).Trade() != (Trade{}) {
+		t.Errorf("Expected the zero Trade for other action types")
+	}
+}
+
+func TestActionAccessors(t *testing.T) {
+	order := &Order{orderID: 1, symbol: "AAPL", side: Bid, price: 150, size: 10, trader: 1, clientOrderID: "B1"}
+	entry := &Order{orderID: 2, symbol: "AAPL", side: Ask, price: 149, size: 4, trader: 2}
+
+	action := newExecuteAction(order, entry, 4, 1)
+	if action.Type() != ActionExecute || action.OrderID() != 1 || action.Symbol() != "AAPL" || action.Side() != Bid {
+		t.Errorf("Expected the execution accessors to describe the Bid order, got %v", action)
+	}
+	if action.Price() != 150 || action.Size() != 10 || action.Trader() != 1 || action.ClientOrderID() != "B1" {
+		t.Errorf("Expected the execution accessors to describe the Bid order, got %v", action)
+	}
+	if action.FillPrice() != 149 || action.FillSize() != 4 {
+		t.Errorf("Expected a fill of 4 at 149, got %d at %d", action.FillSize(), action.FillPrice())
+	}
+	if action.RemainingSize() != 6 || action.CrossRemainingSize() != 0 {
+		t.Errorf("Expected remaining sizes of 6 and 0, got %d and %d", action.RemainingSize(), action.CrossRemainingSize())
+	}
+	if action.Order() != *order || action.CrossOrder() != *entry {
+		t.Errorf("Expected the orders to be returned")
+	}
+
+	reject := newCancelRejectAction(order, RejectAlreadyFilled)
+	if reject.RejectReason() != RejectAlreadyFilled || reject.RemainingSize() != 0 {
+		t.Errorf("Expected a cancel reject with nothing remaining, got %v", reject)
+	}
+	if newOrderAction(order).RemainingSize() != 10 {
+		t.Errorf("Expected a new order to have its whole size remaining")
+	}
+}
+
 func TestActionString(t *testing.T) {
 	order := &Order{orderID: 1, symbol: "AAPL", side: Bid, price: 150, size: 10, trader: 1}
 	entry := &Order{orderID: 2, symbol: "AAPL", side: Ask, price: 150, size: 5, trader: 2}
@@ -66,8 +247,17 @@ func TestActionString(t *testing.T) {
 	}{
 		{newOrderAction(order), "ORDER. ID: 1, Symbol: AAPL, Side: Bid, Price: 150, Size: 10, Trader: 1"},
 		{newCancelAction(order), "CANCEL. ID: 1"},
-		{newCancelRejectAction(), "CANCEL REJECTED"},
-		{newExecuteAction(order, entry, fill_size), "EXECUTION. Bid_ID: 1, Ask_ID: 2, Symbol: AAPL, Price: 150, Size: 5, Bid_Trader: 1, Ask_Trader: 2"},
+		{newOrderRejectAction(order, RejectBadTrader), "ORDER REJECTED. Symbol: AAPL, Price: 150, Size: 10, Trader: 1, ClientOrderID: , Reason: bad trader"},
+		{newCancelRejectAction(entry, RejectAlreadyFilled), "CANCEL REJECTED. ID: 2, ClientOrderID: , Reason: already filled"},
+		{newRemainderCancelAction(entry), "REMAINDER CANCELLED. ID: 2, Size: 5"},
+		{newExpireAction(order), "EXPIRED. ID: 1, Size: 10"},
+		{newReplaceAction(order), "REPLACE. ID: 1, Symbol: AAPL, Price: 150, Size: 10"},
+		{newMassCancelAckAction(MassCancelFilter{Symbol: "AAPL", Trader: 1}, 3), "MASS CANCEL. Symbol: AAPL, Trader: 1, Cancelled: 3"},
+		{newTradeAction(Trade{TradeID: 3, Symbol: "AAPL", Price: 150, Size: 5, AggressorSide: Ask}), "TRADE. ID: 3, Symbol: AAPL, Price: 150, Size: 5, Aggressor: Ask"},
+		{newBBOAction(BBO{Symbol: "AAPL", BidPrice: 100, BidSize: 10}), "BBO. Symbol: AAPL, Bid: 10 x 100, Ask: 0 x 0"},
+		{newBookUpdateAction(BookUpdate{Symbol: "AAPL", Side: Bid, Update: LevelDelete, Price: 100}), "BOOK UPDATE. Symbol: AAPL, Side: Bid, Update: DELETE, Price: 100, Size: 0, Orders: 0"},
+		{newReplaceRejectAction(order, RejectAlreadyCancelled), "REPLACE REJECTED. ID: 1, ClientOrderID: , Reason: already cancelled"},
+		{newExecuteAction(order, entry, fill_size, 1), "EXECUTION. Bid_ID: 1, Ask_ID: 2, Symbol: AAPL, Price: 150, Size: 5, Bid_Trader: 1, Ask_Trader: 2"},
 	}
 
 	for _, tt := range tests {
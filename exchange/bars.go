@@ -0,0 +1,191 @@
+package exchange
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Define the bar intervals supported by the bar aggregator
+const (
+	Bar1s = time.Second
+	Bar1m = time.Minute
+	Bar5m = 5 * time.Minute
+	Bar1h = time.Hour
+	Bar1d = 24 * time.Hour
+)
+
+// ErrBarInterval is returned when initialising a bar aggregator with a zero or negative interval
+var ErrBarInterval = errors.New("exchange: bar interval must be positive")
+
+// Bar represents the open/high/low/close/volume (OHLCV) summary of the executions in a symbol over one interval
+// Intervals are aligned to the Unix epoch (in UTC), so a Bar1d bar starts at midnight UTC
+type Bar struct {
+	Symbol   string
+	Interval time.Duration
+	Start    time.Time // Start of the interval (inclusive)
+	End      time.Time // End of the interval (exclusive)
+	Open     Price
+	High     Price
+	Low      Price
+	Close    Price
+	Volume   uint64  // Total size executed
+	Notional uint64  // Total price * size executed
+	VWAP     float64 // Volume weighted average price
+	Trades   int     // Number of executions
+}
+
+// String returns a human readable representation of the bar
+func (bar *Bar) String() string {
+	return fmt.Sprintf(
+		"BAR. Symbol: %v, Interval: %v, Start: %v, Open: %v, High: %v, Low: %v, Close: %v, Volume: %v, VWAP: %.2f, Trades: %v",
+		bar.Symbol,
+		bar.Interval,
+		bar.Start.UTC().Format(time.RFC3339),
+		bar.Open,
+		bar.High,
+		bar.Low,
+		bar.Close,
+		bar.Volume,
+		bar.VWAP,
+		bar.Trades,
+	)
+}
+
+// add includes an execution of size at price in the bar
+func (bar *Bar) add(price Price, size Size) {
+	if bar.Trades == 0 {
+		bar.Open = price
+		bar.High = price
+		bar.Low = price
+	}
+	bar.High = max(bar.High, price)
+	bar.Low = min(bar.Low, price)
+	bar.Close = price
+	bar.Volume += uint64(size)
+	bar.Notional += uint64(price) * uint64(size)
+	bar.VWAP = float64(bar.Notional) / float64(bar.Volume)
+	bar.Trades += 1
+}
+
+// barKey identifies the bar currently being built for a symbol and interval
+type barKey struct {
+	symbol   string
+	interval time.Duration
+}
+
+// BarAggregator builds OHLCV bars per symbol, for each of its intervals, from the executions reported by the exchange
+// Completed bars are reported via the bars channel when their interval closes, once the aggregator mutex is released
+// (so a slow reader of the bars channel does not hold up Current)
+type BarAggregator struct {
+	intervals []time.Duration
+	current   map[barKey]*Bar // Bars still open, per symbol and interval
+	bars      chan *Bar
+	mutex     sync.Mutex
+}
+
+// Init initialises the bar aggregator with the given intervals (eg. Bar1s, Bar1m) and the channel completed bars are reported on
+// Returns ErrBarInterval (leaving the aggregator uninitialised) if any interval is zero or negative
+func (ba *BarAggregator) Init(intervals []time.Duration, bars chan *Bar) error {
+	for _, interval := range intervals {
+		if interval <= 0 {
+			return fmt.Errorf("%w: %v", ErrBarInterval, interval)
+		}
+	}
+
+	// Lock the aggregator mutex to prevent concurrent access
+	ba.mutex.Lock()
+	defer ba.mutex.Unlock()
+
+	ba.intervals = append([]time.Duration(nil), intervals...)
+	ba.current = make(map[barKey]*Bar, len(intervals)*int(EstNumSymbols))
+	ba.bars = bars
+	return nil
+}
+
+// Process includes an action reported by the exchange in the bars of its symbol; only executions are included
+// An execution in a later interval than the open bar first closes it, so actions should be processed in the order published
+func (ba *BarAggregator) Process(action *Action) {
+	if action.Type() != ActionExecute {
+		return
+	}
+	trade := action.Trade()
+	at := time.Unix(0, trade.Timestamp).UTC()
+
+	// Lock the aggregator mutex to prevent concurrent access
+	ba.mutex.Lock()
+
+	var closed []*Bar
+	for _, interval := range ba.intervals {
+		key := barKey{symbol: trade.Symbol, interval: interval}
+		start := at.Truncate(interval)
+
+		// Close the open bar if the execution falls after its interval
+		bar, exists := ba.current[key]
+		if exists && !at.Before(bar.End) {
+			closed = append(closed, bar)
+			exists = false
+		}
+		if !exists {
+			bar = &Bar{Symbol: trade.Symbol, Interval: interval, Start: start, End: start.Add(interval)}
+			ba.current[key] = bar
+		}
+		bar.add(trade.Price, trade.Size)
+	}
+	ba.mutex.Unlock()
+
+	// Report the closed bars once the mutex is released
+	for _, bar := range closed {
+		ba.bars <- bar
+	}
+}
+
+// Run processes every action received on the actions channel, until it is closed
+func (ba *BarAggregator) Run(actions <-chan *Action) {
+	for action := range actions {
+		ba.Process(action)
+	}
+}
+
+// CloseBars closes (and reports via the bars channel) every open bar whose interval has ended at or before the given time
+// Bars are otherwise only closed by a later execution, so this should be called periodically by the owner of the aggregator
+func (ba *BarAggregator) CloseBars(now time.Time) {
+	// Lock the aggregator mutex to prevent concurrent access
+	ba.mutex.Lock()
+	var closed []*Bar
+	for key, bar := range ba.current {
+		if !now.Before(bar.End) {
+			closed = append(closed, bar)
+			delete(ba.current, key)
+		}
+	}
+	ba.mutex.Unlock()
+
+	// Report the closed bars (once the mutex is released) in a stable order: by symbol, and then by interval
+	slices.SortFunc(closed, func(a, b *Bar) int {
+		if a.Symbol != b.Symbol {
+			return cmp.Compare(a.Symbol, b.Symbol)
+		}
+		return cmp.Compare(a.Interval, b.Interval)
+	})
+	for _, bar := range closed {
+		ba.bars <- bar
+	}
+}
+
+// Current returns a copy of the open bar for the symbol and interval
+// Returns false if there have been no executions in the symbol since the last bar for the interval closed
+func (ba *BarAggregator) Current(symbol string, interval time.Duration) (Bar, bool) {
+	// Lock the aggregator mutex to prevent concurrent access
+	ba.mutex.Lock()
+	defer ba.mutex.Unlock()
+
+	bar, exists := ba.current[barKey{symbol: symbol, interval: interval}]
+	if !exists {
+		return Bar{}, false
+	}
+	return *bar, true
+}
@@ -0,0 +1,163 @@
+package exchange
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// executeAt returns an execution action in the symbol, published at the given time
+func executeAt(symbol string, price Price, size Size, at time.Time) *Action {
+	order := &Order{orderID: 1, symbol: symbol, side: Bid, price: price, size: size, trader: 1}
+	entry := &Order{orderID: 2, symbol: symbol, side: Ask, price: price, size: size, trader: 2}
+	action := newExecuteAction(order, entry, size, 1)
+	action.timestamp = at.UnixNano()
+	return action
+}
+
+func TestBarAggregator_Process(t *testing.T) {
+	bars := make(chan *Bar, 10)
+	var aggregator BarAggregator
+	aggregator.Init([]time.Duration{Bar1m, Bar1h}, bars)
+
+	start := time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC)
+	aggregator.Process(executeAt("AAPL", 100, 10, start.Add(5*time.Second)))
+	aggregator.Process(executeAt("AAPL", 104, 5, start.Add(20*time.Second)))
+	aggregator.Process(executeAt("AAPL", 98, 5, start.Add(40*time.Second)))
+	aggregator.Process(executeAt("AAPL", 101, 20, start.Add(59*time.Second)))
+	aggregator.Process(newOrderAction(&Order{symbol: "AAPL", price: 500, size: 1}))
+
+	bar, ok := aggregator.Current("AAPL", Bar1m)
+	if !ok {
+		t.Fatalf("Expected an open 1m bar")
+	}
+	want := Bar{
+		Symbol: "AAPL", Interval: Bar1m, Start: start, End: start.Add(time.Minute),
+		Open: 100, High: 104, Low: 98, Close: 101, Volume: 40, Notional: 4030, VWAP: 100.75, Trades: 4,
+	}
+	if bar != want {
+		t.Errorf("Expected bar %+v, got %+v", want, bar)
+	}
+	if len(bars) != 0 {
+		t.Errorf("Expected no bars to be closed yet, got %d", len(bars))
+	}
+
+	// An execution in the next minute closes the 1m bar, but not the 1h bar
+	aggregator.Process(executeAt("AAPL", 102, 1, start.Add(time.Minute)))
+	if len(bars) != 1 {
+		t.Fatalf("Expected the 1m bar to be closed, got %d bars", len(bars))
+	}
+	if closed := <-bars; *closed != want {
+		t.Errorf("Expected the closed bar %+v, got %+v", want, *closed)
+	}
+	if bar, _ := aggregator.Current("AAPL", Bar1m); bar.Open != 102 || bar.Trades != 1 {
+		t.Errorf("Expected a new 1m bar opening at 102, got %+v", bar)
+	}
+	if bar, _ := aggregator.Current("AAPL", Bar1h); bar.Trades != 5 || !bar.Start.Equal(start.Truncate(time.Hour)) {
+		t.Errorf("Expected the 1h bar to include all 5 executions, got %+v", bar)
+	}
+	if _, ok := aggregator.Current("MSFT", Bar1m); ok {
+		t.Errorf("Expected no bar for a symbol without executions")
+	}
+}
+
+func TestBarAggregator_CloseBars(t *testing.T) {
+	bars := make(chan *Bar, 10)
+	var aggregator BarAggregator
+	aggregator.Init([]time.Duration{Bar1s, Bar5m, Bar1d}, bars)
+
+	start := time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC)
+	aggregator.Process(executeAt("MSFT", 200, 1, start))
+	aggregator.Process(executeAt("AAPL", 100, 1, start))
+
+	// Only the bars whose interval has ended are closed, in symbol and then interval order
+	aggregator.CloseBars(start.Add(5 * time.Minute))
+	if len(bars) != 4 {
+		t.Fatalf("Expected 4 closed bars, got %d", len(bars))
+	}
+	want := []struct {
+		symbol   string
+		interval time.Duration
+	}{{"AAPL", Bar1s}, {"AAPL", Bar5m}, {"MSFT", Bar1s}, {"MSFT", Bar5m}}
+	for _, w := range want {
+		if bar := <-bars; bar.Symbol != w.symbol || bar.Interval != w.interval {
+			t.Errorf("Expected the %v %v bar, got %v", w.symbol, w.interval, bar)
+		}
+	}
+	if _, ok := aggregator.Current("AAPL", Bar5m); ok {
+		t.Errorf("Expected the 5m bar to be closed")
+	}
+	if bar, ok := aggregator.Current("AAPL", Bar1d); !ok || !bar.Start.Equal(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the 1d bar to remain open from midnight UTC, got %+v", bar)
+	}
+}
+
+func TestBarAggregator_Exchange(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	bars := make(chan *Bar, 10)
+	var aggregator BarAggregator
+	aggregator.Init([]time.Duration{Bar1d}, bars)
+
+	exchange.Limit("AAPL", 100, 10, Ask, 1)
+	exchange.Limit("AAPL", 101, 10, Ask, 1)
+	exchange.Limit("AAPL", 101, 15, Bid, 2)
+	for _, action := range drainActions(&exchange, actions) {
+		aggregator.Process(action)
+	}
+
+	bar, ok := aggregator.Current("AAPL", Bar1d)
+	if !ok || bar.Trades != 2 || bar.Open != 100 || bar.Close != 101 || bar.Volume != 15 {
+		t.Errorf("Expected a bar of the 2 executions, got %+v", bar)
+	}
+}
+
+func TestBarAggregator_InvalidInterval(t *testing.T) {
+	var aggregator BarAggregator
+	for _, interval := range []time.Duration{0, -time.Minute} {
+		if err := aggregator.Init([]time.Duration{Bar1m, interval}, nil); !errors.Is(err, ErrBarInterval) {
+			t.Errorf("Expected the %v interval to be rejected, got %v", interval, err)
+		}
+	}
+}
+
+func TestBarAggregator_SlowReader(t *testing.T) {
+	bars := make(chan *Bar)
+	var aggregator BarAggregator
+	aggregator.Init([]time.Duration{Bar1s}, bars)
+
+	start := time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC)
+	aggregator.Process(executeAt("AAPL", 100, 1, start))
+
+	// While the closed bar waits for a reader, the aggregator can still be queried
+	done := make(chan struct{})
+	go func() {
+		aggregator.CloseBars(start.Add(time.Second))
+		close(done)
+	}()
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if _, ok := aggregator.Current("AAPL", Bar1s); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the bar to be closed")
+		}
+	}
+	if bar := <-bars; bar.Symbol != "AAPL" {
+		t.Errorf("Expected the closed AAPL bar, got %v", bar)
+	}
+	<-done
+}
+
+func TestBarString(t *testing.T) {
+	bar := &Bar{
+		Symbol: "AAPL", Interval: Bar1m, Start: time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC),
+		Open: 100, High: 104, Low: 98, Close: 101, Volume: 40, VWAP: 100.75, Trades: 4,
+	}
+	want := "BAR. Symbol: AAPL, Interval: 1m0s, Start: 2024-01-02T09:30:00Z, Open: 100, High: 104, Low: 98, Close: 101, Volume: 40, VWAP: 100.75, Trades: 4"
+	if bar.String() != want {
+		t.Errorf("Expected %q, got %q", want, bar.String())
+	}
+}
@@ -0,0 +1,140 @@
+package exchange
+
+// CollarReference represents the reference price a symbol's price collar is centred on
+type CollarReference uint8
+
+// Define the collar reference prices
+const (
+	ReferenceLastTrade CollarReference = iota // Price of the last trade, falling back to the BBO midpoint before the first trade
+	ReferenceMidpoint                         // BBO midpoint, falling back to the last trade while either side of the book is empty
+)
+
+// PriceCollar represents a symbol's dynamic price band (fat-finger collar), around a reference price
+// The band extends the wider of Ticks and BasisPoints of the reference price either side of it. A zero band is not checked
+// Incoming limit orders (and amendments to a new price) outside the band are rejected (RejectPriceCollar),
+// or clipped to its edge if Clip is set
+// A bid above the band (or an ask below it) is clipped; a bid below the band (or an ask above it) is always rejected,
+// as clipping it would make it more aggressive. Orders are not collared until the symbol has a reference price
+type PriceCollar struct {
+	Ticks       Price           // Band width either side of the reference, in ticks
+	BasisPoints uint32          // Band width either side of the reference, in hundredths of a percent (eg. 500 for ±5%)
+	Reference   CollarReference // Reference price the band is centred on
+	Clip        bool            // Clip aggressive orders outside the band to its edge, rather than rejecting them
+}
+
+// SetPriceCollar sets the price collar of the symbol, checked against every later incoming limit order
+// A zero collar removes the symbol's collar
+func (ex *Exchange) SetPriceCollar(symbol string, collar PriceCollar) {
+	ex.execute(&Command{Type: CommandSetPriceCollar, Request: OrderRequest{Symbol: symbol}, Collar: collar})
+}
+
+// setPriceCollar sets (or with a zero collar, removes) the price collar of the symbol
+func (ex *Exchange) setPriceCollar(symbol string, collar PriceCollar) {
+	// Lock the exchange mutex to prevent concurrent access
+	ex.mutex.Lock()
+	defer ex.mutex.Unlock()
+
+	if collar == (PriceCollar{}) {
+		delete(ex.collars, symbol)
+		return
+	}
+	ex.collars[symbol] = collar
+}
+
+// PriceBand returns the current price band of the symbol (inclusive), or false if the symbol is not collared
+// (it has no collar, or no reference price yet)
+func (ex *Exchange) PriceBand(symbol string) (low Price, high Price, ok bool) {
+	// Lock the exchange mutex (for reading) to look up the collar and orderbook, without creating it
+	ex.mutex.RLock()
+	collar, collared := ex.collars[symbol]
+	ob, exists := ex.orderbooksMap[symbol]
+	ex.mutex.RUnlock()
+	if !collared || !exists {
+		return 0, 0, false
+	}
+
+	// Lock the orderbook mutex (for reading) to prevent concurrent access
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	return ob.priceBand(collar)
+}
+
+// collarPrice checks the price of an incoming limit order against its symbol's price collar (if any)
+// Returns the price to use (clipped to the band, if the collar clips), or RejectPriceCollar if the order is rejected
+func (ex *Exchange) collarPrice(symbol string, side Side, price Price) (Price, RejectReason) {
+	// Lock the exchange mutex (for reading) to look up the collar and orderbook, without creating it
+	ex.mutex.RLock()
+	collar, collared := ex.collars[symbol]
+	ob, exists := ex.orderbooksMap[symbol]
+	ex.mutex.RUnlock()
+	if !collared || !exists {
+		return price, RejectNone
+	}
+
+	// Lock the orderbook mutex (for reading) to prevent concurrent access
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	return ob.collarPrice(collar, side, price)
+}
+
+// collarPrice checks a price against the collar's band around the orderbook's reference price
+// Returns the price to use (clipped to the band, if the collar clips), or RejectPriceCollar if the price is rejected
+// The orderbook mutex must be held by the caller
+func (ob *OrderBook) collarPrice(collar PriceCollar, side Side, price Price) (Price, RejectReason) {
+	low, high, ok := ob.priceBand(collar)
+	if !ok || (price >= low && price <= high) {
+		return price, RejectNone
+	}
+
+	// Clip an aggressive price back to the edge of the band, and reject any other price outside it
+	switch {
+	case collar.Clip && side == Bid && price > high:
+		return high, RejectNone
+	case collar.Clip && side == Ask && price < low:
+		return low, RejectNone
+	default:
+		return price, RejectPriceCollar
+	}
+}
+
+// priceBand returns the price band of the collar around the orderbook's reference price, or false if there is none
+// The orderbook mutex must be held by the caller
+func (ob *OrderBook) priceBand(collar PriceCollar) (Price, Price, bool) {
+	reference, ok := ob.referencePrice(collar.Reference)
+	if !ok || (collar.Ticks == 0 && collar.BasisPoints == 0) {
+		return 0, 0, false
+	}
+
+	// The wider of the two band widths, capped at the price bounds
+	band := uint64(collar.Ticks)
+	band = max(band, uint64(reference)*uint64(collar.BasisPoints)/10_000)
+	low, high := MinPrice, MaxPrice
+	if uint64(reference) > uint64(MinPrice)+band {
+		low = reference - Price(band)
+	}
+	if uint64(reference)+band < uint64(MaxPrice) {
+		high = reference + Price(band)
+	}
+	return low, high, true
+}
+
+// referencePrice returns the orderbook's reference price for a collar, or false if there is none
+// The orderbook mutex must be held by the caller
+func (ob *OrderBook) referencePrice(reference CollarReference) (Price, bool) {
+	var midpoint Price
+	if top := ob.bbo(); top.BidPrice != 0 && top.AskPrice != 0 {
+		midpoint = Price((uint64(top.BidPrice) + uint64(top.AskPrice)) / 2)
+	}
+
+	// Take the preferred reference price, falling back to the other
+	preferred, fallback := ob.lastTrade, midpoint
+	if reference == ReferenceMidpoint {
+		preferred, fallback = midpoint, ob.lastTrade
+	}
+	if preferred != 0 {
+		return preferred, true
+	}
+	return fallback, fallback != 0
+}
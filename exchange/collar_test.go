@@ -0,0 +1,188 @@
+package exchange
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// trade crosses two orders at the price, setting the symbol's last trade price
+func trade(exchange *Exchange, symbol string, price Price) {
+	exchange.Limit(symbol, price, 1, Ask, 1)
+	exchange.Limit(symbol, price, 1, Bid, 2)
+}
+
+// collarRejected returns whether the error is a price collar rejection
+func collarRejected(err error) bool {
+	var rejectErr *RejectError
+	return errors.As(err, &rejectErr) && rejectErr.Reason == RejectPriceCollar
+}
+
+func TestPriceCollar_Reject(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+	exchange.SetPriceCollar("AAPL", PriceCollar{Ticks: 5})
+
+	// Without a reference price, orders are not collared
+	if _, _, ok := exchange.PriceBand("AAPL"); ok {
+		t.Errorf("Expected no price band before the first trade")
+	}
+	trade(&exchange, "AAPL", 100)
+	drainOrderActions(&exchange, actions)
+	if low, high, ok := exchange.PriceBand("AAPL"); !ok || low != 95 || high != 105 {
+		t.Errorf("Expected the price band 95-105, got %d-%d (%v)", low, high, ok)
+	}
+
+	// A bid above the band is rejected, and one at its edge accepted
+	if _, err := exchange.Limit("AAPL", MaxPrice, 10, Bid, 3); !collarRejected(err) {
+		t.Errorf("Expected the bid at MaxPrice to be rejected, got %v", err)
+	}
+	got := drainOrderActions(&exchange, actions)
+	if len(got) != 1 || got[0].action_type != ActionOrderReject || got[0].RejectReason() != RejectPriceCollar {
+		t.Errorf("Expected a price collar reject action, got %v", got)
+	}
+	if _, err := exchange.Limit("AAPL", 105, 10, Bid, 3); err != nil {
+		t.Errorf("Expected the bid at the edge of the band to be accepted, got %v", err)
+	}
+
+	// An ask away from the band is rejected too
+	if _, err := exchange.Limit("AAPL", 120, 10, Ask, 4); !collarRejected(err) {
+		t.Errorf("Expected the ask above the band to be rejected, got %v", err)
+	}
+
+	// The band follows the last trade
+	exchange.Limit("AAPL", 105, 1, Ask, 4)
+	if low, high, _ := exchange.PriceBand("AAPL"); low != 100 || high != 110 {
+		t.Errorf("Expected the price band to move to 100-110, got %d-%d", low, high)
+	}
+	if _, err := exchange.Limit("AAPL", 110, 1, Ask, 4); err != nil {
+		t.Errorf("Expected the ask within the moved band to be accepted, got %v", err)
+	}
+
+	// Other symbols are not collared, and removing the collar removes the checks
+	if _, err := exchange.Limit("MSFT", MaxPrice, 1, Ask, 4); err != nil {
+		t.Errorf("Expected an uncollared symbol to accept the order, got %v", err)
+	}
+	exchange.SetPriceCollar("AAPL", PriceCollar{})
+	if _, err := exchange.Limit("AAPL", 200, 1, Ask, 4); err != nil {
+		t.Errorf("Expected the order to be accepted once the collar is removed, got %v", err)
+	}
+}
+
+func TestPriceCollar_Clip(t *testing.T) {
+	var exchange Exchange
+	exchange.Init("Test Exchange", nil, SubscribeOptions{})
+	trade(&exchange, "AAPL", 1000)
+	exchange.SetPriceCollar("AAPL", PriceCollar{Ticks: 5, BasisPoints: 200, Clip: true})
+
+	// The wider band applies: 2% of 1000 is 20 ticks
+	if low, high, _ := exchange.PriceBand("AAPL"); low != 980 || high != 1020 {
+		t.Errorf("Expected the price band 980-1020, got %d-%d", low, high)
+	}
+
+	// A bid above the band (and an ask below it) is clipped to the edge of the band
+	bid, err := exchange.Limit("AAPL", MaxPrice, 10, Bid, 3)
+	if err != nil {
+		t.Errorf("Expected the bid to be clipped, got %v", err)
+	}
+	if top := exchange.BBO("AAPL"); top.BidPrice != 1020 {
+		t.Errorf("Expected the bid to rest at 1020, got %d", top.BidPrice)
+	}
+	exchange.Cancel(bid)
+	if _, err := exchange.Limit("AAPL", MinPrice, 10, Ask, 4); err != nil {
+		t.Errorf("Expected the ask to be clipped, got %v", err)
+	}
+	if top := exchange.BBO("AAPL"); top.AskPrice != 980 {
+		t.Errorf("Expected the ask to rest at 980, got %d", top.AskPrice)
+	}
+
+	// A bid below the band cannot be clipped without making it more aggressive, so is rejected
+	if _, err := exchange.Limit("AAPL", 900, 10, Bid, 3); !collarRejected(err) {
+		t.Errorf("Expected the passive bid below the band to be rejected, got %v", err)
+	}
+}
+
+func TestPriceCollar_Amend(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+	exchange.SetPriceCollar("AAPL", PriceCollar{Ticks: 5})
+	trade(&exchange, "AAPL", 100)
+	ask, _ := exchange.Limit("AAPL", 104, 10, Ask, 3)
+	drainOrderActions(&exchange, actions)
+
+	// An amendment to a price outside the band is rejected, leaving the order resting unchanged
+	if err := exchange.Modify(ask, 1, 5); !collarRejected(err) {
+		t.Errorf("Expected the amendment below the band to be rejected, got %v", err)
+	}
+	got := drainOrderActions(&exchange, actions)
+	if len(got) != 1 || got[0].action_type != ActionReplaceReject || got[0].RejectReason() != RejectPriceCollar {
+		t.Errorf("Expected a price collar replace reject action, got %v", got)
+	}
+	if top := exchange.BBO("AAPL"); top.AskPrice != 104 || top.AskSize != 10 {
+		t.Errorf("Expected the ask to rest unchanged at 104, got %d at %d", top.AskSize, top.AskPrice)
+	}
+	if err := exchange.Modify(ask, 104, 5); err != nil {
+		t.Errorf("Expected a size decrease within the band to be accepted, got %v", err)
+	}
+
+	// With a clipping collar, an amendment to an aggressive price is clipped to the edge of the band
+	exchange.Cancel(ask)
+	exchange.SetPriceCollar("AAPL", PriceCollar{Ticks: 5, Clip: true})
+	bid, _ := exchange.Limit("AAPL", 96, 10, Bid, 3)
+	if err := exchange.Modify(bid, MaxPrice, 10); err != nil {
+		t.Errorf("Expected the amendment to be clipped, got %v", err)
+	}
+	if top := exchange.BBO("AAPL"); top.BidPrice != 105 {
+		t.Errorf("Expected the bid to rest at 105, got %d", top.BidPrice)
+	}
+}
+
+func TestPriceCollar_Midpoint(t *testing.T) {
+	var exchange Exchange
+	exchange.Init("Test Exchange", nil, SubscribeOptions{})
+	exchange.Limit("AAPL", 90, 10, Bid, 1)
+	exchange.Limit("AAPL", 110, 10, Ask, 2)
+	exchange.SetPriceCollar("AAPL", PriceCollar{Ticks: 5, Reference: ReferenceMidpoint})
+
+	// The band is centred on the midpoint, moving as the top of book changes
+	if _, err := exchange.Limit("AAPL", 106, 1, Bid, 3); !collarRejected(err) {
+		t.Errorf("Expected the bid above the band to be rejected, got %v", err)
+	}
+	exchange.Limit("AAPL", 104, 1, Bid, 3)
+	if low, high, _ := exchange.PriceBand("AAPL"); low != 102 || high != 112 {
+		t.Errorf("Expected the price band 102-112, got %d-%d", low, high)
+	}
+
+	// The last trade reference falls back to the midpoint before the first trade
+	exchange.SetPriceCollar("AAPL", PriceCollar{Ticks: 5, Reference: ReferenceLastTrade})
+	if low, high, _ := exchange.PriceBand("AAPL"); low != 102 || high != 112 {
+		t.Errorf("Expected the price band 102-112, got %d-%d", low, high)
+	}
+}
+
+func TestPriceCollar_Snapshot(t *testing.T) {
+	var live Exchange
+	live.Init("Live Exchange", nil, SubscribeOptions{})
+	live.SetPriceCollar("AAPL", PriceCollar{Ticks: 5})
+	trade(&live, "AAPL", 100)
+
+	var snapshot bytes.Buffer
+	if err := live.Snapshot(&snapshot); err != nil {
+		t.Fatalf("Expected to take a snapshot, got %v", err)
+	}
+	var restored Exchange
+	restored.Init("Restored Exchange", nil, SubscribeOptions{})
+	if err := restored.Restore(&snapshot); err != nil {
+		t.Fatalf("Expected to restore the snapshot, got %v", err)
+	}
+
+	// The collar and its reference price are restored
+	if low, high, ok := restored.PriceBand("AAPL"); !ok || low != 95 || high != 105 {
+		t.Errorf("Expected the restored price band 95-105, got %d-%d (%v)", low, high, ok)
+	}
+	if _, err := restored.Limit("AAPL", 106, 1, Bid, 3); !collarRejected(err) {
+		t.Errorf("Expected the restored collar to reject the bid, got %v", err)
+	}
+}
@@ -0,0 +1,436 @@
+package exchange
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// CommandType represents the type of an inbound command processed by the exchange
+type CommandType uint8
+
+// Define the command types, one per state changing entry point of the exchange
+const (
+	CommandSubmit CommandType = iota
+	CommandMarket
+	CommandCancel
+	CommandModify
+	CommandCancelByClientOrderID
+	CommandModifyByClientOrderID
+	CommandMassCancel
+	CommandExpireOrders
+	CommandEndSession
+	CommandSetMarketProtection
+	CommandSetPriceCollar
+	CommandSetRiskLimits
+)
+
+// String returns a human readable representation of the command type
+func (commandType CommandType) String() string {
+	switch commandType {
+	case CommandSubmit:
+		return "SUBMIT"
+	case CommandMarket:
+		return "MARKET"
+	case CommandCancel:
+		return "CANCEL"
+	case CommandModify:
+		return "MODIFY"
+	case CommandCancelByClientOrderID:
+		return "CANCEL BY CLIENT ORDER ID"
+	case CommandModifyByClientOrderID:
+		return "MODIFY BY CLIENT ORDER ID"
+	case CommandMassCancel:
+		return "MASS CANCEL"
+	case CommandExpireOrders:
+		return "EXPIRE ORDERS"
+	case CommandEndSession:
+		return "END SESSION"
+	case CommandSetMarketProtection:
+		return "SET MARKET PROTECTION"
+	case CommandSetPriceCollar:
+		return "SET PRICE COLLAR"
+	case CommandSetRiskLimits:
+		return "SET RISK LIMITS"
+	default:
+		return fmt.Sprintf("CommandType(%d)", uint8(commandType))
+	}
+}
+
+// Command represents an inbound command, as sequenced (and journalled) by the exchange
+// Commands are processed one at a time in Sequence order, so processing them again in order reproduces the exchange
+type Command struct {
+	Sequence   uint64
+	Time       int64 // Time the command was received (or the expiry time, for CommandExpireOrders), in nanoseconds since the Unix epoch
+	Type       CommandType
+	OrderID    OrderID          // Assigned OrderID (Submit, Market; zero if rejected), or the order to cancel or modify
+	Request    OrderRequest     // Order request (Submit, Market), new price and size (Modify), and trader and client order ID (ByClientOrderID)
+	Filter     MassCancelFilter // Orders to cancel (MassCancel)
+	Protection Price            // Market order protection band, in ticks (SetMarketProtection)
+	Collar     PriceCollar      // Price collar of the symbol in the request (SetPriceCollar)
+	Limits     RiskLimits       // Risk limits of the trader in the request, or the default limits for trader zero (SetRiskLimits)
+}
+
+// commandResult represents the outcome of processing a command, returned to the caller of the entry point
+type commandResult struct {
+	orderID   OrderID // Assigned OrderID (Submit, Market)
+	cancelled int     // Number of orders cancelled (MassCancel)
+	err       error
+}
+
+// execute sequences an inbound command and processes it, one command at a time
+// The command is journalled (if a journal is attached) before it takes effect
+// Its actions are delivered to the consumers once it has been processed, without holding up the next command's processing
+func (ex *Exchange) execute(cmd *Command) commandResult {
+	// Lock the command mutex, so that commands are processed one at a time in sequence order
+	ex.commandMutex.Lock()
+	result := ex.process(cmd)
+
+	// Take the deliver mutex before releasing the command mutex, so each command's actions are delivered in order
+	ex.deliverMutex.Lock()
+	actions, subscribers := ex.takeStaged()
+	ex.commandMutex.Unlock()
+	ex.deliver(actions, subscribers)
+	ex.deliverMutex.Unlock()
+	return result
+}
+
+// process sequences and processes a command. The command mutex must be held by the caller
+func (ex *Exchange) process(cmd *Command) commandResult {
+	// Refuse every command once the journal has failed, as they could not be recovered
+	if ex.journalErr != nil {
+		return commandResult{err: ex.journalErr}
+	}
+
+	ex.commandSequence += 1
+	cmd.Sequence = ex.commandSequence
+	if cmd.Time == 0 {
+		cmd.Time = time.Now().UnixNano()
+	}
+	result := ex.apply(cmd)
+	ex.recordHash(cmd.Sequence)
+	return result
+}
+
+// apply processes a sequenced command. The command mutex must be held by the caller
+func (ex *Exchange) apply(cmd *Command) commandResult {
+	// Reject a command with a field too long, journalled with the field clipped so it can still be encoded (and replayed)
+	if !validLengths(cmd) {
+		clipLengths(cmd)
+		if err := ex.journalCommand(cmd); err != nil {
+			return commandResult{err: err}
+		}
+		return commandResult{err: ex.rejectTooLong(cmd)}
+	}
+
+	// Order entry is journalled once the OrderID has been assigned, but before matching
+	switch cmd.Type {
+	case CommandSubmit:
+		orderID, err := ex.submit(cmd)
+		return commandResult{orderID: orderID, err: err}
+	case CommandMarket:
+		orderID, err := ex.market(cmd)
+		return commandResult{orderID: orderID, err: err}
+	}
+
+	// Every other command is journalled before it takes effect
+	if err := ex.journalCommand(cmd); err != nil {
+		return commandResult{err: err}
+	}
+
+	switch cmd.Type {
+	case CommandCancel:
+		return commandResult{err: ex.cancel(cmd.OrderID)}
+	case CommandModify:
+		return commandResult{err: ex.modify(cmd.OrderID, cmd.Request.Price, cmd.Request.Size)}
+	case CommandCancelByClientOrderID:
+		return commandResult{err: ex.cancelByClientOrderID(cmd.Request.Trader, cmd.Request.ClientOrderID)}
+	case CommandModifyByClientOrderID:
+		return commandResult{err: ex.modifyByClientOrderID(cmd.Request.Trader, cmd.Request.ClientOrderID, cmd.Request.Price, cmd.Request.Size)}
+	case CommandMassCancel:
+		cancelled, err := ex.massCancel(cmd.Filter)
+		return commandResult{cancelled: cancelled, err: err}
+	case CommandExpireOrders:
+		ex.expireWhere(func(order *Order) bool {
+			return order.tif == GTD && order.expiry <= cmd.Time
+		})
+	case CommandEndSession:
+		ex.expireWhere(func(order *Order) bool {
+			return order.tif == DAY
+		})
+	case CommandSetMarketProtection:
+		ex.setMarketProtection(cmd.Protection)
+	case CommandSetPriceCollar:
+		ex.setPriceCollar(cmd.Request.Symbol, cmd.Collar)
+	case CommandSetRiskLimits:
+		ex.setRiskLimits(cmd.Request.Trader, cmd.Limits)
+	}
+	return commandResult{}
+}
+
+// journalCommand appends the command to the journal (if one is attached), and replicates it to the backup (if any)
+// A failed append stops the exchange from accepting further commands
+func (ex *Exchange) journalCommand(cmd *Command) error {
+	if ex.journal != nil {
+		if err := ex.journal.Append(cmd); err != nil {
+			ex.journalErr = fmt.Errorf("exchange: journal: %w", err)
+			return ex.journalErr
+		}
+	}
+
+	// Wait until the backup has processed the command, so it is not lost if the exchange fails
+	if ex.primary != nil {
+		ex.primary.replicate(cmd)
+	}
+	return nil
+}
+
+// recordHash records the hash of the actions published after processing the command with the given sequence number,
+// in the journal and to the backup, so a replay of the command can be verified
+func (ex *Exchange) recordHash(sequence uint64) {
+	hash := ex.ActionHash()
+	if ex.journal != nil && ex.journalErr == nil {
+		if err := ex.journal.appendHash(sequence, hash); err != nil {
+			ex.journalErr = fmt.Errorf("exchange: journal: %w", err)
+		}
+	}
+	if ex.primary != nil {
+		ex.primary.replicateHash(sequence, hash)
+	}
+}
+
+// validLengths returns whether the symbols and client order ID of the command are within their maximum lengths
+func validLengths(cmd *Command) bool {
+	return len(cmd.Request.Symbol) <= MaxSymbolLength &&
+		len(cmd.Filter.Symbol) <= MaxSymbolLength &&
+		len(cmd.Request.ClientOrderID) <= MaxClientOrderIDLength
+}
+
+// clipLengths clips the symbols and client order ID of the command to one byte over their maximum lengths,
+// so they can be encoded, but are still rejected as too long when replayed
+func clipLengths(cmd *Command) {
+	cmd.Request.Symbol = clip(cmd.Request.Symbol, MaxSymbolLength+1)
+	cmd.Filter.Symbol = clip(cmd.Filter.Symbol, MaxSymbolLength+1)
+	cmd.Request.ClientOrderID = clip(cmd.Request.ClientOrderID, MaxClientOrderIDLength+1)
+}
+
+// clip returns the first n bytes of s (or s, if shorter)
+func clip(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// rejectTooLong reports the rejection of a command with a symbol or client order ID too long (as clipped by clipLengths)
+// to the exchange via the actions channel, returning the error for the caller
+// A price collar for a symbol too long is not reported, as it is not an order command (and no orders can be entered in the symbol)
+func (ex *Exchange) rejectTooLong(cmd *Command) error {
+	req := cmd.Request
+	order := Order{symbol: req.Symbol, price: req.Price, size: req.Size, side: req.Side, trader: req.Trader, clientOrderID: req.ClientOrderID}
+	switch cmd.Type {
+	case CommandSubmit, CommandMarket:
+		ex.publish(newOrderRejectAction(&order, RejectTooLong))
+	case CommandCancelByClientOrderID:
+		ex.publish(newCancelRejectAction(&order, RejectTooLong))
+	case CommandModifyByClientOrderID:
+		ex.publish(newReplaceRejectAction(&order, RejectTooLong))
+	case CommandMassCancel:
+		filter := cmd.Filter
+		ex.publish(newCancelRejectAction(&Order{symbol: filter.Symbol, side: filter.Side, trader: filter.Trader}, RejectTooLong))
+	}
+	return &RejectError{Reason: RejectTooLong}
+}
+
+// commandVersion is the version of the binary command encoding, written at the start of each encoded command
+// Version 2 added the price collar, and version 3 the risk limits; older commands are still decoded
+const commandVersion uint8 = 3
+
+// errCommandEncoding is returned when decoding a truncated or unsupported command
+var errCommandEncoding = errors.New("exchange: invalid command encoding")
+
+// appendCommand appends the binary encoding of the command to buf (little endian, strings prefixed by their length)
+func appendCommand(buf []byte, cmd *Command) []byte {
+	expireAt := int64(0)
+	if !cmd.Request.ExpireAt.IsZero() {
+		expireAt = cmd.Request.ExpireAt.UnixNano()
+	}
+
+	buf = append(buf, commandVersion)
+	buf = binary.LittleEndian.AppendUint64(buf, cmd.Sequence)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(cmd.Time))
+	buf = append(buf, uint8(cmd.Type))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(cmd.OrderID))
+
+	// The order request
+	buf = appendString(buf, cmd.Request.Symbol)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(cmd.Request.Price))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(cmd.Request.Size))
+	buf = append(buf, uint8(cmd.Request.Side))
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(cmd.Request.Trader))
+	buf = append(buf, uint8(cmd.Request.TimeInForce))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(expireAt))
+	buf = appendString(buf, cmd.Request.ClientOrderID)
+
+	// The mass cancel filter
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(cmd.Filter.Trader))
+	buf = appendString(buf, cmd.Filter.Symbol)
+	buf = append(buf, uint8(cmd.Filter.Side))
+	buf = appendBool(buf, cmd.Filter.BySide)
+
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(cmd.Protection))
+
+	// The price collar
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(cmd.Collar.Ticks))
+	buf = binary.LittleEndian.AppendUint32(buf, cmd.Collar.BasisPoints)
+	buf = append(buf, uint8(cmd.Collar.Reference))
+	buf = appendBool(buf, cmd.Collar.Clip)
+
+	return appendRiskLimits(buf, cmd.Limits)
+}
+
+// decodeCommand decodes a command encoded by appendCommand
+func decodeCommand(buf []byte) (Command, error) {
+	var cmd Command
+	d := decoder{buf: buf}
+
+	version := d.uint8()
+	if version < 1 || version > commandVersion {
+		return cmd, errCommandEncoding
+	}
+	cmd.Sequence = d.uint64()
+	cmd.Time = int64(d.uint64())
+	cmd.Type = CommandType(d.uint8())
+	cmd.OrderID = OrderID(d.uint64())
+
+	cmd.Request.Symbol = d.string()
+	cmd.Request.Price = Price(d.uint32())
+	cmd.Request.Size = Size(d.uint32())
+	cmd.Request.Side = Side(d.uint8())
+	cmd.Request.Trader = TraderID(d.uint16())
+	cmd.Request.TimeInForce = TimeInForce(d.uint8())
+	if expireAt := int64(d.uint64()); expireAt != 0 {
+		cmd.Request.ExpireAt = time.Unix(0, expireAt)
+	}
+	cmd.Request.ClientOrderID = d.string()
+
+	cmd.Filter.Trader = TraderID(d.uint16())
+	cmd.Filter.Symbol = d.string()
+	cmd.Filter.Side = Side(d.uint8())
+	cmd.Filter.BySide = d.bool()
+
+	cmd.Protection = Price(d.uint32())
+
+	if version >= 2 {
+		cmd.Collar.Ticks = Price(d.uint32())
+		cmd.Collar.BasisPoints = d.uint32()
+		cmd.Collar.Reference = CollarReference(d.uint8())
+		cmd.Collar.Clip = d.bool()
+	}
+	if version >= 3 {
+		cmd.Limits = decodeRiskLimits(&d)
+	}
+
+	if d.err != nil || len(d.buf) != 0 {
+		return Command{}, errCommandEncoding
+	}
+	return cmd, nil
+}
+
+// appendRiskLimits appends the encoding of the risk limits to buf
+func appendRiskLimits(buf []byte, limits RiskLimits) []byte {
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(limits.MaxOrderSize))
+	buf = binary.LittleEndian.AppendUint64(buf, limits.MaxNotional)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(limits.MaxOpenOrders))
+	buf = binary.LittleEndian.AppendUint64(buf, limits.MaxGrossExposure)
+	return binary.LittleEndian.AppendUint64(buf, limits.MaxNetExposure)
+}
+
+// decodeRiskLimits decodes risk limits encoded by appendRiskLimits
+func decodeRiskLimits(d *decoder) RiskLimits {
+	return RiskLimits{
+		MaxOrderSize:     Size(d.uint32()),
+		MaxNotional:      d.uint64(),
+		MaxOpenOrders:    int(int64(d.uint64())),
+		MaxGrossExposure: d.uint64(),
+		MaxNetExposure:   d.uint64(),
+	}
+}
+
+// appendString appends a string, prefixed by its (uint16) length
+func appendString(buf []byte, s string) []byte {
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(s)))
+	return append(buf, s...)
+}
+
+// appendBool appends a bool as a single byte
+func appendBool(buf []byte, b bool) []byte {
+	if b {
+		return append(buf, 1)
+	}
+	return append(buf, 0)
+}
+
+// decoder reads little endian values from a buffer, recording an error (and returning zero values) once it runs out
+type decoder struct {
+	buf []byte
+	err error
+}
+
+// take returns the next n bytes of the buffer, or nil if fewer remain
+func (d *decoder) take(n int) []byte {
+	if d.err != nil || len(d.buf) < n {
+		d.err = errCommandEncoding
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+// uint8 reads a uint8
+func (d *decoder) uint8() uint8 {
+	if b := d.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+// uint16 reads a uint16
+func (d *decoder) uint16() uint16 {
+	if b := d.take(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+// uint32 reads a uint32
+func (d *decoder) uint32() uint32 {
+	if b := d.take(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+// uint64 reads a uint64
+func (d *decoder) uint64() uint64 {
+	if b := d.take(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+// bool reads a bool (a single byte)
+func (d *decoder) bool() bool {
+	return d.uint8() != 0
+}
+
+// string reads a string (prefixed by its uint16 length)
+func (d *decoder) string() string {
+	n := int(d.uint16())
+	if b := d.take(n); b != nil {
+		return string(b)
+	}
+	return ""
+}
@@ -0,0 +1,125 @@
+package exchange
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandEncoding(t *testing.T) {
+	commands := []Command{
+		{
+			Sequence: 1, Time: 1_700_000_000_000_000_000, Type: CommandSubmit, OrderID: 42,
+			Request: OrderRequest{
+				Symbol: "AAPL", Price: 150, Size: 10, Side: Ask, Trader: 7,
+				TimeInForce: GTD, ExpireAt: time.Unix(0, 1_700_000_060_000_000_000), ClientOrderID: "C1",
+			},
+		},
+		{Sequence: 2, Type: CommandModify, OrderID: 42, Request: OrderRequest{Price: 151, Size: 5}},
+		{Sequence: 3, Type: CommandMassCancel, Filter: MassCancelFilter{Trader: 7, Symbol: "AAPL", Side: Ask, BySide: true}},
+		{Sequence: 4, Type: CommandSetMarketProtection, Protection: 25},
+		{Sequence: 5, Type: CommandSetPriceCollar, Request: OrderRequest{Symbol: "AAPL"}, Collar: PriceCollar{Ticks: 5, BasisPoints: 250, Reference: ReferenceMidpoint, Clip: true}},
+		{Sequence: 6, Type: CommandSetRiskLimits, Request: OrderRequest{Trader: 7}, Limits: RiskLimits{MaxOrderSize: 100, MaxNotional: 1_000_000, MaxOpenOrders: 5, MaxGrossExposure: 500, MaxNetExposure: 300}},
+	}
+
+	for _, cmd := range commands {
+		decoded, err := decodeCommand(appendCommand(nil, &cmd))
+		if err != nil {
+			t.Fatalf("Expected %v to decode, got %v", cmd.Type, err)
+		}
+		if decoded.Request.ExpireAt.UnixNano() != cmd.Request.ExpireAt.UnixNano() {
+			t.Errorf("Expected expiry %v, got %v", cmd.Request.ExpireAt, decoded.Request.ExpireAt)
+		}
+		decoded.Request.ExpireAt, cmd.Request.ExpireAt = time.Time{}, time.Time{}
+		if decoded != cmd {
+			t.Errorf("Expected %+v, got %+v", cmd, decoded)
+		}
+	}
+
+	// Truncated and unknown version encodings are rejected
+	encoded := appendCommand(nil, &commands[0])
+	if _, err := decodeCommand(encoded[:len(encoded)-1]); err != errCommandEncoding {
+		t.Errorf("Expected a truncated command to fail, got %v", err)
+	}
+	encoded[0] = commandVersion + 1
+	if _, err := decodeCommand(encoded); err != errCommandEncoding {
+		t.Errorf("Expected an unknown version to fail, got %v", err)
+	}
+
+	// Version 1 commands (without the price collar) and version 2 commands (without the risk limits) are still decoded
+	limitsLength := len(appendRiskLimits(nil, RiskLimits{}))
+	encoded = appendCommand(nil, &commands[3])
+	encoded[0] = 1
+	if decoded, err := decodeCommand(encoded[:len(encoded)-limitsLength-10]); err != nil || decoded != commands[3] {
+		t.Errorf("Expected a version 1 command to decode as %+v, got %+v (%v)", commands[3], decoded, err)
+	}
+	encoded = appendCommand(nil, &commands[4])
+	encoded[0] = 2
+	if decoded, err := decodeCommand(encoded[:len(encoded)-limitsLength]); err != nil || decoded != commands[4] {
+		t.Errorf("Expected a version 2 command to decode as %+v, got %+v (%v)", commands[4], decoded, err)
+	}
+}
+
+func TestCommandTypeString(t *testing.T) {
+	if CommandSubmit.String() != "SUBMIT" || CommandSetMarketProtection.String() != "SET MARKET PROTECTION" {
+		t.Errorf("Expected the command type names, got %v and %v", CommandSubmit, CommandSetMarketProtection)
+	}
+	if CommandType(99).String() != "CommandType(99)" {
+		t.Errorf("Expected an unknown command type to be numbered, got %v", CommandType(99))
+	}
+}
+
+func TestExchange_CommandTooLong(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "exchange.journal")
+	journal, err := OpenJournal(path, JournalOptions{Sync: SyncEveryRecord})
+	if err != nil {
+		t.Fatalf("Expected to open the journal, got %v", err)
+	}
+	actions := make(chan *Action, ChanSize)
+	var live Exchange
+	live.Init("Live Exchange", actions, SubscribeOptions{})
+	live.SetJournal(journal)
+
+	// Symbols and client order IDs too long are rejected, with a reject action for each
+	var rejectErr *RejectError
+	if _, err := live.Limit(strings.Repeat("A", 70_000), 100, 10, Bid, 1); !errors.As(err, &rejectErr) || rejectErr.Reason != RejectTooLong {
+		t.Errorf("Expected the long symbol to be refused, got %v", err)
+	}
+	long_id := strings.Repeat("c", MaxClientOrderIDLength+1)
+	if _, err := live.Submit(OrderRequest{Symbol: "AAPL", Price: 100, Size: 10, Side: Bid, Trader: 1, ClientOrderID: long_id}); !errors.As(err, &rejectErr) || rejectErr.Reason != RejectTooLong {
+		t.Errorf("Expected the long client order ID to be refused, got %v", err)
+	}
+	if err := live.CancelByClientOrderID(1, long_id); !errors.As(err, &rejectErr) || rejectErr.Reason != RejectTooLong {
+		t.Errorf("Expected the cancel by long client order ID to be refused, got %v", err)
+	}
+	if _, err := live.MassCancel(MassCancelFilter{Symbol: strings.Repeat("B", MaxSymbolLength+1)}); !errors.As(err, &rejectErr) || rejectErr.Reason != RejectTooLong {
+		t.Errorf("Expected the mass cancel of a long symbol to be refused, got %v", err)
+	}
+	got := drainActions(&live, actions)
+	want := []ActionType{ActionOrderReject, ActionOrderReject, ActionCancelReject, ActionCancelReject}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d reject actions, got %v", len(want), got)
+	}
+	for i, action := range got {
+		if action.action_type != want[i] || action.RejectReason() != RejectTooLong {
+			t.Errorf("Expected a %v too long, got %v", want[i], action)
+		}
+	}
+	if len(got[0].order.symbol) != MaxSymbolLength+1 || got[1].order.clientOrderID != long_id {
+		t.Errorf("Expected the rejected fields, clipped to one byte too long, got %v and %v", got[0].order.symbol, got[1].order.clientOrderID)
+	}
+
+	// The rejected commands are journalled (with the long fields clipped), and replay along with the commands after them
+	orderID, _ := live.Limit("AAPL", 100, 10, Bid, 1)
+	journal.Close()
+	var replayed Exchange
+	replayed.Init("Replayed Exchange", nil, SubscribeOptions{})
+	if err := replayJournal(t, &replayed, path); err != nil {
+		t.Fatalf("Expected the journal to replay, got %v", err)
+	}
+	if _, ok := replayed.OrderStatus(orderID); !ok || replayed.commandSequence != 5 || replayed.ActionHash() != live.ActionHash() {
+		t.Errorf("Expected the replay to match the live exchange")
+	}
+}
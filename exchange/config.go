@@ -8,4 +8,10 @@ const (
 	EstNumOrders  Size  = 1_000_000 // Rough estimate of number of orders (to pre-allocate orderIDMap)
 	EstNumSymbols Size  = 1_000     // Rough estimate of number of symbols (to pre-allocate orderbooksMap)
 	ChanSize      Size  = 10_000    // Channel buffer size
+	QueueSize     Size  = 100_000   // Publishing queue size, per consumer (in addition to its channel buffer)
+
+	ClosedOrderRetention Size = 100_000 // Number of closed orders retained, for order status queries and reject reasons
+
+	MaxSymbolLength        = 32 // Longest symbol accepted in a command (in bytes)
+	MaxClientOrderIDLength = 64 // Longest client order ID accepted in a command (in bytes)
 )
@@ -20,4 +20,7 @@ func TestConstants(t *testing.T) {
 	if ChanSize != 10_000 {
 		t.Errorf("Expected CHAN_SIZE to be 10000, got %d", ChanSize)
 	}
+	if ClosedOrderRetention != 100_000 {
+		t.Errorf("Expected CLOSED_ORDER_RETENTION to be 100000, got %d", ClosedOrderRetention)
+	}
 }
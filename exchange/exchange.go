@@ -1,47 +1,218 @@
 package exchange
 
 import (
+	"cmp"
 	"fmt"
+	"slices"
 	"sync"
+	"time"
 )
 
 // Exchange represents the exchange engine, that stores the orderbooks (per symbol) and manages the orders
 type Exchange struct {
-	name           string
-	orderbooksMap  map[string]*OrderBook
-	currentOrderID OrderID
-	orderIDMap     map[OrderID]Order // Could consider struct composing; only need trader + size
-	actions        chan *Action
-	mutex          sync.RWMutex
+	name            string
+	orderbooksMap   map[string]*OrderBook
+	currentOrderID  OrderID
+	currentTradeID  TradeID
+	orderIDMap      map[OrderID]*orderNode // Resting orders, linked into their PricePoint queues
+	actions         chan *Action
+	protection      Price                           // Maximum ticks a market order may trade through the opposite best price (0 = unprotected)
+	collars         map[string]PriceCollar          // Price collars of the incoming limit orders, per symbol
+	closedOrders    map[OrderID]OrderStatus         // Recently closed orders, with their final status
+	closedRing      []OrderID                       // Closed orders in closing order, to bound the closedOrders retention
+	closedNext      int                             // Next position in closedRing to be overwritten once it is full
+	clientOrderIDs  map[TraderID]map[string]OrderID // Working orders per trader, indexed by their client order ID
+	sequence        uint64                          // Sequence number of the last action published by the exchange
+	commandSequence uint64                          // Sequence number of the last command processed
+	actionHash      uint64                          // Running hash of the published actions, to verify a replay
+	hashBuf         []byte                          // Reused encoding buffer for the action hash
+	journal         *Journal                        // Journal the commands are recorded in before they take effect (if any)
+	journalErr      error                           // First failure to journal a command, after which commands are refused
+	primary         *Primary                        // Replicates the commands to a backup, when running as a primary (if any)
+	risk            *RiskManager                    // Pre-trade risk checks of the incoming orders (if any)
+	riskDefaults    RiskLimits                      // Risk limits of the traders without limits of their own
+	riskLimits      map[TraderID]RiskLimits         // Risk limits of the traders with limits of their own
+	commandMutex    sync.Mutex                      // Serialises the commands, so they are processed (and journalled) in sequence order
+	subscribers     []*subscriber                   // Consumers of the published actions, in subscription order
+	staged          []*Action                       // Actions published by the current command, awaiting delivery to the consumers
+	subscriberID    int                             // ID of the last subscriber
+	disconnects     uint64                          // Number of subscribers disconnected as slow consumers
+	publishMutex    sync.Mutex                      // Serialises publishing, so sequence numbers follow the order actions are staged
+	deliverMutex    sync.Mutex                      // Serialises delivery, so the consumers receive each command's actions in order
+	mutex           sync.RWMutex
 }
 
 // Init initialises the exchange with the given name and actions channel, and establishes the order storage
-func (ex *Exchange) Init(name string, actions chan *Action) {
+// The actions channel may be nil, if every consumer uses Subscribe instead. Otherwise it receives every action,
+// queued as configured by the options (the BufferSize is ignored, as the channel is created by the caller)
+func (ex *Exchange) Init(name string, actions chan *Action, options SubscribeOptions) {
 	// Lock the exchange mutex to prevent concurrent access
 	ex.mutex.Lock()
 	defer ex.mutex.Unlock()
 
 	ex.name = name
 	ex.currentOrderID = 0
+	ex.currentTradeID = 0
 
 	// Pre-allocate the maps to avoid resizing based on estimated values (in config)
 	ex.orderbooksMap = make(map[string]*OrderBook, EstNumSymbols)
-	ex.orderIDMap = make(map[OrderID]Order, EstNumOrders)
+	ex.orderIDMap = make(map[OrderID]*orderNode, EstNumOrders)
+	ex.closedOrders = make(map[OrderID]OrderStatus, ClosedOrderRetention)
+	ex.closedRing = make([]OrderID, 0, ClosedOrderRetention)
+	ex.closedNext = 0
+	ex.clientOrderIDs = make(map[TraderID]map[string]OrderID)
+	ex.collars = make(map[string]PriceCollar)
+	ex.riskDefaults = RiskLimits{}
+	ex.riskLimits = make(map[TraderID]RiskLimits)
 
 	ex.actions = actions
+	ex.sequence = 0
+	ex.commandSequence = 0
+	ex.actionHash = hashOffset
+
+	// The actions channel is the first consumer, receiving every action
+	if options.QueueSize <= 0 {
+		options.QueueSize = int(QueueSize)
+	}
+	if actions != nil {
+		ex.subscribers = []*subscriber{newSubscriber(0, SubscriptionFilter{}, options.Policy, options.QueueSize, actions, false)}
+	}
 
 	// Report the exchange is ready to accept orders via STDOUT
 	fmt.Println("Exchange started:", ex.name, "- Ready to accept orders")
 }
 
-// getNextOrderID returns the next available order ID in the exchange and increments the counter
-func (ex *Exchange) getNextOrderID() OrderID {
+// SetMarketProtection sets the protection band for market orders, as a number of ticks through the opposite best price
+// A market order will not trade beyond this band; any remainder is cancelled. Zero disables the protection
+func (ex *Exchange) SetMarketProtection(ticks Price) {
+	ex.execute(&Command{Type: CommandSetMarketProtection, Protection: ticks})
+}
+
+// setMarketProtection sets the protection band for market orders (in ticks)
+func (ex *Exchange) setMarketProtection(ticks Price) {
 	// Lock the exchange mutex to prevent concurrent access
 	ex.mutex.Lock()
 	defer ex.mutex.Unlock()
 
+	ex.protection = ticks
+}
+
+// getMarketProtection returns the current market order protection band (in ticks)
+func (ex *Exchange) getMarketProtection() Price {
+	// Lock the exchange mutex (for reading) to prevent concurrent access
+	ex.mutex.RLock()
+	defer ex.mutex.RUnlock()
+
+	return ex.protection
+}
+
+// nextTradeID returns the next available trade ID in the exchange and increments the counter
+// The exchange mutex must be held by the caller
+func (ex *Exchange) nextTradeID() TradeID {
+	ex.currentTradeID += 1
+	return ex.currentTradeID
+}
+
+// assignOrderID assigns the next OrderID to an accepted order, indexing it by its client order ID (if any)
+// Returns RejectDuplicateClientOrderID if the trader already has a working order with the same client order ID
+func (ex *Exchange) assignOrderID(order *Order) RejectReason {
+	// Lock the exchange mutex to prevent concurrent access
+	ex.mutex.Lock()
+	defer ex.mutex.Unlock()
+
+	if order.clientOrderID != "" {
+		if _, exists := ex.clientOrderIDs[order.trader][order.clientOrderID]; exists {
+			return RejectDuplicateClientOrderID
+		}
+	}
+
 	ex.currentOrderID += 1
-	return ex.currentOrderID
+	order.orderID = ex.currentOrderID
+
+	// Index the order by its client order ID, so it can be cancelled or amended by (TraderID, ClientOrderID)
+	if order.clientOrderID != "" {
+		if ex.clientOrderIDs[order.trader] == nil {
+			ex.clientOrderIDs[order.trader] = make(map[string]OrderID)
+		}
+		ex.clientOrderIDs[order.trader][order.clientOrderID] = order.orderID
+	}
+	return RejectNone
+}
+
+// lookupClientOrderID returns the OrderID of the trader's working order with the given client order ID
+func (ex *Exchange) lookupClientOrderID(trader TraderID, clientOrderID string) (OrderID, bool) {
+	// Lock the exchange mutex (for reading) to prevent concurrent access
+	ex.mutex.RLock()
+	defer ex.mutex.RUnlock()
+
+	orderID, ok := ex.clientOrderIDs[trader][clientOrderID]
+	return orderID, ok
+}
+
+// closeOrder records that an order is no longer working, with its final state
+func (ex *Exchange) closeOrder(order *Order, state OrderState) {
+	// Lock the exchange mutex to prevent concurrent access
+	ex.mutex.Lock()
+	defer ex.mutex.Unlock()
+
+	ex.recordClosed(order, state)
+}
+
+// recordClosed records a closed order, forgetting the oldest closed order once ClosedOrderRetention is reached
+// The order's client order ID is released, so it may be reused by the trader
+// The exchange mutex must be held by the caller
+func (ex *Exchange) recordClosed(order *Order, state OrderState) {
+	orderID := order.orderID
+
+	// Release the client order ID (only if it still refers to this order)
+	if order.clientOrderID != "" {
+		if index := ex.clientOrderIDs[order.trader]; index[order.clientOrderID] == orderID {
+			delete(index, order.clientOrderID)
+			if len(index) == 0 {
+				delete(ex.clientOrderIDs, order.trader)
+			}
+		}
+	}
+
+	if _, exists := ex.closedOrders[orderID]; exists {
+		ex.closedOrders[orderID] = order.status(state)
+		return
+	}
+	ex.risk.close(order)
+
+	// Grow the ring until full, then overwrite (and forget) the oldest closed order
+	if len(ex.closedRing) < int(ClosedOrderRetention) {
+		ex.closedRing = append(ex.closedRing, orderID)
+	} else {
+		delete(ex.closedOrders, ex.closedRing[ex.closedNext])
+		ex.closedRing[ex.closedNext] = orderID
+		ex.closedNext = (ex.closedNext + 1) % len(ex.closedRing)
+	}
+	ex.closedOrders[orderID] = order.status(state)
+}
+
+// lookupRejectReason returns the reason an action on an order that is not working should be rejected
+// The exchange mutex must be held by the caller
+func (ex *Exchange) lookupRejectReason(orderID OrderID) RejectReason {
+	if status, ok := ex.closedOrders[orderID]; ok {
+		if status.State == OrderFilled {
+			return RejectAlreadyFilled
+		}
+		return RejectAlreadyCancelled
+	}
+	return RejectUnknownOrder
+}
+
+// rejectedOrder returns the order reported back on the rejection of an action on an order that is not working
+// A recently closed order's trader and client order ID are echoed, so the client can match the reject to its own ID
+// The exchange mutex must be held by the caller
+func (ex *Exchange) rejectedOrder(orderID OrderID) Order {
+	order := Order{orderID: orderID}
+	if status, ok := ex.closedOrders[orderID]; ok {
+		order.trader = status.Trader
+		order.clientOrderID = status.ClientOrderID
+	}
+	return order
 }
 
 // getOrCreateOrderBook returns the orderbook for the given symbol, creating it if it doesn't exist
@@ -68,77 +239,455 @@ func (ex *Exchange) PreWarmWithSymbols(symbols []string) {
 }
 
 // validateOrder checks the incoming order for validity, ensuring the fields are within bounds
-// Used to prevent invalid orders from being processed
-func validateOrder(symbol string, price Price, size Size, side Side, trader TraderID) bool {
+// Used to prevent invalid orders from being processed. Returns RejectNone for a valid order
+func validateOrder(symbol string, price Price, size Size, side Side, trader TraderID) RejectReason {
 	if symbol == "" {
-		return false
+		return RejectEmptySymbol
 	}
 	if price < MinPrice || price > MaxPrice {
-		return false
+		return RejectPriceOutOfBand
 	}
 	if size <= 0 {
-		return false
+		return RejectZeroSize
 	}
 	if side != Bid && side != Ask {
-		return false
+		return RejectBadSide
 	}
 	// TraderID is not specifically validated, as it can be any positive integer
 	// This could be extended to check for a valid traderID from a database call or similar
 	if trader <= 0 {
-		return false
+		return RejectBadTrader
 	}
-	return true
+	return RejectNone
 }
 
-// Limit processes an incoming limit order, validating it and passing it to the appropriate orderbook
-func (ex *Exchange) Limit(symbol string, price Price, size Size, side Side, trader TraderID) {
-	// Validate the incoming order, rejecting if invalid
-	if !validateOrder(symbol, price, size, side, trader) {
-		// Report the rejection to the exchange via the actions channel
-		ex.actions <- newOrderRejectAction()
-		return
+// validateTimeInForce checks the incoming time in force instruction for validity
+// GTD orders must carry an expiry time after the time the order was received. Returns RejectNone for a valid instruction
+func validateTimeInForce(tif TimeInForce, expireAt time.Time, now time.Time) RejectReason {
+	if tif > GTD {
+		return RejectBadTimeInForce
+	}
+	if tif == GTD && !expireAt.After(now) {
+		return RejectBadTimeInForce
 	}
+	return RejectNone
+}
+
+// Limit processes an incoming good-till-cancel limit order
+// Returns the assigned OrderID, or a *RejectError if the order was rejected
+func (ex *Exchange) Limit(symbol string, price Price, size Size, side Side, trader TraderID) (OrderID, error) {
+	return ex.Submit(OrderRequest{
+		Symbol: symbol,
+		Price:  price,
+		Size:   size,
+		Side:   side,
+		Trader: trader,
+	})
+}
+
+// Submit processes an incoming limit order request, validating it and passing it to the appropriate orderbook
+// Returns the assigned OrderID, or a *RejectError if the order was rejected
+func (ex *Exchange) Submit(req OrderRequest) (OrderID, error) {
+	result := ex.execute(&Command{Type: CommandSubmit, Request: req})
+	return result.orderID, result.err
+}
+
+// submit processes an order request command, journalling it (with its assigned OrderID, if accepted) before matching
+func (ex *Exchange) submit(cmd *Command) (OrderID, error) {
+	req := cmd.Request
 
 	// Initialise the incoming order with the given values
 	incomingOrder := Order{
-		symbol: symbol,
-		price:  price,
-		size:   size,
-		side:   side,
-		trader: trader,
+		symbol:        req.Symbol,
+		price:         req.Price,
+		size:          req.Size,
+		side:          req.Side,
+		trader:        req.Trader,
+		tif:           req.TimeInForce,
+		clientOrderID: req.ClientOrderID,
+		original:      req.Size,
+	}
+	if req.TimeInForce == GTD {
+		incomingOrder.expiry = req.ExpireAt.UnixNano()
+	}
+
+	// Validate the incoming order, and then assign the OrderID (rejecting a duplicate client order ID for the trader)
+	reason := validateOrder(req.Symbol, req.Price, req.Size, req.Side, req.Trader)
+	if reason == RejectNone {
+		reason = validateTimeInForce(req.TimeInForce, req.ExpireAt, time.Unix(0, cmd.Time))
+	}
+
+	// Check a valid order against the symbol's price collar, clipping its price or rejecting it if outside the band
+	if reason == RejectNone {
+		incomingOrder.price, reason = ex.collarPrice(req.Symbol, req.Side, req.Price)
+	}
+
+	// Run the pre-trade risk checks on a valid order
+	var rule string
+	if reason == RejectNone {
+		if rule = ex.checkRisk(&incomingOrder); rule != "" {
+			reason = RejectRiskLimit
+		}
+	}
+	if reason == RejectNone {
+		reason = ex.assignOrderID(&incomingOrder)
+	}
+
+	// Journal the command, with the assigned OrderID (zero if rejected), before it takes effect
+	cmd.OrderID = incomingOrder.orderID
+	if err := ex.journalCommand(cmd); err != nil {
+		return 0, err
+	}
+
+	if reason != RejectNone {
+		return 0, ex.rejectOrder(&incomingOrder, reason, rule)
 	}
 
 	// Get or create the orderbook for the symbol and process the incoming order
+	ex.risk.accept(&incomingOrder)
 	ob := ex.getOrCreateOrderBook(incomingOrder.symbol)
-	incomingOrder.orderID = ex.getNextOrderID()
 	ob.limitHandle(incomingOrder)
+	return incomingOrder.orderID, nil
 }
 
-// Cancel processes an incoming cancel order, cancelling the order if it exists in the exchange
-func (ex *Exchange) Cancel(orderID OrderID) {
-	// Lock the exchange mutex to prevent concurrent access
+// Market processes an incoming market order, validating it and passing it to the appropriate orderbook
+// Market orders sweep the opposite side of the book and never rest; any unfilled remainder is cancelled
+// Returns the assigned OrderID, or a *RejectError if the order was rejected
+func (ex *Exchange) Market(symbol string, size Size, side Side, trader TraderID) (OrderID, error) {
+	return ex.SubmitMarket(OrderRequest{Symbol: symbol, Size: size, Side: side, Trader: trader})
+}
+
+// SubmitMarket processes an incoming market order request, with its optional client order ID
+// The request's price and time in force instructions are ignored, as market orders never rest
+// Returns the assigned OrderID, or a *RejectError if the order was rejected
+func (ex *Exchange) SubmitMarket(req OrderRequest) (OrderID, error) {
+	result := ex.execute(&Command{Type: CommandMarket, Request: req})
+	return result.orderID, result.err
+}
+
+// market processes a market order command, journalling it (with its assigned OrderID, if accepted) before matching
+func (ex *Exchange) market(cmd *Command) (OrderID, error) {
+	req := cmd.Request
+	symbol, size, side, trader := req.Symbol, req.Size, req.Side, req.Trader
+
+	// Market orders carry no price, so validate against the extreme price the order may sweep to
+	sweepPrice := MaxPrice
+	if side == Ask {
+		sweepPrice = MinPrice
+	}
+
+	// Initialise the incoming order with the given values
+	incomingOrder := Order{
+		symbol:        symbol,
+		price:         sweepPrice,
+		size:          size,
+		side:          side,
+		trader:        trader,
+		clientOrderID: req.ClientOrderID,
+		original:      size,
+	}
+
+	// Validate the incoming order, and then assign the OrderID (rejecting a duplicate client order ID for the trader)
+	reason := validateOrder(symbol, sweepPrice, size, side, trader)
+
+	// Run the pre-trade risk checks on a valid order, at the worst price it may trade at
+	var rule string
+	if reason == RejectNone && ex.risk != nil {
+		riskOrder := incomingOrder
+		riskOrder.price = ex.marketRiskPrice(symbol, side)
+		if rule = ex.checkRisk(&riskOrder); rule != "" {
+			reason = RejectRiskLimit
+		}
+	}
+	if reason == RejectNone {
+		reason = ex.assignOrderID(&incomingOrder)
+	}
+
+	// Journal the command, with the assigned OrderID (zero if rejected), before it takes effect
+	cmd.OrderID = incomingOrder.orderID
+	if err := ex.journalCommand(cmd); err != nil {
+		return 0, err
+	}
+
+	if reason != RejectNone {
+		return 0, ex.rejectOrder(&incomingOrder, reason, rule)
+	}
+
+	// Get or create the orderbook for the symbol and process the incoming order
+	ex.risk.accept(&incomingOrder)
+	ob := ex.getOrCreateOrderBook(incomingOrder.symbol)
+	ob.marketHandle(incomingOrder, ex.getMarketProtection())
+	return incomingOrder.orderID, nil
+}
+
+// rejectOrder reports the rejection of an incoming order (with the offending order, and the failing risk rule if any)
+// to the exchange via the actions channel, returning the error for the caller
+func (ex *Exchange) rejectOrder(order *Order, reason RejectReason, rule string) error {
+	if rule != "" {
+		ex.publish(newRiskRejectAction(order, rule))
+	} else {
+		ex.publish(newOrderRejectAction(order, reason))
+	}
+	return &RejectError{Reason: reason, Rule: rule}
+}
+
+// OrderStatus returns the status of a working order, or of a recently closed order
+// Closed orders are retained up to ClosedOrderRetention; returns false if the order is not known
+func (ex *Exchange) OrderStatus(orderID OrderID) (OrderStatus, bool) {
+	// Lock the exchange mutex (for reading) to prevent concurrent access
+	ex.mutex.RLock()
+	defer ex.mutex.RUnlock()
+
+	if node, ok := ex.orderIDMap[orderID]; ok {
+		if node.order.filled > 0 {
+			return node.order.status(OrderPartiallyFilled), true
+		}
+		return node.order.status(OrderNew), true
+	}
+	status, ok := ex.closedOrders[orderID]
+	return status, ok
+}
+
+// lookupRestingSymbol returns the symbol of a resting order, or the reject reason if the order is not working
+func (ex *Exchange) lookupRestingSymbol(orderID OrderID) (string, RejectReason) {
+	// Lock the exchange mutex (for reading) to prevent concurrent access
+	ex.mutex.RLock()
+	defer ex.mutex.RUnlock()
+
+	if node, ok := ex.orderIDMap[orderID]; ok {
+		return node.order.symbol, RejectNone
+	}
+	return "", ex.lookupRejectReason(orderID)
+}
+
+// Cancel processes an incoming cancel order, removing the order from its orderbook if it exists in the exchange
+// Returns a *RejectError if the cancel was rejected
+func (ex *Exchange) Cancel(orderID OrderID) error {
+	return ex.execute(&Command{Type: CommandCancel, OrderID: orderID}).err
+}
+
+// cancel removes the order from its orderbook if it exists in the exchange
+func (ex *Exchange) cancel(orderID OrderID) error {
+	// Look up the resting order, to find the orderbook it belongs to
+	symbol, reason := ex.lookupRestingSymbol(orderID)
+
+	// If the orderID is not found in the orderIDMap, it cannot be cancelled
+	if reason != RejectNone {
+		// Report the cancel rejection (already cancelled, already filled, or unknown) via the actions channel
+		ex.mutex.RLock()
+		rejected := ex.rejectedOrder(orderID)
+		ex.mutex.RUnlock()
+		ex.publish(newCancelRejectAction(&rejected, reason))
+		return &RejectError{Reason: reason}
+	}
+
+	// Pass the cancel to the orderbook, which rechecks the order under the orderbook lock
+	ob := ex.getOrCreateOrderBook(symbol)
+	if reason := ob.cancelHandle(orderID); reason != RejectNone {
+		return &RejectError{Reason: reason}
+	}
+	return nil
+}
+
+// Modify processes an incoming amend (cancel-replace) of a resting order's price and/or size
+// Size decreases at the same price keep the order's time priority; any other amendment moves it to the back of the queue
+// Returns a *RejectError if the amendment was rejected
+func (ex *Exchange) Modify(orderID OrderID, newPrice Price, newSize Size) error {
+	return ex.execute(&Command{Type: CommandModify, OrderID: orderID, Request: OrderRequest{Price: newPrice, Size: newSize}}).err
+}
+
+// modify amends the price and/or size of the order, if it is resting in the exchange
+func (ex *Exchange) modify(orderID OrderID, newPrice Price, newSize Size) error {
+	// The requested amendment, reported back on any rejection
+	amendment := Order{orderID: orderID, price: newPrice, size: newSize}
+
+	// Validate the amended price and size, rejecting if invalid
+	if newPrice < MinPrice || newPrice > MaxPrice {
+		// Report the replace rejection to the exchange via the actions channel
+		ex.publish(newReplaceRejectAction(&amendment, RejectPriceOutOfBand))
+		return &RejectError{Reason: RejectPriceOutOfBand}
+	}
+	if newSize <= 0 {
+		// Report the replace rejection to the exchange via the actions channel
+		ex.publish(newReplaceRejectAction(&amendment, RejectZeroSize))
+		return &RejectError{Reason: RejectZeroSize}
+	}
+
+	// Look up the resting order, to find the orderbook it belongs to
+	symbol, reason := ex.lookupRestingSymbol(orderID)
+
+	// If the order is not found, it cannot be amended
+	if reason != RejectNone {
+		// Report the replace rejection (echoing a closed order's client order ID) to the exchange via the actions channel
+		ex.mutex.RLock()
+		amendment = ex.rejectedOrder(orderID)
+		ex.mutex.RUnlock()
+		amendment.price, amendment.size = newPrice, newSize
+		ex.publish(newReplaceRejectAction(&amendment, reason))
+		return &RejectError{Reason: reason}
+	}
+
+	// Pass the amendment to the orderbook, which rechecks the order under the orderbook lock
+	ob := ex.getOrCreateOrderBook(symbol)
+	if reason, rule := ob.modifyHandle(orderID, newPrice, newSize); reason != RejectNone {
+		return &RejectError{Reason: reason, Rule: rule}
+	}
+	return nil
+}
+
+// CancelByClientOrderID cancels the trader's working order with the given client order ID
+// Returns a *RejectError if the cancel was rejected
+func (ex *Exchange) CancelByClientOrderID(trader TraderID, clientOrderID string) error {
+	return ex.execute(&Command{Type: CommandCancelByClientOrderID, Request: OrderRequest{Trader: trader, ClientOrderID: clientOrderID}}).err
+}
+
+// cancelByClientOrderID cancels the trader's working order with the given client order ID, if any
+func (ex *Exchange) cancelByClientOrderID(trader TraderID, clientOrderID string) error {
+	orderID, ok := ex.lookupClientOrderID(trader, clientOrderID)
+	if !ok {
+		// Report the cancel rejection (echoing the client order ID) to the exchange via the actions channel
+		ex.publish(newCancelRejectAction(&Order{trader: trader, clientOrderID: clientOrderID}, RejectUnknownOrder))
+		return &RejectError{Reason: RejectUnknownOrder}
+	}
+	return ex.cancel(orderID)
+}
+
+// ModifyByClientOrderID amends the price and/or size of the trader's working order with the given client order ID
+// Returns a *RejectError if the amendment was rejected
+func (ex *Exchange) ModifyByClientOrderID(trader TraderID, clientOrderID string, newPrice Price, newSize Size) error {
+	return ex.execute(&Command{
+		Type:    CommandModifyByClientOrderID,
+		Request: OrderRequest{Price: newPrice, Size: newSize, Trader: trader, ClientOrderID: clientOrderID},
+	}).err
+}
+
+// modifyByClientOrderID amends the price and/or size of the trader's working order with the given client order ID, if any
+func (ex *Exchange) modifyByClientOrderID(trader TraderID, clientOrderID string, newPrice Price, newSize Size) error {
+	orderID, ok := ex.lookupClientOrderID(trader, clientOrderID)
+	if !ok {
+		// Report the replace rejection (echoing the client order ID) to the exchange via the actions channel
+		amendment := Order{price: newPrice, size: newSize, trader: trader, clientOrderID: clientOrderID}
+		ex.publish(newReplaceRejectAction(&amendment, RejectUnknownOrder))
+		return &RejectError{Reason: RejectUnknownOrder}
+	}
+	return ex.modify(orderID, newPrice, newSize)
+}
+
+// MassCancelFilter selects the resting orders cancelled by a mass cancel
+// Zero-valued fields match any order: any trader, any symbol, and both sides unless BySide is set
+// A filter must select a trader or a symbol (or both); a filter matching every trader in every symbol is rejected
+type MassCancelFilter struct {
+	Trader TraderID // Only cancel the orders of this trader
+	Symbol string   // Only cancel the orders in this symbol
+	Side   Side     // Only cancel the orders on this side, if BySide is set
+	BySide bool
+}
+
+// CancelAll cancels every resting order of the trader, across all symbols
+// Returns the number of orders cancelled, or a *RejectError if the trader is zero (which would match every trader)
+func (ex *Exchange) CancelAll(trader TraderID) (int, error) {
+	return ex.MassCancel(MassCancelFilter{Trader: trader})
+}
+
+// CancelSymbol cancels every resting order in the symbol, for all traders
+// Returns the number of orders cancelled, or a *RejectError if the symbol is empty (which would match every symbol)
+func (ex *Exchange) CancelSymbol(symbol string) (int, error) {
+	return ex.MassCancel(MassCancelFilter{Symbol: symbol})
+}
+
+// MassCancel atomically cancels every resting order matching the filter, across all orderbooks
+// One cancel action is reported per order (in OrderID order), followed by a mass cancel acknowledgement
+// Returns the number of orders cancelled, or a *RejectError if the filter selects neither a trader nor a symbol
+func (ex *Exchange) MassCancel(filter MassCancelFilter) (int, error) {
+	result := ex.execute(&Command{Type: CommandMassCancel, Filter: filter})
+	return result.cancelled, result.err
+}
+
+// massCancel cancels every resting order matching the filter, returning the number of orders cancelled
+func (ex *Exchange) massCancel(filter MassCancelFilter) (int, error) {
+	// Reject a filter that would match every order of every trader
+	if filter.Trader == 0 && filter.Symbol == "" {
+		// Report the rejection (with the filter) to the exchange via the actions channel
+		ex.publish(newCancelRejectAction(&Order{side: filter.Side}, RejectEmptyFilter))
+		return 0, &RejectError{Reason: RejectEmptyFilter}
+	}
+
+	// Collect the orderbooks that may hold matching orders
+	ex.mutex.RLock()
+	var books []*OrderBook
+	for symbol, ob := range ex.orderbooksMap {
+		if filter.Symbol == "" || filter.Symbol == symbol {
+			books = append(books, ob)
+		}
+	}
+	ex.mutex.RUnlock()
+
+	// Lock every orderbook (in symbol order, so concurrent mass cancels agree on the lock order)
+	// and then the exchange mutex, so that the mass cancel is atomic across the orderbooks
+	slices.SortFunc(books, func(a, b *OrderBook) int {
+		return cmp.Compare(a.symbol, b.symbol)
+	})
+	for _, ob := range books {
+		ob.mutex.Lock()
+		defer ob.mutex.Unlock()
+	}
 	ex.mutex.Lock()
 	defer ex.mutex.Unlock()
 
-	// Check if the order exists in the exchange
-	if cancelOrder, ok := ex.orderIDMap[orderID]; ok {
-		// If the order size is zero, it has already been cancelled
-		if cancelOrder.size == 0 {
-			// Report the cancel rejection to the exchange via the actions channel
-			ex.actions <- newCancelRejectAction()
-		} else {
-			// Update the order size to zero to show it has been cancelled
-			cancelOrder.size = 0
-
-			// Update the orderIDMap with the cancelled order
-			ex.orderIDMap[orderID] = cancelOrder
-
-			// Report the cancellation to the exchange via the actions channel
-			ex.actions <- newCancelAction(&cancelOrder)
+	// Collect the matching resting orders, and cancel them in OrderID (ie. arrival) order
+	var nodes []*orderNode
+	for _, ob := range books {
+		nodes = ob.collectResting(filter, nodes)
+	}
+	slices.SortFunc(nodes, func(a, b *orderNode) int {
+		return cmp.Compare(a.order.orderID, b.order.orderID)
+	})
+	for _, node := range nodes {
+		ex.orderbooksMap[node.order.symbol].cancelResting(node)
+	}
+
+	// Report any changes to the price levels and top of book of the affected orderbooks
+	for _, ob := range books {
+		ob.updateMarketData()
+	}
+
+	// Report the mass cancel acknowledgement to the exchange via the actions channel
+	ex.publish(newMassCancelAckAction(filter, Size(len(nodes))))
+	return len(nodes), nil
+}
+
+// ExpireOrders expires every resting GTD order whose expiry time is at or before the given time
+// The exchange does not run its own clock, so this should be called periodically by the owner of the exchange
+func (ex *Exchange) ExpireOrders(now time.Time) {
+	ex.execute(&Command{Type: CommandExpireOrders, Time: now.UnixNano()})
+}
+
+// EndSession expires every resting DAY order, and should be called at the end of each trading session
+func (ex *Exchange) EndSession() {
+	ex.execute(&Command{Type: CommandEndSession})
+}
+
+// expireWhere expires every resting order matching the given predicate
+func (ex *Exchange) expireWhere(expired func(order *Order) bool) {
+	// Collect the expired orders (and their orderbooks), while holding the exchange mutex for reading
+	type expiry struct {
+		orderID OrderID
+		symbol  string
+	}
+	var expiries []expiry
+	ex.mutex.RLock()
+	for orderID, node := range ex.orderIDMap {
+		if expired(&node.order) {
+			expiries = append(expiries, expiry{orderID: orderID, symbol: node.order.symbol})
 		}
-	} else {
-		// If the orderID is not found in the orderIDMap, it cannot be cancelled
-		// Report the cancel rejection to the exchange via the actions channel
-		ex.actions <- newCancelRejectAction()
+	}
+	ex.mutex.RUnlock()
+
+	// Expire in OrderID (ie. arrival) order, so the reported actions are deterministic
+	slices.SortFunc(expiries, func(a, b expiry) int {
+		return cmp.Compare(a.orderID, b.orderID)
+	})
+	for _, e := range expiries {
+		ex.getOrCreateOrderBook(e.symbol).expireHandle(e.orderID)
 	}
 }
@@ -1,14 +1,17 @@
 package exchange
 
 import (
+	"errors"
 	"math/rand"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestExchange_Init(t *testing.T) {
 	actions := make(chan *Action, ChanSize)
 	var exchange Exchange
-	exchange.Init("Test Exchange", actions)
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
 
 	if exchange.name != "Test Exchange" {
 		t.Errorf("Expected exchange name to be 'Test Exchange', got %s", exchange.name)
@@ -30,7 +33,7 @@ func TestExchange_Init(t *testing.T) {
 func TestExchange_getOrCreateOrderBook(t *testing.T) {
 	actions := make(chan *Action, ChanSize)
 	var exchange Exchange
-	exchange.Init("Test Exchange", actions)
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
 
 	symbol := "AAPL"
 	orderBook := exchange.getOrCreateOrderBook(symbol)
@@ -46,7 +49,7 @@ func TestExchange_getOrCreateOrderBook(t *testing.T) {
 func TestExchange_Limit(t *testing.T) {
 	actions := make(chan *Action, ChanSize)
 	var exchange Exchange
-	exchange.Init("Test Exchange", actions)
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
 
 	symbol := "AAPL"
 	price := Price(100)
@@ -65,7 +68,7 @@ func TestExchange_Limit(t *testing.T) {
 func TestExchange_FullFillLimit(t *testing.T) {
 	actions := make(chan *Action, ChanSize)
 	var exchange Exchange
-	exchange.Init("Test Exchange", actions)
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
 
 	exchange.Limit("AAPL", 100, 1000, Bid, 1)
 	exchange.Limit("AAPL", 100, 1000, Ask, 2)
@@ -92,7 +95,7 @@ func TestExchange_FullFillLimit(t *testing.T) {
 func TestExchange_MixedFullFillLimit(t *testing.T) {
 	actions := make(chan *Action, ChanSize)
 	var exchange Exchange
-	exchange.Init("Test Exchange", actions)
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
 
 	exchange.Limit("AAPL", 101, 1000, Bid, 1)
 	exchange.Limit("AAPL", 102, 500, Ask, 4)
@@ -111,12 +114,387 @@ func TestExchange_MixedFullFillLimit(t *testing.T) {
 	}
 }
 
-// Expand test suite to include order validation tests
+func TestExchange_Market(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	exchange.Limit("AAPL", 100, 10, Ask, 1)
+	exchange.Limit("AAPL", 105, 10, Ask, 2)
+	drainOrderActions(&exchange, actions)
+
+	exchange.Market("AAPL", 25, Bid, 3)
+
+	// Expect the order report, two executions and the cancelled remainder
+	got := drainOrderActions(&exchange, actions)
+	if len(got) != 4 {
+		t.Fatalf("Expected 4 actions, got %d", len(got))
+	}
+	if got[1].fill_price != 100 || got[2].fill_price != 105 {
+		t.Errorf("Expected fills at 100 and 105, got %d and %d", got[1].fill_price, got[2].fill_price)
+	}
+	if got[3].action_type != ActionRemainderCancel || got[3].order.size != 5 {
+		t.Errorf("Expected remainder cancel of 5, got %v", got[3])
+	}
+
+	orderBook := exchange.getOrCreateOrderBook("AAPL")
+	if orderBook.asks.Len() != 0 || orderBook.bids.Len() != 0 {
+		t.Errorf("Expected market order to sweep the asks and never rest")
+	}
+}
+
+func TestExchange_MarketProtection(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+	exchange.SetMarketProtection(2)
+
+	exchange.Limit("AAPL", 100, 10, Bid, 1)
+	exchange.Limit("AAPL", 98, 10, Bid, 2)
+	exchange.Limit("AAPL", 97, 10, Bid, 3)
+	drainOrderActions(&exchange, actions)
+
+	exchange.Market("AAPL", 30, Ask, 4)
+
+	// Only the 100 and 98 levels are within 2 ticks of the best bid
+	got := drainOrderActions(&exchange, actions)
+	if len(got) != 4 {
+		t.Fatalf("Expected 4 actions, got %d", len(got))
+	}
+	if got[3].action_type != ActionRemainderCancel || got[3].order.size != 10 {
+		t.Errorf("Expected remainder cancel of 10, got %v", got[3])
+	}
+
+	orderBook := exchange.getOrCreateOrderBook("AAPL")
+	if orderBook.bids.Len() != 1 || orderBook.bids.Max().(*PricePoint).price != 97 {
+		t.Errorf("Expected only the 97 bid level to remain")
+	}
+}
+
+func TestExchange_MarketReject(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	exchange.Market("AAPL", 0, Bid, 1)
+
+	got := drainOrderActions(&exchange, actions)
+	if len(got) != 1 || got[0].action_type != ActionOrderReject {
+		t.Errorf("Expected a single order reject action")
+	}
+}
+
+func TestExchange_SubmitIOC(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	exchange.Limit("AAPL", 100, 10, Ask, 1)
+	drainOrderActions(&exchange, actions)
+
+	exchange.Submit(OrderRequest{Symbol: "AAPL", Price: 100, Size: 15, Side: Bid, Trader: 2, TimeInForce: IOC})
+
+	got := drainOrderActions(&exchange, actions)
+	if len(got) != 3 {
+		t.Fatalf("Expected 3 actions, got %d", len(got))
+	}
+	if got[2].action_type != ActionRemainderCancel || got[2].order.size != 5 {
+		t.Errorf("Expected remainder cancel of 5, got %v", got[2])
+	}
+
+	orderBook := exchange.getOrCreateOrderBook("AAPL")
+	if orderBook.bids.Len() != 0 {
+		t.Errorf("Expected IOC remainder not to rest in the book")
+	}
+}
+
+func TestExchange_SubmitFOK(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	exchange.Limit("AAPL", 100, 10, Ask, 1)
+	exchange.Limit("AAPL", 101, 10, Ask, 2)
+	exchange.Limit("AAPL", 102, 10, Ask, 3)
+	drainOrderActions(&exchange, actions)
+
+	// Not enough liquidity at or below 101, so the order is killed untouched
+	exchange.Submit(OrderRequest{Symbol: "AAPL", Price: 101, Size: 25, Side: Bid, Trader: 4, TimeInForce: FOK})
+
+	got := drainOrderActions(&exchange, actions)
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 actions, got %d", len(got))
+	}
+	if got[1].action_type != ActionRemainderCancel || got[1].order.size != 25 {
+		t.Errorf("Expected the whole FOK order to be cancelled, got %v", got[1])
+	}
+
+	orderBook := exchange.getOrCreateOrderBook("AAPL")
+	if orderBook.asks.Len() != 3 {
+		t.Errorf("Expected the book to be untouched by the killed FOK order")
+	}
+
+	// Enough liquidity at or below 102, so the order fills completely
+	exchange.Submit(OrderRequest{Symbol: "AAPL", Price: 102, Size: 25, Side: Bid, Trader: 4, TimeInForce: FOK})
+
+	got = drainOrderActions(&exchange, actions)
+	if len(got) != 4 {
+		t.Fatalf("Expected 4 actions, got %d", len(got))
+	}
+	if orderBook.asks.Len() != 1 || orderBook.bids.Len() != 0 {
+		t.Errorf("Expected the FOK order to fill completely without resting")
+	}
+}
+
+func TestExchange_EndSession(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	exchange.Submit(OrderRequest{Symbol: "AAPL", Price: 100, Size: 10, Side: Bid, Trader: 1, TimeInForce: DAY})
+	exchange.Limit("AAPL", 99, 10, Bid, 2)
+	drainOrderActions(&exchange, actions)
+
+	exchange.EndSession()
+
+	got := drainOrderActions(&exchange, actions)
+	if len(got) != 1 || got[0].action_type != ActionExpire || got[0].order.orderID != 1 {
+		t.Fatalf("Expected the DAY order to expire, got %v", got)
+	}
+	if got[0].order.size != 10 {
+		t.Errorf("Expected the expired size to be reported, got %d", got[0].order.size)
+	}
+	if _, ok := exchange.orderIDMap[1]; ok || exchange.orderIDMap[2].order.size != 10 {
+		t.Errorf("Expected only the DAY order to be expired")
+	}
+}
+
+func TestExchange_ExpireOrders(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	now := time.Now()
+	exchange.Submit(OrderRequest{Symbol: "AAPL", Price: 100, Size: 10, Side: Bid, Trader: 1, TimeInForce: GTD, ExpireAt: now.Add(time.Minute)})
+	exchange.Submit(OrderRequest{Symbol: "AAPL", Price: 100, Size: 10, Side: Bid, Trader: 1, TimeInForce: GTD, ExpireAt: now.Add(time.Hour)})
+	drainOrderActions(&exchange, actions)
+
+	exchange.ExpireOrders(now.Add(2 * time.Minute))
+
+	got := drainOrderActions(&exchange, actions)
+	if len(got) != 1 || got[0].action_type != ActionExpire || got[0].order.orderID != 1 {
+		t.Fatalf("Expected only the first GTD order to expire, got %v", got)
+	}
+
+	// Expired orders are no longer matched against
+	exchange.Limit("AAPL", 100, 10, Ask, 2)
+	got = drainOrderActions(&exchange, actions)
+	if len(got) != 2 || got[1].order.orderID != 2 {
+		t.Errorf("Expected the incoming ask to fill against the unexpired GTD order")
+	}
+}
+
+func TestExchange_SubmitInvalidGTD(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	exchange.Submit(OrderRequest{Symbol: "AAPL", Price: 100, Size: 10, Side: Bid, Trader: 1, TimeInForce: GTD})
+
+	got := drainOrderActions(&exchange, actions)
+	if len(got) != 1 || got[0].action_type != ActionOrderReject {
+		t.Errorf("Expected a GTD order without an expiry to be rejected")
+	}
+}
+
+func TestExchange_ModifySizeDecreaseKeepsPriority(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	exchange.Limit("AAPL", 100, 10, Bid, 1)
+	exchange.Limit("AAPL", 100, 10, Bid, 2)
+	drainOrderActions(&exchange, actions)
+
+	exchange.Modify(1, 100, 4)
+
+	got := drainOrderActions(&exchange, actions)
+	if len(got) != 1 || got[0].action_type != ActionReplace || got[0].order.size != 4 {
+		t.Fatalf("Expected a replace action with size 4, got %v", got)
+	}
+
+	// Order 1 keeps its place at the front of the queue
+	exchange.Limit("AAPL", 100, 4, Ask, 3)
+	got = drainOrderActions(&exchange, actions)
+	if len(got) != 2 || got[1].order.orderID != 1 {
+		t.Errorf("Expected the amended order to keep its time priority")
+	}
+}
+
+func TestExchange_ModifySizeIncreaseLosesPriority(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	exchange.Limit("AAPL", 100, 10, Bid, 1)
+	exchange.Limit("AAPL", 100, 10, Bid, 2)
+	exchange.Modify(1, 100, 20)
+	drainOrderActions(&exchange, actions)
+
+	// Order 2 is now at the front of the queue
+	exchange.Limit("AAPL", 100, 5, Ask, 3)
+	got := drainOrderActions(&exchange, actions)
+	if len(got) != 2 || got[1].order.orderID != 2 {
+		t.Errorf("Expected the amended order to lose its time priority")
+	}
+}
+
+func TestExchange_ModifyPrice(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	exchange.Limit("AAPL", 100, 10, Bid, 1)
+	exchange.Limit("AAPL", 105, 4, Ask, 2)
+	drainOrderActions(&exchange, actions)
+
+	// Amending the bid through the ask fills it, with the remainder resting at the new price
+	exchange.Modify(1, 105, 10)
+
+	got := drainOrderActions(&exchange, actions)
+	if len(got) != 2 || got[0].action_type != ActionReplace || got[1].action_type != ActionExecute {
+		t.Fatalf("Expected a replace then an execution, got %v", got)
+	}
+
+	orderBook := exchange.getOrCreateOrderBook("AAPL")
+	if orderBook.asks.Len() != 0 || orderBook.bids.Len() != 1 {
+		t.Fatalf("Expected only the amended bid to remain in the book")
+	}
+	if orderBook.bids.Max().(*PricePoint).price != 105 || exchange.orderIDMap[1].order.size != 6 {
+		t.Errorf("Expected the remainder of 6 to rest at 105")
+	}
+}
+
+func TestExchange_ModifyReject(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	exchange.Limit("AAPL", 100, 10, Bid, 1)
+	exchange.Cancel(1)
+	drainOrderActions(&exchange, actions)
+
+	exchange.Modify(1, 100, 5)
+	exchange.Modify(2, 100, 5)
+	exchange.Modify(1, 0, 5)
+
+	for _, action := range drainOrderActions(&exchange, actions) {
+		if action.action_type != ActionReplaceReject {
+			t.Errorf("Expected a replace reject, got %v", action)
+		}
+	}
+}
+
+func TestValidateOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		symbol string
+		price  Price
+		size   Size
+		side   Side
+		trader TraderID
+		want   RejectReason
+	}{
+		{"valid", "AAPL", 100, 10, Bid, 1, RejectNone},
+		{"empty symbol", "", 100, 10, Bid, 1, RejectEmptySymbol},
+		{"price below band", "AAPL", 0, 10, Bid, 1, RejectPriceOutOfBand},
+		{"price above band", "AAPL", MaxPrice + 1, 10, Bid, 1, RejectPriceOutOfBand},
+		{"zero size", "AAPL", 100, 0, Bid, 1, RejectZeroSize},
+		{"bad side", "AAPL", 100, 10, Side(2), 1, RejectBadSide},
+		{"bad trader", "AAPL", 100, 10, Ask, 0, RejectBadTrader},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validateOrder(tt.symbol, tt.price, tt.size, tt.side, tt.trader); got != tt.want {
+				t.Errorf("validateOrder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExchange_OrderRejectReason(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	exchange.Submit(OrderRequest{Symbol: "AAPL", Price: MaxPrice + 1, Size: 10, Side: Bid, Trader: 7, ClientOrderID: "ref-1"})
+
+	got := drainOrderActions(&exchange, actions)
+	if len(got) != 1 || got[0].action_type != ActionOrderReject {
+		t.Fatalf("Expected a single order reject action, got %v", got)
+	}
+	if got[0].reason != RejectPriceOutOfBand {
+		t.Errorf("Expected reason %v, got %v", RejectPriceOutOfBand, got[0].reason)
+	}
+	if got[0].order.trader != 7 || got[0].order.price != MaxPrice+1 || got[0].order.clientOrderID != "ref-1" {
+		t.Errorf("Expected the offending order fields on the reject, got %v", got[0].order)
+	}
+}
+
+func TestExchange_CancelRejectReason(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	exchange.Limit("AAPL", 100, 10, Bid, 1)
+	exchange.Limit("AAPL", 100, 10, Bid, 1)
+	exchange.Limit("AAPL", 100, 10, Ask, 2)
+	exchange.Cancel(2)
+	drainOrderActions(&exchange, actions)
+
+	exchange.Cancel(1)
+	exchange.Cancel(2)
+	exchange.Cancel(3)
+	exchange.Cancel(99)
+
+	got := drainOrderActions(&exchange, actions)
+	want := []RejectReason{RejectAlreadyFilled, RejectAlreadyCancelled, RejectAlreadyFilled, RejectUnknownOrder}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d actions, got %d", len(want), len(got))
+	}
+	for i, action := range got {
+		if action.action_type != ActionCancelReject || action.reason != want[i] {
+			t.Errorf("Expected cancel reject with reason %v, got %v", want[i], action)
+		}
+	}
+}
+
+func TestExchange_recordClosedRetention(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	for id := OrderID(1); id <= OrderID(ClosedOrderRetention)+1; id++ {
+		exchange.closeOrder(&Order{orderID: id}, OrderFilled)
+	}
+
+	// The oldest closed order is forgotten once the retention is exceeded
+	if len(exchange.closedOrders) != int(ClosedOrderRetention) {
+		t.Errorf("Expected %d closed orders, got %d", ClosedOrderRetention, len(exchange.closedOrders))
+	}
+	if exchange.lookupRejectReason(1) != RejectUnknownOrder {
+		t.Errorf("Expected the oldest closed order to be forgotten")
+	}
+	if exchange.lookupRejectReason(2) != RejectAlreadyFilled {
+		t.Errorf("Expected the retained closed order to be remembered")
+	}
+}
 
 func TestExchange_Cancel(t *testing.T) {
 	actions := make(chan *Action, ChanSize)
 	var exchange Exchange
-	exchange.Init("Test Exchange", actions)
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
 
 	symbol := "AAPL"
 	price := Price(100)
@@ -129,9 +507,508 @@ func TestExchange_Cancel(t *testing.T) {
 
 	exchange.Cancel(orderID)
 
-	if exchange.orderIDMap[orderID].size != 0 {
-		t.Errorf("Expected order size to be 0 after cancellation")
+	if _, ok := exchange.orderIDMap[orderID]; ok {
+		t.Errorf("Expected order to be removed from the orderIDMap after cancellation")
+	}
+
+	// The cancelled order is removed from its price point, and the empty price point from the orderbook
+	orderBook := exchange.getOrCreateOrderBook(symbol)
+	if orderBook.bids.Len() != 0 {
+		t.Errorf("Expected the empty price point to be removed after cancellation")
+	}
+}
+
+func TestExchange_CancelKeepsQueue(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	first, _ := exchange.Limit("AAPL", 100, 10, Bid, 1)
+	second, _ := exchange.Limit("AAPL", 100, 10, Bid, 2)
+	third, _ := exchange.Limit("AAPL", 100, 10, Bid, 3)
+
+	// Cancel from the middle of the queue
+	exchange.Cancel(second)
+
+	pp := exchange.getOrCreateOrderBook("AAPL").bids.Max().(*PricePoint)
+	if pp.count != 2 || pp.head.order.orderID != first || pp.tail.order.orderID != third || pp.head.next != pp.tail {
+		t.Errorf("Expected orders %d and %d to remain linked at the price point", first, third)
+	}
+	drainOrderActions(&exchange, actions)
+
+	// The remaining orders fill in time priority
+	exchange.Limit("AAPL", 100, 20, Ask, 4)
+	got := drainOrderActions(&exchange, actions)
+	if len(got) != 3 || got[1].order.orderID != first || got[2].order.orderID != third {
+		t.Errorf("Expected fills against orders %d then %d", first, third)
+	}
+}
+
+func TestExchange_LimitReturnsOrderID(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	orderID, err := exchange.Limit("AAPL", 100, 10, Bid, 1)
+	if err != nil {
+		t.Fatalf("Expected the order to be accepted, got %v", err)
+	}
+	if orderID != 1 || exchange.orderIDMap[orderID].order.size != 10 {
+		t.Errorf("Expected the assigned OrderID 1 to be returned, got %d", orderID)
+	}
+
+	orderID, err = exchange.Limit("AAPL", 100, 0, Bid, 1)
+	var rejectErr *RejectError
+	if !errors.As(err, &rejectErr) || rejectErr.Reason != RejectZeroSize {
+		t.Errorf("Expected a zero size RejectError, got %v", err)
+	}
+	if orderID != 0 {
+		t.Errorf("Expected no OrderID for a rejected order, got %d", orderID)
+	}
+
+	orderID, err = exchange.Market("AAPL", 5, Ask, 2)
+	if err != nil || orderID != 2 {
+		t.Errorf("Expected the market order to be assigned OrderID 2, got %d (%v)", orderID, err)
+	}
+}
+
+func TestExchange_CancelReturnsError(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	orderID, _ := exchange.Limit("AAPL", 100, 10, Bid, 1)
+
+	if err := exchange.Cancel(orderID); err != nil {
+		t.Errorf("Expected the cancel to be accepted, got %v", err)
+	}
+
+	var rejectErr *RejectError
+	if err := exchange.Cancel(orderID); !errors.As(err, &rejectErr) || rejectErr.Reason != RejectAlreadyCancelled {
+		t.Errorf("Expected an already cancelled RejectError, got %v", err)
+	}
+	if err := exchange.Modify(orderID, 100, 5); !errors.As(err, &rejectErr) || rejectErr.Reason != RejectAlreadyCancelled {
+		t.Errorf("Expected an already cancelled RejectError on modify, got %v", err)
+	}
+}
+
+func TestExchange_ConcurrentLimitOrderIDs(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	const goroutines, perGoroutine = 8, 50
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	seen := make(map[OrderID]bool)
+
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(trader TraderID) {
+			defer wg.Done()
+			for i := 0; i < perGoroutine; i++ {
+				orderID, err := exchange.Limit("AAPL", 100, 1, Bid, trader)
+				if err != nil {
+					t.Errorf("Expected the order to be accepted, got %v", err)
+					return
+				}
+				mutex.Lock()
+				seen[orderID] = true
+				mutex.Unlock()
+			}
+		}(TraderID(g + 1))
+	}
+	wg.Wait()
+
+	if len(seen) != goroutines*perGoroutine {
+		t.Errorf("Expected %d unique OrderIDs, got %d", goroutines*perGoroutine, len(seen))
+	}
+}
+
+func TestExchange_ClientOrderID(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	orderID, err := exchange.Submit(OrderRequest{Symbol: "AAPL", Price: 100, Size: 10, Side: Bid, Trader: 1, ClientOrderID: "A1"})
+	if err != nil {
+		t.Fatalf("Expected the order to be accepted, got %v", err)
+	}
+
+	// The same client order ID is rejected for the same trader, but allowed for another trader
+	var rejectErr *RejectError
+	_, err = exchange.Submit(OrderRequest{Symbol: "AAPL", Price: 100, Size: 10, Side: Bid, Trader: 1, ClientOrderID: "A1"})
+	if !errors.As(err, &rejectErr) || rejectErr.Reason != RejectDuplicateClientOrderID {
+		t.Errorf("Expected a duplicate client order ID RejectError, got %v", err)
+	}
+	if _, err = exchange.Submit(OrderRequest{Symbol: "AAPL", Price: 100, Size: 10, Side: Bid, Trader: 2, ClientOrderID: "A1"}); err != nil {
+		t.Errorf("Expected the same client order ID to be accepted for another trader, got %v", err)
+	}
+	drainOrderActions(&exchange, actions)
+
+	// Amend and cancel by client order ID, with the client order ID echoed on each action
+	if err = exchange.ModifyByClientOrderID(1, "A1", 100, 5); err != nil {
+		t.Errorf("Expected the amend to be accepted, got %v", err)
+	}
+	if err = exchange.CancelByClientOrderID(1, "A1"); err != nil {
+		t.Errorf("Expected the cancel to be accepted, got %v", err)
+	}
+	for _, action := range drainOrderActions(&exchange, actions) {
+		if action.order.orderID != orderID || action.order.clientOrderID != "A1" {
+			t.Errorf("Expected the client order ID to be echoed, got %v", action.order)
+		}
+	}
+
+	// Once the order is cancelled, its client order ID is released
+	if err = exchange.CancelByClientOrderID(1, "A1"); !errors.As(err, &rejectErr) || rejectErr.Reason != RejectUnknownOrder {
+		t.Errorf("Expected an unknown order RejectError, got %v", err)
+	}
+	if got := drainOrderActions(&exchange, actions); len(got) != 1 || got[0].order.clientOrderID != "A1" || got[0].order.trader != 1 {
+		t.Errorf("Expected the cancel reject to echo the client order ID")
+	}
+	if _, err = exchange.Submit(OrderRequest{Symbol: "AAPL", Price: 100, Size: 10, Side: Bid, Trader: 1, ClientOrderID: "A1"}); err != nil {
+		t.Errorf("Expected the released client order ID to be reusable, got %v", err)
+	}
+}
+
+func TestExchange_ClientOrderIDReleasedOnFill(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	exchange.Submit(OrderRequest{Symbol: "AAPL", Price: 100, Size: 10, Side: Bid, Trader: 1, ClientOrderID: "B1"})
+	exchange.Submit(OrderRequest{Symbol: "AAPL", Price: 100, Size: 10, Side: Ask, Trader: 2, ClientOrderID: "S1"})
+
+	got := drainOrderActions(&exchange, actions)
+	if len(got) != 3 || got[2].order.clientOrderID != "B1" || got[2].cross_order.clientOrderID != "S1" {
+		t.Errorf("Expected both client order IDs to be echoed on the execution")
+	}
+	if len(exchange.clientOrderIDs) != 0 {
+		t.Errorf("Expected the client order IDs to be released once filled, got %v", exchange.clientOrderIDs)
+	}
+}
+
+func TestExchange_MarketClientOrderID(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	exchange.Submit(OrderRequest{Symbol: "AAPL", Price: 100, Size: 10, Side: Ask, Trader: 1, ClientOrderID: "S1"})
+	exchange.Submit(OrderRequest{Symbol: "AAPL", Price: 90, Size: 10, Side: Bid, Trader: 2, ClientOrderID: "B1"})
+	drainOrderActions(&exchange, actions)
+
+	// A market order echoes its client order ID, and a duplicate of a working order's client order ID is rejected
+	var rejectErr *RejectError
+	if _, err := exchange.SubmitMarket(OrderRequest{Symbol: "AAPL", Size: 5, Side: Bid, Trader: 2, ClientOrderID: "B1"}); !errors.As(err, &rejectErr) || rejectErr.Reason != RejectDuplicateClientOrderID {
+		t.Errorf("Expected a duplicate client order ID RejectError, got %v", err)
+	}
+	drainOrderActions(&exchange, actions)
+	if _, err := exchange.SubmitMarket(OrderRequest{Symbol: "AAPL", Size: 5, Side: Bid, Trader: 2, ClientOrderID: "M1"}); err != nil {
+		t.Fatalf("Expected the market order to be accepted, got %v", err)
+	}
+	got := drainOrderActions(&exchange, actions)
+	if len(got) != 2 || got[0].order.clientOrderID != "M1" || got[1].order.clientOrderID != "M1" || got[1].cross_order.clientOrderID != "S1" {
+		t.Errorf("Expected the market order's client order ID to be echoed, got %v", got)
+	}
+
+	// Once filled, the market order's client order ID is released
+	if _, err := exchange.SubmitMarket(OrderRequest{Symbol: "AAPL", Size: 5, Side: Bid, Trader: 2, ClientOrderID: "M1"}); err != nil {
+		t.Errorf("Expected the released client order ID to be reusable, got %v", err)
+	}
+	drainOrderActions(&exchange, actions)
+
+	// Cancel and replace rejects of a closed order echo its trader and client order ID
+	exchange.CancelByClientOrderID(2, "B1")
+	drainOrderActions(&exchange, actions)
+	exchange.Cancel(1)
+	exchange.Modify(2, 95, 10)
+	got = drainOrderActions(&exchange, actions)
+	if len(got) != 2 || got[0].action_type != ActionCancelReject || got[0].order.trader != 1 || got[0].order.clientOrderID != "S1" {
+		t.Errorf("Expected the cancel reject to echo the filled order's client order ID, got %v", got)
+	}
+	if len(got) == 2 && (got[1].action_type != ActionReplaceReject || got[1].order.clientOrderID != "B1" || got[1].order.price != 95) {
+		t.Errorf("Expected the replace reject to echo the cancelled order's client order ID, got %v", got[1])
+	}
+}
+
+func TestExchange_MassCancel(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	exchange.Limit("AAPL", 100, 10, Bid, 1)
+	exchange.Limit("AAPL", 110, 10, Ask, 1)
+	exchange.Limit("GOOGL", 200, 10, Bid, 1)
+	exchange.Limit("AAPL", 99, 10, Bid, 2)
+	exchange.Limit("GOOGL", 210, 10, Ask, 2)
+	drainOrderActions(&exchange, actions)
+
+	// Cancel trader 1's bids across every symbol
+	if cancelled, err := exchange.MassCancel(MassCancelFilter{Trader: 1, Side: Bid, BySide: true}); err != nil || cancelled != 2 {
+		t.Errorf("Expected 2 orders to be cancelled, got %d", cancelled)
+	}
+
+	got := drainOrderActions(&exchange, actions)
+	if len(got) != 3 {
+		t.Fatalf("Expected 3 actions, got %d", len(got))
+	}
+	if got[0].action_type != ActionCancel || got[0].order.orderID != 1 || got[1].action_type != ActionCancel || got[1].order.orderID != 3 {
+		t.Errorf("Expected cancels of orders 1 and 3 in OrderID order, got %v and %v", got[0], got[1])
+	}
+	if got[2].action_type != ActionMassCancelAck || got[2].cancelled != 2 {
+		t.Errorf("Expected a mass cancel acknowledgement of 2 orders, got %v", got[2])
+	}
+	if len(exchange.orderIDMap) != 3 {
+		t.Errorf("Expected 3 orders to remain, got %d", len(exchange.orderIDMap))
+	}
+}
+
+func TestExchange_CancelAllAndSymbol(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	exchange.Limit("AAPL", 100, 10, Bid, 1)
+	exchange.Limit("AAPL", 110, 10, Ask, 2)
+	exchange.Limit("GOOGL", 200, 10, Bid, 1)
+	exchange.Limit("GOOGL", 210, 10, Ask, 2)
+
+	if cancelled, _ := exchange.CancelSymbol("AAPL"); cancelled != 2 {
+		t.Errorf("Expected 2 AAPL orders to be cancelled, got %d", cancelled)
+	}
+	orderBook := exchange.getOrCreateOrderBook("AAPL")
+	if orderBook.bids.Len() != 0 || orderBook.asks.Len() != 0 {
+		t.Errorf("Expected the AAPL orderbook to be empty")
+	}
+
+	if cancelled, _ := exchange.CancelAll(2); cancelled != 1 {
+		t.Errorf("Expected 1 order of trader 2 to be cancelled, got %d", cancelled)
+	}
+	if cancelled, _ := exchange.CancelAll(3); cancelled != 0 {
+		t.Errorf("Expected no orders of trader 3 to be cancelled, got %d", cancelled)
+	}
+	if len(exchange.orderIDMap) != 1 || exchange.orderIDMap[3] == nil {
+		t.Errorf("Expected only the GOOGL bid to remain")
+	}
+}
+
+func TestExchange_MassCancelEmptyFilter(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	exchange.Limit("AAPL", 100, 10, Bid, 1)
+	exchange.Limit("GOOGL", 210, 10, Ask, 2)
+	drainOrderActions(&exchange, actions)
+
+	// A zero trader or empty symbol would match every order, so is rejected rather than cancelling everything
+	var rejectErr *RejectError
+	if cancelled, err := exchange.CancelAll(0); !errors.As(err, &rejectErr) || rejectErr.Reason != RejectEmptyFilter || cancelled != 0 {
+		t.Errorf("Expected CancelAll(0) to be rejected, got %d (%v)", cancelled, err)
+	}
+	if cancelled, err := exchange.CancelSymbol(""); !errors.As(err, &rejectErr) || rejectErr.Reason != RejectEmptyFilter || cancelled != 0 {
+		t.Errorf("Expected CancelSymbol(\"\") to be rejected, got %d (%v)", cancelled, err)
+	}
+	if _, err := exchange.MassCancel(MassCancelFilter{Side: Bid, BySide: true}); !errors.As(err, &rejectErr) || rejectErr.Reason != RejectEmptyFilter {
+		t.Errorf("Expected a filter by side alone to be rejected, got %v", err)
+	}
+
+	got := drainOrderActions(&exchange, actions)
+	if len(got) != 3 || got[0].action_type != ActionCancelReject || got[0].RejectReason() != RejectEmptyFilter {
+		t.Errorf("Expected three cancel rejects, got %v", got)
+	}
+	if len(exchange.orderIDMap) != 2 {
+		t.Errorf("Expected no orders to be cancelled, got %d remaining", len(exchange.orderIDMap))
+	}
+}
+
+func TestExchange_OrderStatus(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	bidID, _ := exchange.Limit("AAPL", 101, 30, Bid, 1)
+	if status, ok := exchange.OrderStatus(bidID); !ok || status.State != OrderNew || status.RemainingSize != 30 {
+		t.Errorf("Expected a new order with 30 remaining, got %+v", status)
+	}
+
+	exchange.Limit("AAPL", 101, 10, Ask, 2)
+	exchange.Limit("AAPL", 101, 5, Ask, 2)
+	status, ok := exchange.OrderStatus(bidID)
+	if !ok || status.State != OrderPartiallyFilled || status.FilledSize != 15 || status.RemainingSize != 15 {
+		t.Errorf("Expected a partially filled order with 15 filled and 15 remaining, got %+v", status)
+	}
+	if status.OriginalSize != 30 || status.AvgFillPrice != 101 {
+		t.Errorf("Expected an original size of 30 and average fill price of 101, got %+v", status)
+	}
+
+	exchange.Cancel(bidID)
+	status, ok = exchange.OrderStatus(bidID)
+	if !ok || status.State != OrderCancelled || status.FilledSize != 15 || status.RemainingSize != 0 {
+		t.Errorf("Expected a cancelled order with 15 filled and nothing remaining, got %+v", status)
+	}
+
+	if _, ok := exchange.OrderStatus(99); ok {
+		t.Errorf("Expected no status for an unknown order")
+	}
+}
+
+func TestExchange_OrderStatusClosed(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	exchange.Limit("AAPL", 100, 10, Ask, 1)
+	exchange.Limit("AAPL", 102, 10, Ask, 1)
+	marketID, _ := exchange.Market("AAPL", 20, Bid, 2)
+	fokID, _ := exchange.Submit(OrderRequest{Symbol: "AAPL", Price: 100, Size: 10, Side: Bid, Trader: 2, TimeInForce: FOK})
+	dayID, _ := exchange.Submit(OrderRequest{Symbol: "AAPL", Price: 90, Size: 10, Side: Bid, Trader: 2, TimeInForce: DAY})
+	exchange.EndSession()
+
+	tests := []struct {
+		orderID  OrderID
+		state    OrderState
+		filled   Size
+		avgPrice float64
+	}{
+		{1, OrderFilled, 10, 100},
+		{marketID, OrderFilled, 20, 101},
+		{fokID, OrderRejected, 0, 0},
+		{dayID, OrderExpired, 0, 0},
+	}
+	for _, tt := range tests {
+		status, ok := exchange.OrderStatus(tt.orderID)
+		if !ok || status.State != tt.state || status.FilledSize != tt.filled || status.AvgFillPrice != tt.avgPrice {
+			t.Errorf("Expected order %d to be in state %v with %d filled at %v, got %+v", tt.orderID, tt.state, tt.filled, tt.avgPrice, status)
+		}
+		if status.RemainingSize != 0 {
+			t.Errorf("Expected closed order %d to have nothing remaining, got %d", tt.orderID, status.RemainingSize)
+		}
+	}
+}
+
+// drainActions returns all actions published so far, once the exchange has delivered them to the actions channel
+func drainActions(exchange *Exchange, actions chan *Action) []*Action {
+	exchange.Flush()
+
+	var drained []*Action
+	for {
+		select {
+		case action := <-actions:
+			drained = append(drained, action)
+		default:
+			return drained
+		}
+	}
+}
+
+func TestExchange_TradeTape(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	exchange.Limit("AAPL", 100, 5, Ask, 1)
+	exchange.Limit("AAPL", 101, 5, Ask, 2)
+	exchange.Limit("MSFT", 50, 5, Bid, 3)
+	exchange.Limit("AAPL", 101, 8, Bid, 4)
+	exchange.Market("MSFT", 2, Ask, 5)
+
+	// Each execution is followed by its public trade, with trade IDs unique across symbols
+	var executions, trades []*Action
+	for _, action := range drainActions(&exchange, actions) {
+		switch action.Type() {
+		case ActionExecute:
+			executions = append(executions, action)
+		case ActionTrade:
+			if len(trades) != len(executions)-1 {
+				t.Errorf("Expected the public trade to follow its execution")
+			}
+			trades = append(trades, action)
+		}
+	}
+	if len(executions) != 3 || len(trades) != 3 {
+		t.Fatalf("Expected 3 executions and 3 trades, got %d and %d", len(executions), len(trades))
+	}
+
+	want := []Trade{
+		{TradeID: 1, Symbol: "AAPL", Price: 100, Size: 5, AggressorSide: Bid},
+		{TradeID: 2, Symbol: "AAPL", Price: 101, Size: 3, AggressorSide: Bid},
+		{TradeID: 3, Symbol: "MSFT", Price: 50, Size: 2, AggressorSide: Ask},
+	}
+	for i := range want {
+		trade := trades[i].Trade()
+		if trade.Timestamp != trades[i].Timestamp() || trade.Timestamp == 0 {
+			t.Errorf("Expected the trade to be timestamped when published, got %d", trade.Timestamp)
+		}
+		trade.Timestamp = 0
+		if trade != want[i] {
+			t.Errorf("Expected trade %+v, got %+v", want[i], trade)
+		}
+		if executions[i].TradeID() != want[i].TradeID || executions[i].AggressorSide() != want[i].AggressorSide {
+			t.Errorf("Expected the execution to carry trade %d, got %d", want[i].TradeID, executions[i].TradeID())
+		}
+		if trades[i].Trader() != 0 || trades[i].OrderID() != 0 {
+			t.Errorf("Expected the public trade to be anonymous, got %v", trades[i])
+		}
+	}
+}
+
+func TestExchange_SequenceNumbers(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	exchange.Limit("AAPL", 100, 10, Bid, 1)
+	exchange.Limit("MSFT", 200, 10, Ask, 2)
+	exchange.Limit("AAPL", 100, 4, Ask, 3)
+	exchange.Limit("", 100, 4, Ask, 3)
+	exchange.Cancel(999)
+	exchange.Limit("MSFT", 199, 10, Bid, 4)
+	exchange.CancelAll(1)
+
+	// Every action carries the next exchange sequence number, and its symbol's next sequence number if published by an orderbook
+	var exchangeSequence uint64
+	var timestamp int64
+	symbolSequence := make(map[string]uint64)
+	for _, action := range drainActions(&exchange, actions) {
+		if action.ExchangeSequence() != exchangeSequence+1 {
+			t.Errorf("Expected exchange sequence %d, got %d", exchangeSequence+1, action.ExchangeSequence())
+		}
+		exchangeSequence = action.ExchangeSequence()
+
+		if action.Timestamp() < timestamp || action.Timestamp() == 0 {
+			t.Errorf("Expected non-decreasing timestamps, got %d after %d", action.Timestamp(), timestamp)
+		}
+		timestamp = action.Timestamp()
+
+		if action.Sequence() != 0 {
+			if action.Sequence() != symbolSequence[action.Symbol()]+1 {
+				t.Errorf("Expected %s sequence %d, got %d", action.Symbol(), symbolSequence[action.Symbol()]+1, action.Sequence())
+			}
+			symbolSequence[action.Symbol()] = action.Sequence()
+		}
+	}
+	if symbolSequence["AAPL"] == 0 || symbolSequence["MSFT"] == 0 {
+		t.Errorf("Expected per-symbol sequences for both symbols, got %v", symbolSequence)
+	}
+	if exchange.Orders("AAPL").Sequence != symbolSequence["AAPL"] {
+		t.Errorf("Expected the snapshot sequence to match the last AAPL action")
+	}
+}
+
+// drainOrderActions returns the order event actions published so far, once delivered to the actions channel
+// Market data actions (public trades, book updates and top of book changes) are discarded
+func drainOrderActions(exchange *Exchange, actions chan *Action) []*Action {
+	var drained []*Action
+	for _, action := range drainActions(exchange, actions) {
+		switch action.action_type {
+		case ActionTrade, ActionBookUpdate, ActionBBO:
+			continue
+		default:
+			drained = append(drained, action)
+		}
 	}
+	return drained
 }
 
 // go test -bench=BenchmarkExchange
@@ -144,7 +1021,7 @@ func BenchmarkExchange(b *testing.B) {
 	var actions = make(chan *Action, ChanSize)
 
 	var exchange Exchange
-	exchange.Init("Test exchange", actions)
+	exchange.Init("Test exchange", actions, SubscribeOptions{})
 
 	go func() {
 		for range actions {
@@ -0,0 +1,337 @@
+package exchange
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// SyncPolicy represents when the journal forces its records to stable storage (fsync)
+type SyncPolicy uint8
+
+// Define the journal sync policies, trading durability for throughput
+const (
+	SyncEveryRecord SyncPolicy = iota // Sync each record before its command takes effect (no accepted command is lost)
+	SyncBatch                         // Sync once every BatchSize commands (up to BatchSize-1 commands may be lost)
+	SyncInterval                      // Sync every Interval in the background (up to Interval of commands may be lost)
+)
+
+// JournalOptions configures the sync policy of a journal
+// A zero BatchSize uses 100, and a zero Interval uses 10ms
+type JournalOptions struct {
+	Sync      SyncPolicy
+	BatchSize int
+	Interval  time.Duration
+}
+
+// Define the kinds of journal record, written as the first byte of each record's payload
+const (
+	journalCommandRecord uint8 = iota + 1 // An inbound command, written before it takes effect
+	journalHashRecord                     // The hash of the actions published, once a command has been processed
+)
+
+// journalHeaderSize is the size of each record header: the payload length and the CRC-32 (Castagnoli) of the payload
+const journalHeaderSize = 8
+
+// journalMaxRecord bounds the payload length read from a record header, so a corrupted length is not allocated
+const journalMaxRecord = 1 << 20
+
+// journalTable is the CRC-32 table used for the record checksums
+var journalTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrJournalChecksum is returned when a journal record does not match its checksum
+var ErrJournalChecksum = errors.New("exchange: journal record checksum mismatch")
+
+// Journal represents an append-only file of the commands processed by the exchange, written ahead of them taking effect
+// Each command record is followed by a record of the hash of the actions published by the exchange after processing it
+// Records are prefixed by their length and checksum
+type Journal struct {
+	file     *os.File
+	writer   *bufio.Writer
+	options  JournalOptions
+	unsynced int           // Number of records written since the last sync
+	batched  int           // Number of command records written since the last sync (SyncBatch counts these, not the hash records)
+	buf      []byte        // Reused encoding buffer
+	stop     chan struct{} // Closed to stop the background sync (SyncInterval)
+	stopped  chan struct{} // Closed once the background sync has stopped
+	stopOnce sync.Once     // Stops the background sync on the first Close only
+	mutex    sync.Mutex
+}
+
+// OpenJournal opens (or creates) the journal file at path for appending, with the given sync policy
+// A partially written final record (eg. if the exchange stopped while writing it) is truncated, so the new records follow
+// the last whole record. Returns ErrJournalChecksum if an earlier record is corrupted, as the journal could not be replayed
+func OpenJournal(path string, options JournalOptions) (*Journal, error) {
+	if options.BatchSize <= 0 {
+		options.BatchSize = 100
+	}
+	if options.Interval <= 0 {
+		options.Interval = 10 * time.Millisecond
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := truncateTorn(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	j := &Journal{
+		file:    file,
+		writer:  bufio.NewWriter(file),
+		options: options,
+	}
+
+	// Sync in the background for the interval policy
+	if options.Sync == SyncInterval {
+		j.stop = make(chan struct{})
+		j.stopped = make(chan struct{})
+		go j.syncEvery(options.Interval)
+	}
+	return j, nil
+}
+
+// truncateTorn truncates the journal file after its last whole record, dropping a partially written final record
+func truncateTorn(file *os.File) error {
+	reader := NewJournalReader(file)
+	for {
+		_, err := reader.readRecord()
+		if err == io.ErrUnexpectedEOF {
+			return file.Truncate(reader.offset)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Append writes the command as a new record, syncing it to stable storage according to the sync policy
+func (j *Journal) Append(cmd *Command) error {
+	// Lock the journal mutex to prevent concurrent access with the background sync
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if err := j.write(journalCommandRecord, func(buf []byte) []byte {
+		return appendCommand(buf, cmd)
+	}); err != nil {
+		return err
+	}
+	j.batched += 1
+
+	switch j.options.Sync {
+	case SyncEveryRecord:
+		return j.sync()
+	case SyncBatch:
+		if j.batched >= j.options.BatchSize {
+			return j.sync()
+		}
+	}
+	return nil
+}
+
+// appendHash writes a record of the action hash after processing the command with the given sequence number
+// The record is synced along with the next command, as it is only used to verify a replay
+func (j *Journal) appendHash(sequence uint64, hash uint64) error {
+	// Lock the journal mutex to prevent concurrent access with the background sync
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.write(journalHashRecord, func(buf []byte) []byte {
+		return appendHashBody(buf, sequence, hash)
+	})
+}
+
+// write encodes a record of the given kind (with the body appended by encode) and writes it to the journal
+// The journal mutex must be held by the caller
+func (j *Journal) write(kind uint8, encode func(buf []byte) []byte) error {
+	j.buf = appendRecord(j.buf[:0], kind, encode)
+	if _, err := j.writer.Write(j.buf); err != nil {
+		return err
+	}
+	j.unsynced += 1
+	return nil
+}
+
+// appendRecord appends a record of the given kind to buf: the header, and then the payload (the kind and the body appended by encode)
+func appendRecord(buf []byte, kind uint8, encode func(buf []byte) []byte) []byte {
+	// Encode the record: the header (filled in once the payload is known) and then the payload
+	start := len(buf)
+	buf = append(buf, make([]byte, journalHeaderSize)...)
+	buf = append(buf, kind)
+	buf = encode(buf)
+	payload := buf[start+journalHeaderSize:]
+	binary.LittleEndian.PutUint32(buf[start:start+4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[start+4:start+8], crc32.Checksum(payload, journalTable))
+	return buf
+}
+
+// appendHashBody appends the body of a hash record: the command sequence number and the action hash after it
+func appendHashBody(buf []byte, sequence uint64, hash uint64) []byte {
+	buf = binary.LittleEndian.AppendUint64(buf, sequence)
+	return binary.LittleEndian.AppendUint64(buf, hash)
+}
+
+// Sync flushes any buffered records and forces them to stable storage
+func (j *Journal) Sync() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.sync()
+}
+
+// sync flushes and syncs the journal file. The journal mutex must be held by the caller
+func (j *Journal) sync() error {
+	if j.unsynced == 0 {
+		return nil
+	}
+	if err := j.writer.Flush(); err != nil {
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+	j.unsynced = 0
+	j.batched = 0
+	return nil
+}
+
+// syncEvery syncs the journal every interval, until the journal is closed
+func (j *Journal) syncEvery(interval time.Duration) {
+	defer close(j.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// A failed sync is retried on the next tick, and reported by Close
+			j.Sync()
+		case <-j.stop:
+			return
+		}
+	}
+}
+
+// Close syncs any outstanding records and closes the journal file
+// Closing the journal again returns the file's error (os.ErrClosed)
+func (j *Journal) Close() error {
+	if j.stop != nil {
+		j.stopOnce.Do(func() { close(j.stop) })
+		<-j.stopped
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	err := j.sync()
+	if closeErr := j.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// SetJournal attaches a journal to the exchange; every command is appended to it before taking effect
+// The journal should be attached before any commands are processed (or after restoring the exchange from it)
+func (ex *Exchange) SetJournal(journal *Journal) {
+	// Lock the command mutex, so that no command is part way through processing
+	ex.commandMutex.Lock()
+	defer ex.commandMutex.Unlock()
+
+	ex.journal = journal
+}
+
+// JournalErr returns the error that stopped the exchange from journalling commands, or nil
+// Once the journal has failed, every command is refused with this error
+func (ex *Exchange) JournalErr() error {
+	ex.commandMutex.Lock()
+	defer ex.commandMutex.Unlock()
+
+	return ex.journalErr
+}
+
+// journalHash represents a hash record: the action hash after processing the command with the sequence number
+type journalHash struct {
+	sequence uint64
+	hash     uint64
+}
+
+// JournalReader reads the commands recorded in a journal, in order
+type JournalReader struct {
+	reader *bufio.Reader
+	header [journalHeaderSize]byte
+	offset int64 // Length of the whole records read so far
+}
+
+// NewJournalReader creates a reader of the journal records read from r (eg. an opened journal file)
+func NewJournalReader(r io.Reader) *JournalReader {
+	return &JournalReader{reader: bufio.NewReader(r)}
+}
+
+// Next returns the next command in the journal
+// Returns io.EOF at the end of the journal, io.ErrUnexpectedEOF for a partially written final record,
+// or ErrJournalChecksum for a corrupted record
+func (jr *JournalReader) Next() (Command, error) {
+	for {
+		cmd, hash, err := jr.next()
+		if err != nil || hash == nil {
+			return cmd, err
+		}
+	}
+}
+
+// next returns the next record in the journal: either a command, or an action hash
+func (jr *JournalReader) next() (Command, *journalHash, error) {
+	payload, err := jr.readRecord()
+	if err != nil {
+		return Command{}, nil, err
+	}
+
+	switch payload[0] {
+	case journalCommandRecord:
+		cmd, err := decodeCommand(payload[1:])
+		return cmd, nil, err
+	case journalHashRecord:
+		d := decoder{buf: payload[1:]}
+		hash := &journalHash{sequence: d.uint64(), hash: d.uint64()}
+		if d.err != nil || len(d.buf) != 0 {
+			return Command{}, nil, errCommandEncoding
+		}
+		return Command{}, hash, nil
+	default:
+		return Command{}, nil, errCommandEncoding
+	}
+}
+
+// readRecord reads the payload of the next record, checking it against its checksum
+func (jr *JournalReader) readRecord() ([]byte, error) {
+	if _, err := io.ReadFull(jr.reader, jr.header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.LittleEndian.Uint32(jr.header[0:4])
+	checksum := binary.LittleEndian.Uint32(jr.header[4:8])
+	if length == 0 || length > journalMaxRecord {
+		return nil, ErrJournalChecksum
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(jr.reader, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if crc32.Checksum(payload, journalTable) != checksum {
+		return nil, ErrJournalChecksum
+	}
+	jr.offset += journalHeaderSize + int64(length)
+	return payload, nil
+}
@@ -0,0 +1,213 @@
+package exchange
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readJournal returns every command recorded in the journal file, and the error that ended the read
+func readJournal(t *testing.T, path string) ([]Command, error) {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Expected to open the journal, got %v", err)
+	}
+	defer file.Close()
+
+	var commands []Command
+	reader := NewJournalReader(file)
+	for {
+		cmd, err := reader.Next()
+		if err != nil {
+			return commands, err
+		}
+		commands = append(commands, cmd)
+	}
+}
+
+func TestJournal_Exchange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "exchange.journal")
+	journal, err := OpenJournal(path, JournalOptions{Sync: SyncEveryRecord})
+	if err != nil {
+		t.Fatalf("Expected to open the journal, got %v", err)
+	}
+
+	var exchange Exchange
+	exchange.Init("Test Exchange", nil, SubscribeOptions{})
+	exchange.SetJournal(journal)
+
+	bid, _ := exchange.Limit("AAPL", 100, 10, Bid, 1)
+	exchange.Limit("AAPL", 0, 10, Bid, 1)
+	market, _ := exchange.Market("AAPL", 4, Ask, 2)
+	exchange.Cancel(bid)
+	exchange.CancelAll(1)
+	if err := journal.Close(); err != nil {
+		t.Fatalf("Expected to close the journal, got %v", err)
+	}
+
+	// Every command is recorded in sequence, with the OrderID assigned to accepted orders
+	commands, err := readJournal(t, path)
+	if err != io.EOF {
+		t.Errorf("Expected to read to the end of the journal, got %v", err)
+	}
+	want := []struct {
+		commandType CommandType
+		orderID     OrderID
+	}{{CommandSubmit, bid}, {CommandSubmit, 0}, {CommandMarket, market}, {CommandCancel, bid}, {CommandMassCancel, 0}}
+	if len(commands) != len(want) {
+		t.Fatalf("Expected %d commands, got %d", len(want), len(commands))
+	}
+	for i, w := range want {
+		if commands[i].Sequence != uint64(i+1) || commands[i].Type != w.commandType || commands[i].OrderID != w.orderID {
+			t.Errorf("Expected command %d to be %v of order %d, got %+v", i+1, w.commandType, w.orderID, commands[i])
+		}
+		if commands[i].Time == 0 {
+			t.Errorf("Expected command %d to be timestamped", i+1)
+		}
+	}
+	if commands[0].Request.Symbol != "AAPL" || commands[0].Request.Price != 100 || commands[4].Filter.Trader != 1 {
+		t.Errorf("Expected the command details to be recorded, got %+v and %+v", commands[0], commands[4])
+	}
+}
+
+func TestJournal_SyncPolicies(t *testing.T) {
+	for _, options := range []JournalOptions{
+		{Sync: SyncEveryRecord},
+		{Sync: SyncBatch, BatchSize: 3},
+		{Sync: SyncInterval, Interval: time.Millisecond},
+	} {
+		path := filepath.Join(t.TempDir(), "exchange.journal")
+		journal, err := OpenJournal(path, options)
+		if err != nil {
+			t.Fatalf("Expected to open the journal, got %v", err)
+		}
+		for i := 1; i <= 5; i++ {
+			if err := journal.Append(&Command{Sequence: uint64(i), Type: CommandEndSession}); err != nil {
+				t.Fatalf("Expected to append, got %v", err)
+			}
+		}
+
+		// Batched records are only written once the batch is full
+		if options.Sync == SyncBatch {
+			if commands, _ := readJournal(t, path); len(commands) != 3 {
+				t.Errorf("Expected the first batch of 3 records to be written, got %d", len(commands))
+			}
+		}
+		if options.Sync == SyncInterval {
+			for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+				commands, _ := readJournal(t, path)
+				if len(commands) == 5 {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("Expected the records to be written by the background sync, got %d", len(commands))
+				}
+			}
+		}
+
+		journal.Close()
+		if commands, err := readJournal(t, path); len(commands) != 5 || err != io.EOF {
+			t.Errorf("Expected all 5 records once closed (policy %v), got %d and %v", options.Sync, len(commands), err)
+		}
+	}
+}
+
+func TestJournal_SyncBatchCountsCommands(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "exchange.journal")
+	journal, err := OpenJournal(path, JournalOptions{Sync: SyncBatch, BatchSize: 3})
+	if err != nil {
+		t.Fatalf("Expected to open the journal, got %v", err)
+	}
+	defer journal.Close()
+
+	// The hash record written after each command does not count towards the batch
+	for i := 1; i <= 2; i++ {
+		journal.Append(&Command{Sequence: uint64(i), Type: CommandEndSession})
+		journal.appendHash(uint64(i), 0)
+	}
+	if commands, _ := readJournal(t, path); len(commands) != 0 {
+		t.Errorf("Expected nothing to be synced before the batch of 3 commands, got %d", len(commands))
+	}
+	journal.Append(&Command{Sequence: 3, Type: CommandEndSession})
+	if commands, _ := readJournal(t, path); len(commands) != 3 {
+		t.Errorf("Expected the batch of 3 commands to be synced, got %d", len(commands))
+	}
+}
+
+func TestJournal_Corruption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "exchange.journal")
+	journal, _ := OpenJournal(path, JournalOptions{})
+	journal.Append(&Command{Sequence: 1, Type: CommandEndSession})
+	journal.Append(&Command{Sequence: 2, Type: CommandEndSession})
+	journal.Close()
+	data, _ := os.ReadFile(path)
+
+	// A partially written final record
+	os.WriteFile(path, data[:len(data)-3], 0o644)
+	if commands, err := readJournal(t, path); len(commands) != 1 || err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected 1 command and a torn record, got %d and %v", len(commands), err)
+	}
+
+	// A corrupted payload byte in the second record
+	data[len(data)-1] ^= 0xff
+	os.WriteFile(path, data, 0o644)
+	if commands, err := readJournal(t, path); len(commands) != 1 || err != ErrJournalChecksum {
+		t.Errorf("Expected 1 command and a checksum mismatch, got %d and %v", len(commands), err)
+	}
+
+	// A corrupted journal is refused, as the commands after the corruption could not be replayed
+	if _, err := OpenJournal(path, JournalOptions{}); err != ErrJournalChecksum {
+		t.Errorf("Expected the corrupted journal to be refused, got %v", err)
+	}
+}
+
+func TestJournal_CloseTwice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "exchange.journal")
+	journal, err := OpenJournal(path, JournalOptions{Sync: SyncInterval})
+	if err != nil {
+		t.Fatalf("Expected to open the journal, got %v", err)
+	}
+	journal.Append(&Command{Sequence: 1, Type: CommandEndSession})
+
+	if err := journal.Close(); err != nil {
+		t.Errorf("Expected the journal to close, got %v", err)
+	}
+	if err := journal.Close(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Expected closing again to report the journal closed, got %v", err)
+	}
+	if commands, _ := readJournal(t, path); len(commands) != 1 {
+		t.Errorf("Expected the command to be synced on close, got %d", len(commands))
+	}
+}
+
+func TestJournal_FailureStopsExchange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "exchange.journal")
+	journal, _ := OpenJournal(path, JournalOptions{Sync: SyncEveryRecord})
+
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+	exchange.SetJournal(journal)
+
+	// Close the underlying file, so the next record cannot be written
+	journal.file.Close()
+	if _, err := exchange.Limit("AAPL", 100, 10, Bid, 1); err == nil {
+		t.Fatalf("Expected the order to fail to be journalled")
+	}
+	if exchange.JournalErr() == nil {
+		t.Errorf("Expected the journal error to be reported")
+	}
+
+	// The failed command did not take effect, and later commands are refused
+	err := exchange.Cancel(1)
+	if err == nil || !errors.Is(err, exchange.JournalErr()) {
+		t.Errorf("Expected the cancel to be refused, got %v", err)
+	}
+	if len(drainActions(&exchange, actions)) != 0 || len(exchange.Depth("AAPL", 0).Bids) != 0 {
+		t.Errorf("Expected no commands to take effect once the journal has failed")
+	}
+}
@@ -0,0 +1,296 @@
+package exchange
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	"github.com/google/btree"
+)
+
+// PriceLevel represents an aggregated price level of the orderbook
+type PriceLevel struct {
+	Price  Price
+	Size   Size // Total remaining size of the orders at the price level
+	Orders int  // Number of orders at the price level
+}
+
+// BookDepth represents an aggregated (Level 2) snapshot of the orderbook for a symbol
+type BookDepth struct {
+	Symbol string
+	Bids   []PriceLevel // Best (highest) bid first
+	Asks   []PriceLevel // Best (lowest) ask first
+}
+
+// Depth returns the aggregated price levels of the orderbook for the symbol, up to the given number of levels per side
+// A levels value of zero (or less) returns every price level. An unknown symbol returns an empty BookDepth
+func (ex *Exchange) Depth(symbol string, levels int) BookDepth {
+	// Lock the exchange mutex (for reading) to look up the orderbook, without creating it
+	ex.mutex.RLock()
+	ob, exists := ex.orderbooksMap[symbol]
+	ex.mutex.RUnlock()
+
+	if !exists {
+		return BookDepth{Symbol: symbol}
+	}
+	return ob.depth(levels)
+}
+
+// depth returns the aggregated price levels of the orderbook, computed consistently under the orderbook lock
+func (ob *OrderBook) depth(levels int) BookDepth {
+	// Lock the orderbook mutex (for reading) so both sides are taken at the same point in time
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	return BookDepth{
+		Symbol: ob.symbol,
+		Bids:   collectLevels(ob.bids.Descend, levels),
+		Asks:   collectLevels(ob.asks.Ascend, levels),
+	}
+}
+
+// collectLevels aggregates the price points visited by the given btree walk (best price first), up to levels
+func collectLevels(walk func(btree.ItemIterator), levels int) []PriceLevel {
+	var collected []PriceLevel
+	walk(func(i btree.Item) bool {
+		pp := i.(*PricePoint)
+		collected = append(collected, PriceLevel{Price: pp.price, Size: pp.volume, Orders: pp.count})
+		return levels <= 0 || len(collected) < levels
+	})
+	return collected
+}
+
+// RestingOrder represents a single resting order in a market-by-order (Level 3) snapshot
+// Position is the order's place in the time priority queue of its price level, starting at 1
+type RestingOrder struct {
+	OrderID  OrderID
+	Trader   TraderID
+	Price    Price
+	Size     Size // Remaining size
+	Position int
+}
+
+// BookOrders represents every resting order for a symbol, in priority order (best price first, then time priority)
+// Sequence is the sequence number of the last action published by the orderbook before the snapshot was taken,
+// so the snapshot can be continued from the actions with a greater sequence number
+type BookOrders struct {
+	Symbol   string
+	Sequence uint64
+	Bids     []RestingOrder
+	Asks     []RestingOrder
+}
+
+// Orders returns a market-by-order (Level 3) snapshot of every resting order for the symbol
+// An unknown symbol returns an empty BookOrders
+func (ex *Exchange) Orders(symbol string) BookOrders {
+	// Lock the exchange mutex (for reading) to look up the orderbook, without creating it
+	ex.mutex.RLock()
+	ob, exists := ex.orderbooksMap[symbol]
+	ex.mutex.RUnlock()
+
+	if !exists {
+		return BookOrders{Symbol: symbol}
+	}
+	return ob.orders()
+}
+
+// orders returns every resting order of the orderbook, with the sequence number taken under the same orderbook lock
+func (ob *OrderBook) orders() BookOrders {
+	// Lock the orderbook mutex (for reading) so the orders and sequence number are taken at the same point in time
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	return BookOrders{
+		Symbol:   ob.symbol,
+		Sequence: ob.sequence,
+		Bids:     collectOrders(ob.bids.Descend),
+		Asks:     collectOrders(ob.asks.Ascend),
+	}
+}
+
+// collectOrders lists the resting orders of the price points visited by the given btree walk, in queue order
+func collectOrders(walk func(btree.ItemIterator)) []RestingOrder {
+	var collected []RestingOrder
+	walk(func(i btree.Item) bool {
+		position := 1
+		for node := i.(*PricePoint).head; node != nil; node = node.next {
+			collected = append(collected, RestingOrder{
+				OrderID:  node.order.orderID,
+				Trader:   node.order.trader,
+				Price:    node.order.price,
+				Size:     node.order.size,
+				Position: position,
+			})
+			position += 1
+		}
+		return true
+	})
+	return collected
+}
+
+// BBO represents the top of the orderbook for a symbol: the best bid and ask prices and their total sizes
+// An empty side has a zero price and size
+type BBO struct {
+	Symbol   string
+	BidPrice Price
+	BidSize  Size
+	AskPrice Price
+	AskSize  Size
+}
+
+// BBO returns the best bid and ask of the orderbook for the symbol (an empty BBO for an unknown symbol)
+func (ex *Exchange) BBO(symbol string) BBO {
+	// Lock the exchange mutex (for reading) to look up the orderbook, without creating it
+	ex.mutex.RLock()
+	ob, exists := ex.orderbooksMap[symbol]
+	ex.mutex.RUnlock()
+
+	if !exists {
+		return BBO{Symbol: symbol}
+	}
+
+	// Lock the orderbook mutex (for reading) so both sides are taken at the same point in time
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	return ob.bbo()
+}
+
+// bbo returns the current best bid and ask of the orderbook
+// The orderbook mutex must be held by the caller
+func (ob *OrderBook) bbo() BBO {
+	top := BBO{Symbol: ob.symbol}
+	if maxBid := ob.bids.Max(); maxBid != nil {
+		top.BidPrice = maxBid.(*PricePoint).price
+		top.BidSize = maxBid.(*PricePoint).volume
+	}
+	if minAsk := ob.asks.Min(); minAsk != nil {
+		top.AskPrice = minAsk.(*PricePoint).price
+		top.AskSize = minAsk.(*PricePoint).volume
+	}
+	return top
+}
+
+// Trade represents an execution as published to the market: anonymous, without the orders or traders involved
+// The AggressorSide is the side of the incoming order, which traded against a resting order at its price
+type Trade struct {
+	TradeID       TradeID
+	Symbol        string
+	Price         Price
+	Size          Size
+	AggressorSide Side
+	Timestamp     int64 // Time the trade was published, in nanoseconds since the Unix epoch
+}
+
+// BookUpdateType represents the change made to a price level by an incremental book update
+type BookUpdateType uint8
+
+// Define the incremental book update types, from which a consumer can rebuild the Level 2 book
+const (
+	LevelAdd    BookUpdateType = iota // A new price level, with its size and number of orders
+	LevelChange                       // A change to the size and/or number of orders of an existing price level
+	LevelDelete                       // Removal of a price level, which has no orders remaining
+)
+
+// String returns a human readable representation of the book update type
+func (update BookUpdateType) String() string {
+	switch update {
+	case LevelAdd:
+		return "ADD"
+	case LevelChange:
+		return "CHANGE"
+	case LevelDelete:
+		return "DELETE"
+	default:
+		return fmt.Sprintf("BookUpdateType(%d)", uint8(update))
+	}
+}
+
+// BookUpdate represents an incremental change to a single price level of the orderbook for a symbol
+// Size and Orders are the new totals of the price level (zero for a LevelDelete)
+type BookUpdate struct {
+	Symbol string
+	Side   Side
+	Update BookUpdateType
+	Price  Price
+	Size   Size
+	Orders int
+}
+
+// levelKey identifies a price level on one side of the orderbook
+type levelKey struct {
+	side  Side
+	price Price
+}
+
+// touch records the state of a price level before it is first changed by the current operation
+// The orderbook mutex must be held by the caller
+func (ob *OrderBook) touch(side Side, price Price) {
+	key := levelKey{side: side, price: price}
+	if _, exists := ob.touched[key]; exists {
+		return
+	}
+	ob.touched[key] = ob.level(side, price)
+}
+
+// level returns the current totals of a price level (the zero PriceLevel if there are no orders at the price)
+// The orderbook mutex must be held by the caller
+func (ob *OrderBook) level(side Side, price Price) PriceLevel {
+	item := ob.sideTree(side).Get(&PricePoint{price: price})
+	if item == nil {
+		return PriceLevel{}
+	}
+	pp := item.(*PricePoint)
+	return PriceLevel{Price: pp.price, Size: pp.volume, Orders: pp.count}
+}
+
+// updateMarketData reports the changes made to the orderbook by the current operation:
+// an incremental update for each changed price level (bids then asks, by price), followed by any top of book change
+// The orderbook mutex must be held by the caller
+func (ob *OrderBook) updateMarketData() {
+	keys := make([]levelKey, 0, len(ob.touched))
+	for key := range ob.touched {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b levelKey) int {
+		if a.side != b.side {
+			return cmp.Compare(a.side, b.side)
+		}
+		return cmp.Compare(a.price, b.price)
+	})
+
+	for _, key := range keys {
+		before := ob.touched[key]
+		after := ob.level(key.side, key.price)
+		update := BookUpdate{Symbol: ob.symbol, Side: key.side, Price: key.price, Size: after.Size, Orders: after.Orders}
+
+		// Levels that were changed but ended the operation as they started (eg. by a replace) are not reported
+		switch {
+		case before.Orders == 0 && after.Orders == 0:
+			continue
+		case before.Orders == 0:
+			update.Update = LevelAdd
+		case after.Orders == 0:
+			update.Update = LevelDelete
+		case before != after:
+			update.Update = LevelChange
+		default:
+			continue
+		}
+		ob.publish(newBookUpdateAction(update))
+	}
+	clear(ob.touched)
+
+	ob.updateBBO()
+}
+
+// updateBBO reports the top of book via the actions channel if it has changed since it was last reported
+// The orderbook mutex must be held by the caller
+func (ob *OrderBook) updateBBO() {
+	top := ob.bbo()
+	if top == ob.lastBBO {
+		return
+	}
+	ob.lastBBO = top
+	ob.publish(newBBOAction(top))
+}
@@ -0,0 +1,291 @@
+package exchange
+
+import (
+	"testing"
+)
+
+func TestExchange_Depth(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	exchange.Limit("AAPL", 100, 10, Bid, 1)
+	exchange.Limit("AAPL", 100, 5, Bid, 2)
+	exchange.Limit("AAPL", 99, 7, Bid, 3)
+	exchange.Limit("AAPL", 98, 1, Bid, 3)
+	exchange.Limit("AAPL", 101, 4, Ask, 4)
+	exchange.Limit("AAPL", 103, 6, Ask, 4)
+
+	depth := exchange.Depth("AAPL", 2)
+	wantBids := []PriceLevel{{Price: 100, Size: 15, Orders: 2}, {Price: 99, Size: 7, Orders: 1}}
+	wantAsks := []PriceLevel{{Price: 101, Size: 4, Orders: 1}, {Price: 103, Size: 6, Orders: 1}}
+	if len(depth.Bids) != len(wantBids) || len(depth.Asks) != len(wantAsks) {
+		t.Fatalf("Expected 2 levels per side, got %+v", depth)
+	}
+	for i := range wantBids {
+		if depth.Bids[i] != wantBids[i] {
+			t.Errorf("Expected bid level %+v, got %+v", wantBids[i], depth.Bids[i])
+		}
+	}
+	for i := range wantAsks {
+		if depth.Asks[i] != wantAsks[i] {
+			t.Errorf("Expected ask level %+v, got %+v", wantAsks[i], depth.Asks[i])
+		}
+	}
+
+	if all := exchange.Depth("AAPL", 0); len(all.Bids) != 3 {
+		t.Errorf("Expected all 3 bid levels, got %d", len(all.Bids))
+	}
+	if empty := exchange.Depth("MSFT", 5); empty.Symbol != "MSFT" || len(empty.Bids) != 0 || len(empty.Asks) != 0 {
+		t.Errorf("Expected an empty depth for an unknown symbol, got %+v", empty)
+	}
+}
+
+func TestExchange_DepthAfterFillsAndCancels(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	first, _ := exchange.Limit("AAPL", 100, 10, Bid, 1)
+	exchange.Limit("AAPL", 100, 10, Bid, 2)
+	third, _ := exchange.Limit("AAPL", 100, 10, Bid, 3)
+
+	// Partially fill the first order, cancel the third and amend the second down
+	exchange.Limit("AAPL", 100, 4, Ask, 4)
+	exchange.Cancel(third)
+	exchange.Modify(first+1, 100, 8)
+
+	depth := exchange.Depth("AAPL", 1)
+	if len(depth.Bids) != 1 || depth.Bids[0] != (PriceLevel{Price: 100, Size: 14, Orders: 2}) {
+		t.Errorf("Expected a single bid level of 14 across 2 orders, got %+v", depth.Bids)
+	}
+
+	exchange.Cancel(first)
+	exchange.Cancel(first + 1)
+	if depth := exchange.Depth("AAPL", 0); len(depth.Bids) != 0 {
+		t.Errorf("Expected no bid levels once every order is cancelled, got %+v", depth.Bids)
+	}
+}
+
+func TestExchange_BBO(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	if top := exchange.BBO("AAPL"); top != (BBO{Symbol: "AAPL"}) {
+		t.Errorf("Expected an empty BBO for an unknown symbol, got %+v", top)
+	}
+
+	exchange.Limit("AAPL", 100, 10, Bid, 1)
+	exchange.Limit("AAPL", 100, 5, Bid, 2)
+	exchange.Limit("AAPL", 99, 7, Bid, 3)
+	exchange.Limit("AAPL", 102, 4, Ask, 4)
+
+	want := BBO{Symbol: "AAPL", BidPrice: 100, BidSize: 15, AskPrice: 102, AskSize: 4}
+	if top := exchange.BBO("AAPL"); top != want {
+		t.Errorf("Expected BBO %+v, got %+v", want, top)
+	}
+}
+
+func TestExchange_BBOEvents(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	bboEvents := func() []BBO {
+		var events []BBO
+		for _, action := range drainActions(&exchange, actions) {
+			if action.Type() == ActionBBO {
+				events = append(events, action.BBO())
+			}
+		}
+		return events
+	}
+
+	// A new best bid changes the top of book
+	exchange.Limit("AAPL", 100, 10, Bid, 1)
+	if events := bboEvents(); len(events) != 1 || events[0] != (BBO{Symbol: "AAPL", BidPrice: 100, BidSize: 10}) {
+		t.Errorf("Expected a single BBO event for the new best bid, got %+v", events)
+	}
+
+	// An order behind the top of book does not
+	exchange.Limit("AAPL", 99, 10, Bid, 1)
+	exchange.Limit("AAPL", 105, 10, Ask, 2)
+	if events := bboEvents(); len(events) != 1 || events[0].AskPrice != 105 {
+		t.Errorf("Expected a single BBO event for the new best ask only, got %+v", events)
+	}
+	exchange.Limit("AAPL", 106, 10, Ask, 2)
+	if events := bboEvents(); len(events) != 0 {
+		t.Errorf("Expected no BBO event for an order behind the top of book, got %+v", events)
+	}
+
+	// A partial fill at the top reduces the best bid size
+	exchange.Limit("AAPL", 100, 4, Ask, 3)
+	if events := bboEvents(); len(events) != 1 || events[0].BidSize != 6 {
+		t.Errorf("Expected a BBO event with the reduced bid size, got %+v", events)
+	}
+
+	// Mass cancelling the asks empties that side of the book
+	exchange.MassCancel(MassCancelFilter{Trader: 2})
+	if events := bboEvents(); len(events) != 1 || events[0].AskPrice != 0 || events[0].BidPrice != 100 {
+		t.Errorf("Expected a BBO event with an empty ask side, got %+v", events)
+	}
+}
+
+func TestExchange_Orders(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	if empty := exchange.Orders("AAPL"); empty.Symbol != "AAPL" || empty.Sequence != 0 || len(empty.Bids) != 0 {
+		t.Errorf("Expected an empty snapshot for an unknown symbol, got %+v", empty)
+	}
+
+	first, _ := exchange.Limit("AAPL", 100, 10, Bid, 1)
+	second, _ := exchange.Limit("AAPL", 101, 5, Bid, 2)
+	third, _ := exchange.Limit("AAPL", 100, 7, Bid, 3)
+	ask, _ := exchange.Limit("AAPL", 103, 6, Ask, 4)
+
+	// Partially fill the best bid
+	exchange.Limit("AAPL", 101, 2, Ask, 5)
+
+	snapshot := exchange.Orders("AAPL")
+	wantBids := []RestingOrder{
+		{OrderID: second, Trader: 2, Price: 101, Size: 3, Position: 1},
+		{OrderID: first, Trader: 1, Price: 100, Size: 10, Position: 1},
+		{OrderID: third, Trader: 3, Price: 100, Size: 7, Position: 2},
+	}
+	if len(snapshot.Bids) != len(wantBids) || len(snapshot.Asks) != 1 {
+		t.Fatalf("Expected 3 bids and 1 ask, got %+v", snapshot)
+	}
+	for i := range wantBids {
+		if snapshot.Bids[i] != wantBids[i] {
+			t.Errorf("Expected bid %+v, got %+v", wantBids[i], snapshot.Bids[i])
+		}
+	}
+	if snapshot.Asks[0] != (RestingOrder{OrderID: ask, Trader: 4, Price: 103, Size: 6, Position: 1}) {
+		t.Errorf("Expected the resting ask, got %+v", snapshot.Asks[0])
+	}
+
+	// The snapshot sequence is that of the last action published for the symbol
+	var last uint64
+	for _, action := range drainActions(&exchange, actions) {
+		if action.Symbol() == "AAPL" && action.Sequence() != 0 {
+			if action.Sequence() != last+1 {
+				t.Errorf("Expected sequence %d, got %d", last+1, action.Sequence())
+			}
+			last = action.Sequence()
+		}
+	}
+	if snapshot.Sequence != last {
+		t.Errorf("Expected snapshot sequence %d, got %d", last, snapshot.Sequence)
+	}
+}
+
+func TestExchange_BookUpdatesRebuildDepth(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	// Rebuild the Level 2 book of each side from the incremental book updates alone
+	rebuilt := map[Side]map[Price]PriceLevel{Bid: {}, Ask: {}}
+	apply := func() {
+		for _, action := range drainActions(&exchange, actions) {
+			if action.Type() != ActionBookUpdate {
+				continue
+			}
+			update := action.BookUpdate()
+			levels := rebuilt[update.Side]
+			_, exists := levels[update.Price]
+			switch update.Update {
+			case LevelAdd:
+				if exists {
+					t.Errorf("Unexpected add of an existing level %+v", update)
+				}
+				levels[update.Price] = PriceLevel{Price: update.Price, Size: update.Size, Orders: update.Orders}
+			case LevelChange:
+				if !exists {
+					t.Errorf("Unexpected change of a missing level %+v", update)
+				}
+				levels[update.Price] = PriceLevel{Price: update.Price, Size: update.Size, Orders: update.Orders}
+			case LevelDelete:
+				if !exists {
+					t.Errorf("Unexpected delete of a missing level %+v", update)
+				}
+				delete(levels, update.Price)
+			}
+		}
+	}
+	check := func(step string) {
+		apply()
+		depth := exchange.Depth("AAPL", 0)
+		for side, want := range map[Side][]PriceLevel{Bid: depth.Bids, Ask: depth.Asks} {
+			if len(rebuilt[side]) != len(want) {
+				t.Errorf("%s: expected %d levels on side %v, rebuilt %d", step, len(want), side, len(rebuilt[side]))
+			}
+			for _, level := range want {
+				if rebuilt[side][level.Price] != level {
+					t.Errorf("%s: expected level %+v, rebuilt %+v", step, level, rebuilt[side][level.Price])
+				}
+			}
+		}
+	}
+
+	first, _ := exchange.Limit("AAPL", 100, 10, Bid, 1)
+	exchange.Limit("AAPL", 100, 5, Bid, 2)
+	exchange.Limit("AAPL", 99, 7, Bid, 3)
+	exchange.Limit("AAPL", 102, 4, Ask, 4)
+	exchange.Limit("AAPL", 103, 6, Ask, 4)
+	check("resting orders")
+
+	// Sweep through the bid levels, leaving the remainder resting on the ask side
+	exchange.Limit("AAPL", 99, 30, Ask, 5)
+	check("sweep")
+
+	exchange.Limit("AAPL", 98, 3, Bid, 6)
+	exchange.Modify(first, 100, 5)
+	second, _ := exchange.Limit("AAPL", 98, 3, Bid, 6)
+	exchange.Modify(second, 97, 3)
+	check("modify")
+
+	exchange.Cancel(second)
+	exchange.Market("AAPL", 2, Bid, 7)
+	exchange.CancelAll(4)
+	check("cancels")
+}
+
+func TestExchange_BookUpdatesUnchangedLevel(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	orderID, _ := exchange.Limit("AAPL", 100, 10, Bid, 1)
+	drainActions(&exchange, actions)
+
+	// An amendment to the same price and size is made in place, leaving the level unchanged
+	exchange.Modify(orderID, 100, 10)
+	for _, action := range drainActions(&exchange, actions) {
+		if action.Type() == ActionBookUpdate {
+			t.Errorf("Expected no book update for an unchanged level, got %v", action)
+		}
+	}
+
+	// Removing the only order and re-inserting it within one operation changes the level and then restores it
+	ob := exchange.getOrCreateOrderBook("AAPL")
+	ob.mutex.Lock()
+	exchange.mutex.Lock()
+	node := exchange.orderIDMap[orderID]
+	order := node.order
+	ob.removeFromBook(node)
+	delete(exchange.orderIDMap, orderID)
+	exchange.mutex.Unlock()
+	ob.insertIntoBook(&order)
+	ob.updateMarketData()
+	ob.mutex.Unlock()
+
+	for _, action := range drainActions(&exchange, actions) {
+		if action.Type() == ActionBookUpdate || action.Type() == ActionBBO {
+			t.Errorf("Expected no market data for a level changed then restored, got %v", action)
+		}
+	}
+}
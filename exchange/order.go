@@ -1,11 +1,14 @@
 package exchange
 
+import "time"
+
 // Define the types used in the exchange. These are used to represent the orderbook, orders, and traders
 type OrderID uint64  // Unique identifier for an order [range 0-2^64]
 type Side uint8      // Bid or Ask
 type Price uint32    // Price in ticks (eg. 12345 would be 123.45) [range 0-2^32]
 type Size uint32     // Size integer [range 0-2^32]
 type TraderID uint16 // Unique identifier for a trader [range 0-2^16]
+type TradeID uint64  // Unique identifier for an execution [range 0-2^64]
 
 // Define the two sides of an order
 const (
@@ -13,6 +16,18 @@ const (
 	Ask             // Ask side represents a sell order
 )
 
+// TimeInForce represents how long an order remains working on the exchange
+type TimeInForce uint8
+
+// Define the time in force instructions of an order
+const (
+	GTC TimeInForce = iota // Good-till-cancel: rests in the book until filled or cancelled
+	IOC                    // Immediate-or-cancel: fills what it can immediately, the remainder is cancelled
+	FOK                    // Fill-or-kill: fills completely and immediately, otherwise cancelled untouched
+	DAY                    // Day: rests in the book until the end of the trading session
+	GTD                    // Good-till-date: rests in the book until its expiry time
+)
+
 // Order represents an order on the exchange
 type Order struct {
 	orderID OrderID
@@ -20,5 +35,129 @@ type Order struct {
 	size    Size
 	side    Side
 	trader  TraderID
-	symbol  string // Symbol of the order (eg. AAPL, GOOGL)
+	symbol  string      // Symbol of the order (eg. AAPL, GOOGL)
+	tif     TimeInForce // Time in force of the order (GTC by default)
+	expiry  int64       // Expiry time of a GTD order, in Unix nanoseconds
+
+	clientOrderID string // Client-supplied reference for the order (eg. FIX ClOrdID), echoed back on every action
+
+	original Size   // Original size of the order (the filled size plus the new size, after an amendment)
+	filled   Size   // Cumulative size filled
+	notional uint64 // Cumulative filled price × size, for the average fill price
+}
+
+// OrderState represents the lifecycle state of an order on the exchange
+type OrderState uint8
+
+// Define the lifecycle states of an order
+const (
+	OrderNew             OrderState = iota // Working, with nothing filled
+	OrderPartiallyFilled                   // Working, with some of the order filled
+	OrderFilled                            // Closed, completely filled
+	OrderCancelled                         // Closed, cancelled (including the unfilled remainder of IOC and market orders)
+	OrderExpired                           // Closed, DAY or GTD order expired
+	OrderRejected                          // Closed, FOK order killed untouched as it could not be filled completely
+)
+
+// OrderStatus represents the state of an order, as returned by an order status query
+type OrderStatus struct {
+	OrderID       OrderID
+	Symbol        string
+	Side          Side
+	Trader        TraderID
+	ClientOrderID string
+	State         OrderState
+	OriginalSize  Size
+	FilledSize    Size    // Cumulative size filled
+	AvgFillPrice  float64 // Average fill price in ticks (zero if nothing filled)
+	RemainingSize Size    // Size still working (zero once the order is closed)
+}
+
+// OrderRequest represents an incoming limit order, along with its optional order instructions
+type OrderRequest struct {
+	Symbol      string
+	Price       Price
+	Size        Size
+	Side        Side
+	Trader      TraderID
+	TimeInForce TimeInForce // Defaults to GTC
+	ExpireAt    time.Time   // Expiry time, required for (and only used by) GTD orders
+
+	ClientOrderID string // Optional client-supplied reference, unique among the trader's working orders
+}
+
+// recordFill adds a fill of the given size and price to the order's cumulative fill statistics
+func (order *Order) recordFill(size Size, price Price) {
+	order.filled += size
+	order.notional += uint64(size) * uint64(price)
+}
+
+// status returns the status of the order in the given state
+// A closed order has nothing remaining, whatever size it was last reported with
+func (order *Order) status(state OrderState) OrderStatus {
+	status := OrderStatus{
+		OrderID:       order.orderID,
+		Symbol:        order.symbol,
+		Side:          order.side,
+		Trader:        order.trader,
+		ClientOrderID: order.clientOrderID,
+		State:         state,
+		OriginalSize:  order.original,
+		FilledSize:    order.filled,
+	}
+	if order.filled > 0 {
+		status.AvgFillPrice = float64(order.notional) / float64(order.filled)
+	}
+	if state == OrderNew || state == OrderPartiallyFilled {
+		status.RemainingSize = order.size
+	}
+	return status
+}
+
+// OrderID returns the unique identifier assigned to the order by the exchange
+func (order Order) OrderID() OrderID {
+	return order.orderID
+}
+
+// Symbol returns the symbol of the order (eg. AAPL, GOOGL)
+func (order Order) Symbol() string {
+	return order.symbol
+}
+
+// Side returns the side of the order (Bid or Ask)
+func (order Order) Side() Side {
+	return order.side
+}
+
+// Price returns the limit price of the order, in ticks
+func (order Order) Price() Price {
+	return order.price
+}
+
+// Size returns the size of the order, as at the time it was reported
+func (order Order) Size() Size {
+	return order.size
+}
+
+// Trader returns the trader that entered the order
+func (order Order) Trader() TraderID {
+	return order.trader
+}
+
+// TimeInForce returns the time in force instruction of the order
+func (order Order) TimeInForce() TimeInForce {
+	return order.tif
+}
+
+// ExpireAt returns the expiry time of a GTD order (the zero time for other orders)
+func (order Order) ExpireAt() time.Time {
+	if order.tif != GTD {
+		return time.Time{}
+	}
+	return time.Unix(0, order.expiry)
+}
+
+// ClientOrderID returns the client-supplied reference of the order (empty if none was given)
+func (order Order) ClientOrderID() string {
+	return order.clientOrderID
 }
@@ -2,6 +2,7 @@ package exchange
 
 import (
 	"testing"
+	"time"
 )
 
 func TestOrderCreation(t *testing.T) {
@@ -61,3 +62,45 @@ func TestOrderSize(t *testing.T) {
 		t.Errorf("Expected size to be 100, got %d", order.size)
 	}
 }
+
+func TestOrderAccessors(t *testing.T) {
+	expireAt := time.Unix(0, 1_700_000_000_000_000_000)
+	order := Order{
+		orderID:       1,
+		price:         12345,
+		size:          100,
+		side:          Ask,
+		trader:        2,
+		symbol:        "TEST",
+		tif:           GTD,
+		expiry:        expireAt.UnixNano(),
+		clientOrderID: "C1",
+	}
+
+	if order.OrderID() != 1 || order.Price() != 12345 || order.Size() != 100 || order.Side() != Ask {
+		t.Errorf("Expected accessors to return the order fields, got %v", order)
+	}
+	if order.Trader() != 2 || order.Symbol() != "TEST" || order.ClientOrderID() != "C1" {
+		t.Errorf("Expected accessors to return the order fields, got %v", order)
+	}
+	if order.TimeInForce() != GTD || !order.ExpireAt().Equal(expireAt) {
+		t.Errorf("Expected the GTD expiry to be %v, got %v", expireAt, order.ExpireAt())
+	}
+	if !(Order{tif: DAY}).ExpireAt().IsZero() {
+		t.Errorf("Expected no expiry time for a non-GTD order")
+	}
+}
+
+func TestOrderStatus(t *testing.T) {
+	order := Order{orderID: 1, symbol: "TEST", side: Bid, price: 100, size: 10, trader: 1, original: 20}
+	order.recordFill(5, 99)
+	order.recordFill(5, 102)
+
+	status := order.status(OrderPartiallyFilled)
+	if status.FilledSize != 10 || status.AvgFillPrice != 100.5 || status.RemainingSize != 10 || status.OriginalSize != 20 {
+		t.Errorf("Expected 10 filled at an average of 100.5 with 10 remaining, got %+v", status)
+	}
+	if status := order.status(OrderCancelled); status.RemainingSize != 0 {
+		t.Errorf("Expected a closed order to have nothing remaining, got %d", status.RemainingSize)
+	}
+}
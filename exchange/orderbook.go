@@ -3,14 +3,25 @@ package exchange
 import (
 	"sync"
 
-	"github.com/gammazero/deque"
 	"github.com/google/btree"
 )
 
-// PricePoint represents a price level and its associated orders Deque in the orderbook
+// orderNode represents a resting order, intrusively linked into the queue of its PricePoint
+// Holding the links on the order allows it to be removed from its price level in O(1)
+type orderNode struct {
+	order Order
+	level *PricePoint
+	prev  *orderNode
+	next  *orderNode
+}
+
+// PricePoint represents a price level and its associated queue of resting orders (in time priority) in the orderbook
 type PricePoint struct {
 	price  Price
-	orders deque.Deque[OrderID]
+	head   *orderNode // Oldest order at the price level, first to be filled
+	tail   *orderNode // Newest order at the price level
+	count  int        // Number of orders at the price level
+	volume Size       // Total remaining size of the orders at the price level
 	mutex  sync.Mutex
 }
 
@@ -19,13 +30,51 @@ func (p *PricePoint) Less(than btree.Item) bool {
 	return p.price < than.(*PricePoint).price
 }
 
+// pushBack adds a resting order to the back of the price point's queue
+func (p *PricePoint) pushBack(node *orderNode) {
+	node.level = p
+	node.prev = p.tail
+	node.next = nil
+	if p.tail != nil {
+		p.tail.next = node
+	} else {
+		p.head = node
+	}
+	p.tail = node
+	p.count += 1
+	p.volume += node.order.size
+}
+
+// remove unlinks a resting order from the price point's queue
+func (p *PricePoint) remove(node *orderNode) {
+	if node.prev != nil {
+		node.prev.next = node.next
+	} else {
+		p.head = node.next
+	}
+	if node.next != nil {
+		node.next.prev = node.prev
+	} else {
+		p.tail = node.prev
+	}
+	node.level = nil
+	node.prev = nil
+	node.next = nil
+	p.count -= 1
+	p.volume -= node.order.size
+}
+
 // OrderBook represents the collection of asks and bids, for a specific symbol on the exchange
 type OrderBook struct {
-	symbol   string
-	asks     *btree.BTree
-	bids     *btree.BTree
-	exchange *Exchange
-	mutex    sync.RWMutex
+	symbol    string
+	asks      *btree.BTree
+	bids      *btree.BTree
+	exchange  *Exchange
+	lastBBO   BBO                     // Top of book as last reported, to detect changes
+	sequence  uint64                  // Sequence number of the last action published for the orderbook
+	lastTrade Price                   // Price of the last execution, the reference price for a price collar (0 before the first)
+	touched   map[levelKey]PriceLevel // Price levels changed by the current operation, as they were before it
+	mutex     sync.RWMutex
 }
 
 // init initialises the OrderBook with the given symbol and exchange and creates the btrees
@@ -39,20 +88,40 @@ func (ob *OrderBook) init(symbol string, exchange *Exchange) {
 
 	ob.asks = btree.New(int(MaxPrice))
 	ob.bids = btree.New(int(MaxPrice))
+	ob.touched = make(map[levelKey]PriceLevel)
+}
+
+// publish stamps the action with the orderbook's next sequence number and publishes it via the exchange
+// The orderbook mutex must be held by the caller, so the sequence matches the order the book was updated in
+func (ob *OrderBook) publish(action *Action) {
+	ob.sequence += 1
+	action.sequence = ob.sequence
+	ob.exchange.publish(action)
 }
 
 // limitHandle processes an incoming order in the following manner:
-// 1. Immediately try to fill the incoming order
-// 2. If the order is unfilled or partially filled, insert it into the orderbook
+// 1. For FOK orders, check enough liquidity is available, otherwise cancel the order untouched
+// 2. Immediately try to fill the incoming order
+// 3. If the order is unfilled or partially filled, insert it into the orderbook (or cancel the remainder for IOC)
 func (ob *OrderBook) limitHandle(incoming_order Order) {
 	// Lock the orderbook mutex to prevent concurrent access
 	ob.mutex.Lock()
 	defer ob.mutex.Unlock()
 
+	// Report any changes to the price levels and top of book once the orderbook has been updated
+	defer ob.updateMarketData()
+
 	order := incoming_order
 
 	// Report the incoming order to the exchange via the actions channel
-	ob.exchange.actions <- newOrderAction(&order)
+	ob.publish(newOrderAction(&order))
+
+	// FOK orders must be completely fillable before touching the book
+	if order.tif == FOK && ob.availableLiquidity(&order) < order.size {
+		ob.publish(newRemainderCancelAction(&order))
+		ob.exchange.closeOrder(&order, OrderRejected)
+		return
+	}
 
 	// Try to immediately fill the incoming order
 	if order.side == Bid {
@@ -62,9 +131,292 @@ func (ob *OrderBook) limitHandle(incoming_order Order) {
 	}
 
 	// If unfilled (or partially filled), insert into the orderbook
+	// IOC orders never rest, so the remainder is reported as cancelled instead
+	if order.size > 0 {
+		if order.tif == IOC {
+			ob.publish(newRemainderCancelAction(&order))
+			ob.exchange.closeOrder(&order, OrderCancelled)
+		} else {
+			ob.insertIntoBook(&order)
+		}
+	} else {
+		ob.exchange.closeOrder(&order, OrderFilled)
+	}
+}
+
+// availableLiquidity returns the opposite side size the order could fill against at its limit price
+// The walk stops early once enough liquidity has been found to fill the order completely
+func (ob *OrderBook) availableLiquidity(order *Order) Size {
+	// Lock the exchange mutex (for reading) to look up the book order sizes
+	ob.exchange.mutex.RLock()
+	defer ob.exchange.mutex.RUnlock()
+
+	var available Size
+
+	// Sum the order sizes at a price point, stopping once the order is covered
+	visit := func(i btree.Item) bool {
+		pp := i.(*PricePoint)
+		if (order.side == Bid && pp.price > order.price) || (order.side == Ask && pp.price < order.price) {
+			return false
+		}
+		for node := pp.head; node != nil && available < order.size; node = node.next {
+			available += node.order.size
+		}
+		return available < order.size
+	}
+
+	// Walk the asks from lowest price for bids, and the bids from highest price for asks
+	if order.side == Bid {
+		ob.asks.Ascend(visit)
+	} else {
+		ob.bids.Descend(visit)
+	}
+	return available
+}
+
+// modifyHandle amends the price and/or size of a resting order in the following manner:
+// 1. A pure size decrease is applied in place, keeping the order's time priority
+// 2. Otherwise the order is removed from its price point and re-entered at the back of the queue at its new price,
+// trying to fill it immediately first (as the new price may cross the book)
+// A new price is first checked against the symbol's price collar (clipping it, or rejecting the amendment),
+// and an amendment raising the size or price is run through the pre-trade risk checks, as if it were a new order
+// Returns the reject reason (and the failing risk rule, if any) if the amendment is rejected, otherwise RejectNone
+func (ob *OrderBook) modifyHandle(orderID OrderID, newPrice Price, newSize Size) (RejectReason, string) {
+	// Lock the orderbook mutex to prevent concurrent access
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	// Report any changes to the price levels and top of book once the orderbook has been updated
+	defer ob.updateMarketData()
+
+	// Lock the exchange mutex to look up and amend the resting order
+	ob.exchange.mutex.Lock()
+
+	// The order may have been filled or cancelled since the modify was received
+	node, ok := ob.exchange.orderIDMap[orderID]
+	if !ok {
+		amendment := ob.exchange.rejectedOrder(orderID)
+		amendment.price, amendment.size = newPrice, newSize
+		reason := ob.exchange.lookupRejectReason(orderID)
+		ob.publish(newReplaceRejectAction(&amendment, reason))
+		ob.exchange.mutex.Unlock()
+		return reason, ""
+	}
+
+	// Check a new price against the symbol's price collar, clipping it or rejecting the amendment if outside the band
+	if collar, collared := ob.exchange.collars[ob.symbol]; collared && newPrice != node.order.price {
+		var reason RejectReason
+		if newPrice, reason = ob.collarPrice(collar, node.order.side, newPrice); reason != RejectNone {
+			amendment := Order{orderID: orderID, price: newPrice, size: newSize}
+			ob.publish(newReplaceRejectAction(&amendment, reason))
+			ob.exchange.mutex.Unlock()
+			return reason, ""
+		}
+	}
+
+	// Run the pre-trade risk checks on an amendment raising the size or price, in place of the working order
+	if newSize > node.order.size || newPrice > node.order.price {
+		amended := node.order
+		amended.price = newPrice
+		amended.size = newSize
+		if rule := ob.exchange.risk.check(&amended, &node.order, ob.exchange.traderRiskLimits(node.order.trader)); rule != "" {
+			amendment := Order{orderID: orderID, price: newPrice, size: newSize}
+			ob.publish(newRiskReplaceRejectAction(&amendment, rule))
+			ob.exchange.mutex.Unlock()
+			return RejectRiskLimit, rule
+		}
+	}
+
+	// A size decrease at the same price keeps its place in the PricePoint queue
+	if newPrice == node.order.price && newSize <= node.order.size {
+		ob.touch(node.order.side, node.order.price)
+		ob.exchange.risk.amend(&node.order, newSize)
+		node.level.volume -= node.order.size - newSize
+		node.order.size = newSize
+		node.order.original = node.order.filled + newSize
+		ob.publish(newReplaceAction(&node.order))
+		ob.exchange.mutex.Unlock()
+		return RejectNone, ""
+	}
+
+	// Otherwise the order loses its time priority, so remove it from the orderbook and orderIDMap
+	order := node.order
+	ob.removeFromBook(node)
+	delete(ob.exchange.orderIDMap, orderID)
+	ob.exchange.mutex.Unlock()
+
+	ob.exchange.risk.amend(&order, newSize)
+	order.price = newPrice
+	order.size = newSize
+	order.original = order.filled + newSize
+
+	// Report the replaced order to the exchange via the actions channel
+	ob.publish(newReplaceAction(&order))
+
+	// Try to immediately fill the replaced order, as the new price may cross the book
+	if order.side == Bid {
+		ob.fillBidSide(&order)
+	} else {
+		ob.fillAskSide(&order)
+	}
+
+	// If unfilled (or partially filled), insert into the back of the queue at the new price
 	if order.size > 0 {
 		ob.insertIntoBook(&order)
+	} else {
+		ob.exchange.closeOrder(&order, OrderFilled)
+	}
+	return RejectNone, ""
+}
+
+// cancelHandle removes a resting order from the orderbook (and orderIDMap), reporting the cancellation
+// Returns the reject reason if the order is no longer working, otherwise RejectNone
+func (ob *OrderBook) cancelHandle(orderID OrderID) RejectReason {
+	// Lock the orderbook mutex to prevent concurrent access
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	// Report any changes to the price levels and top of book once the orderbook has been updated
+	defer ob.updateMarketData()
+
+	// Lock the exchange mutex to look up and remove the resting order
+	ob.exchange.mutex.Lock()
+	defer ob.exchange.mutex.Unlock()
+
+	// The order may have been filled or cancelled since the cancel was received
+	node, ok := ob.exchange.orderIDMap[orderID]
+	if !ok {
+		reason := ob.exchange.lookupRejectReason(orderID)
+		rejected := ob.exchange.rejectedOrder(orderID)
+		ob.publish(newCancelRejectAction(&rejected, reason))
+		return reason
+	}
+
+	ob.cancelResting(node)
+	return RejectNone
+}
+
+// cancelResting removes a resting order from its price point and the orderIDMap, reporting the cancellation
+// The orderbook and exchange mutexes must be held by the caller
+func (ob *OrderBook) cancelResting(node *orderNode) {
+	ob.removeFromBook(node)
+	delete(ob.exchange.orderIDMap, node.order.orderID)
+
+	// Report the cancellation (with a size of zero, as nothing remains working) via the actions channel
+	cancelOrder := node.order
+	cancelOrder.size = 0
+	ob.publish(newCancelAction(&cancelOrder))
+	ob.exchange.recordClosed(&cancelOrder, OrderCancelled)
+}
+
+// collectResting appends the resting orders matching the filter to nodes, walking the price points of each side
+// The orderbook mutex must be held by the caller
+func (ob *OrderBook) collectResting(filter MassCancelFilter, nodes []*orderNode) []*orderNode {
+	for _, side := range []Side{Bid, Ask} {
+		if filter.BySide && filter.Side != side {
+			continue
+		}
+		ob.sideTree(side).Ascend(func(i btree.Item) bool {
+			for node := i.(*PricePoint).head; node != nil; node = node.next {
+				if filter.Trader == 0 || filter.Trader == node.order.trader {
+					nodes = append(nodes, node)
+				}
+			}
+			return true
+		})
+	}
+	return nodes
+}
+
+// expireHandle removes a resting order from the orderbook (and orderIDMap), reporting the expiry
+func (ob *OrderBook) expireHandle(orderID OrderID) {
+	// Lock the orderbook mutex to prevent concurrent access
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	// Report any changes to the price levels and top of book once the orderbook has been updated
+	defer ob.updateMarketData()
+
+	// Lock the exchange mutex to look up and remove the resting order
+	ob.exchange.mutex.Lock()
+	defer ob.exchange.mutex.Unlock()
+
+	// The order may have been filled or cancelled since it was found to have expired
+	node, ok := ob.exchange.orderIDMap[orderID]
+	if !ok {
+		return
 	}
+
+	// Remove the order from its price point and the orderIDMap
+	ob.removeFromBook(node)
+	delete(ob.exchange.orderIDMap, orderID)
+
+	// Report the expiry (with the expired size) to the exchange via the actions channel
+	ob.publish(newExpireAction(&node.order))
+	ob.exchange.recordClosed(&node.order, OrderExpired)
+}
+
+// marketHandle processes an incoming market order in the following manner:
+// 1. Set the order price to the sweep limit (optionally collared by the protection ticks)
+// 2. Immediately try to fill the incoming order against the opposite side
+// 3. If the order is unfilled or partially filled, cancel the remainder (market orders never rest)
+func (ob *OrderBook) marketHandle(incoming_order Order, protection Price) {
+	// Lock the orderbook mutex to prevent concurrent access
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	// Report any changes to the price levels and top of book once the orderbook has been updated
+	defer ob.updateMarketData()
+
+	order := incoming_order
+	order.price = ob.marketLimitPrice(order.side, protection)
+
+	// Report the incoming order to the exchange via the actions channel
+	ob.publish(newOrderAction(&order))
+
+	// Try to immediately fill the incoming order
+	if order.side == Bid {
+		ob.fillBidSide(&order)
+	} else {
+		ob.fillAskSide(&order)
+	}
+
+	// If unfilled (or partially filled), report the remainder as cancelled rather than inserting into the orderbook
+	if order.size > 0 {
+		ob.publish(newRemainderCancelAction(&order))
+		ob.exchange.closeOrder(&order, OrderCancelled)
+	} else {
+		ob.exchange.closeOrder(&order, OrderFilled)
+	}
+}
+
+// marketLimitPrice returns the worst price a market order on the given side may trade at
+// With zero protection the order may sweep the whole book (up to MaxPrice or down to MinPrice)
+// Otherwise the order may trade at most protection ticks through the opposite best price
+func (ob *OrderBook) marketLimitPrice(side Side, protection Price) Price {
+	if side == Bid {
+		minAsk := ob.asks.Min()
+		if protection == 0 || minAsk == nil {
+			return MaxPrice
+		}
+		// Collar the bid above the best ask, capped at MaxPrice
+		best := minAsk.(*PricePoint).price
+		if best > MaxPrice-protection {
+			return MaxPrice
+		}
+		return best + protection
+	}
+
+	maxBid := ob.bids.Max()
+	if protection == 0 || maxBid == nil {
+		return MinPrice
+	}
+	// Collar the ask below the best bid, floored at MinPrice
+	best := maxBid.(*PricePoint).price
+	if best < MinPrice+protection {
+		return MinPrice
+	}
+	return best - protection
 }
 
 // fillBidSide attempts to fill an incoming bid order by matching it with the lowest ask prices
@@ -76,6 +428,7 @@ func (ob *OrderBook) fillBidSide(order *Order) {
 	}
 
 	// Iterate through the existing book asks from lowest to highest price
+	var emptied []*PricePoint
 	ob.asks.AscendGreaterOrEqual(minAsk, func(i btree.Item) bool {
 		pp := i.(*PricePoint)
 
@@ -89,19 +442,24 @@ func (ob *OrderBook) fillBidSide(order *Order) {
 		}
 
 		// Fill the incoming bid order with the existing book asks
-		for pp.orders.Len() > 0 && order.size > 0 {
-			ob.fillOrder(order, &pp.orders)
+		for pp.head != nil && order.size > 0 {
+			ob.fillOrder(order, pp)
 		}
 
-		// If the price point is empty, remove it from the orderbook
-		if pp.orders.Len() == 0 {
-			ob.asks.Delete(pp)
+		// If the price point is empty, mark it for removal from the orderbook
+		if pp.head == nil {
+			emptied = append(emptied, pp)
 		} else {
 			// Otherwise, replace the price point in the orderbook
 			ob.asks.ReplaceOrInsert(pp)
 		}
 		return true
 	})
+
+	// Remove the emptied price points (the btree must not be restructured while iterating)
+	for _, pp := range emptied {
+		ob.asks.Delete(pp)
+	}
 }
 
 // fillAskSide attempts to fill an incoming ask order by matching it with the highest bid prices
@@ -113,6 +471,7 @@ func (ob *OrderBook) fillAskSide(order *Order) {
 	}
 
 	// Iterate through the existing book bids from highest to lowest price
+	var emptied []*PricePoint
 	ob.bids.DescendLessOrEqual(maxBid, func(i btree.Item) bool {
 		pp := i.(*PricePoint)
 
@@ -126,96 +485,140 @@ func (ob *OrderBook) fillAskSide(order *Order) {
 		}
 
 		// Fill the incoming ask order with the existing book bids
-		for pp.orders.Len() > 0 && order.size > 0 {
-			ob.fillOrder(order, &pp.orders)
+		for pp.head != nil && order.size > 0 {
+			ob.fillOrder(order, pp)
 		}
 
-		// If the price point is empty, remove it from the orderbook
-		if pp.orders.Len() == 0 {
-			ob.bids.Delete(pp)
+		// If the price point is empty, mark it for removal from the orderbook
+		if pp.head == nil {
+			emptied = append(emptied, pp)
 		} else {
 			// Otherwise, replace the price point in the orderbook
 			ob.bids.ReplaceOrInsert(pp)
 		}
 		return true
 	})
+
+	// Remove the emptied price points (the btree must not be restructured while iterating)
+	for _, pp := range emptied {
+		ob.bids.Delete(pp)
+	}
 }
 
-// fillOrder fills an incoming order with the existing book orders
-func (ob *OrderBook) fillOrder(order *Order, entries *deque.Deque[OrderID]) {
+// fillOrder fills an incoming order with the order at the front of the price point's queue
+func (ob *OrderBook) fillOrder(order *Order, pp *PricePoint) {
 	// Lock the exchange mutex to prevent concurrent access
 	ob.exchange.mutex.Lock()
 	defer ob.exchange.mutex.Unlock()
 
-	// Look up the order in the orderIDMap by the orderID
-	if entry, ok := ob.exchange.orderIDMap[entries.Front()]; ok {
-		// The existing book order is larger than the incoming order
-		// Therefore, the incoming order is completely filled
-		if entry.size > order.size {
-			// Report the trade to the exchange via the actions channel
-			ob.exchange.actions <- newExecuteAction(order, &entry, order.size)
+	entry := pp.head
+	ob.touch(entry.order.side, pp.price)
 
-			// Reduce the existing book order size by the incoming order size and update the orderIDMap
-			entry.size -= order.size
-			ob.exchange.orderIDMap[entries.Front()] = entry
+	// The existing book order is larger than the incoming order
+	// Therefore, the incoming order is completely filled
+	if entry.order.size > order.size {
+		// Report the trade (privately, and then publicly) to the exchange via the actions channel
+		ob.reportTrade(order, &entry.order, order.size)
 
-			// Reduce the incoming order size to zero to show that no further trades are possible
-			order.size = 0
-		} else {
-			// The existing book order is smaller than the incoming order
-			// Therefore, the incoming order is partially filled
+		// Reduce the existing book order size by the incoming order size (in place, keeping its time priority)
+		entry.order.recordFill(order.size, entry.order.price)
+		order.recordFill(order.size, entry.order.price)
+		entry.order.size -= order.size
+		pp.volume -= order.size
 
-			// Skip and remove cancelled orders (which have a size of zero from the cancel function)
-			if entry.size == 0 {
-				entries.PopFront()
-				return
-			}
+		// Reduce the incoming order size to zero to show that no further trades are possible
+		order.size = 0
+	} else {
+		// The existing book order is smaller than the incoming order
+		// Therefore, the incoming order is partially filled
 
-			// Report the trade to the exchange via the actions channel
-			ob.exchange.actions <- newExecuteAction(order, &entry, entry.size)
+		// Report the trade (privately, and then publicly) to the exchange via the actions channel
+		ob.reportTrade(order, &entry.order, entry.order.size)
 
-			// Reduce the incoming order size by the existing book order size
-			order.size -= entry.size
+		// Reduce the incoming order size by the existing book order size
+		entry.order.recordFill(entry.order.size, entry.order.price)
+		order.recordFill(entry.order.size, entry.order.price)
+		order.size -= entry.order.size
 
-			// Remove the existing book order from the orderbook and orderIDMap
-			entries.PopFront()
-			delete(ob.exchange.orderIDMap, entry.orderID)
-		}
-	} else {
-		// The orderID is cannot be found in the orderIDMap, so remove it from the orderbook
-		entries.PopFront()
+		// Remove the existing book order from the orderbook and orderIDMap
+		pp.remove(entry)
+		delete(ob.exchange.orderIDMap, entry.order.orderID)
+		ob.exchange.recordClosed(&entry.order, OrderFilled)
 	}
 }
 
+// reportTrade assigns the next TradeID to an execution between the incoming order and a book order,
+// and reports it both as an execution (with the orders and traders) and as an anonymous public trade
+// The exchange mutex must be held by the caller
+func (ob *OrderBook) reportTrade(order *Order, entry *Order, fill_size Size) {
+	ob.exchange.risk.fill(order, fill_size)
+	ob.exchange.risk.fill(entry, fill_size)
+
+	tradeID := ob.exchange.nextTradeID()
+	ob.lastTrade = entry.price
+	ob.publish(newExecuteAction(order, entry, fill_size, tradeID))
+	ob.publish(newTradeAction(Trade{
+		TradeID:       tradeID,
+		Symbol:        order.symbol,
+		Price:         entry.price,
+		Size:          fill_size,
+		AggressorSide: order.side,
+	}))
+}
+
 // insertIntoBook inserts an incoming order into the appropriate btree of the orderbook
 func (ob *OrderBook) insertIntoBook(order *Order) {
+	ob.touch(order.side, order.price)
 
 	// Select the appropriate btree based on the order side
-	var tree *btree.BTree
-	if order.side == Bid {
-		tree = ob.bids
-	} else {
-		tree = ob.asks
-	}
+	tree := ob.sideTree(order.side)
 
 	// Create a new PricePoint with the order price
 	pp := &PricePoint{price: order.price}
 
-	// Check if the price point already exists in the orderbook
+	// Check if the price point already exists in the orderbook, otherwise insert the new price point
 	if item := tree.Get(pp); item != nil {
 		pp = item.(*PricePoint)
+	} else {
+		tree.ReplaceOrInsert(pp)
 	}
 
-	// Add the order to the price point's orders deque (while protected by a PricePoint mutex)
+	// Add the order to the back of the price point's queue (while protected by a PricePoint mutex)
+	node := &orderNode{order: *order}
 	pp.mutex.Lock()
-	pp.orders.PushBack(order.orderID)
+	pp.pushBack(node)
 	pp.mutex.Unlock()
 
-	// Insert the price point into the orderbook
-	tree.ReplaceOrInsert(pp)
-
-	// Update the orderIDMap with the order details (while protected by an orderIDMap mutex)
+	// Update the orderIDMap with the resting order (while protected by an orderIDMap mutex)
 	ob.exchange.mutex.Lock()
-	ob.exchange.orderIDMap[order.orderID] = *order
+	ob.exchange.orderIDMap[order.orderID] = node
 	ob.exchange.mutex.Unlock()
 }
+
+// removeFromBook unlinks a resting order from its price point in O(1), deleting the price point if it becomes empty
+func (ob *OrderBook) removeFromBook(node *orderNode) {
+	pp := node.level
+	if pp == nil {
+		return
+	}
+	ob.touch(node.order.side, pp.price)
+
+	// Remove the order from the price point's queue (while protected by a PricePoint mutex)
+	pp.mutex.Lock()
+	pp.remove(node)
+	empty := pp.head == nil
+	pp.mutex.Unlock()
+
+	// If the price point is empty, remove it from the orderbook
+	if empty {
+		ob.sideTree(node.order.side).Delete(pp)
+	}
+}
+
+// sideTree returns the btree of price points for the given side of the orderbook
+func (ob *OrderBook) sideTree(side Side) *btree.BTree {
+	if side == Bid {
+		return ob.bids
+	}
+	return ob.asks
+}
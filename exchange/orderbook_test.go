@@ -23,16 +23,18 @@ func TestOrderBookInit(t *testing.T) {
 func TestOrderBookLimitHandle(t *testing.T) {
 	actions := make(chan *Action, ChanSize)
 	var exchange_engine Exchange
-	exchange_engine.Init("TEST", actions)
+	exchange_engine.Init("TEST", actions, SubscribeOptions{})
 
 	ob := &OrderBook{}
 	ob.init("TEST", &exchange_engine)
 
 	order := Order{orderID: 1, price: 100, size: 10, side: Bid, trader: 1}
 	ob.limitHandle(order)
+	exchange_engine.Flush()
 
-	if len(exchange_engine.actions) != 1 {
-		t.Errorf("Expected 1 action, got %d", len(exchange_engine.actions))
+	// The order action, followed by the new price level and the change to the top of book
+	if len(exchange_engine.actions) != 3 {
+		t.Errorf("Expected 3 actions, got %d", len(exchange_engine.actions))
 	}
 	if ob.bids.Len() != 1 {
 		t.Errorf("Expected 1 bid order in the order book, got %d", ob.bids.Len())
@@ -42,7 +44,7 @@ func TestOrderBookLimitHandle(t *testing.T) {
 func TestOrderBookFillAskSide(t *testing.T) {
 	actions := make(chan *Action, ChanSize)
 	var exchange_engine Exchange
-	exchange_engine.Init("TEST", actions)
+	exchange_engine.Init("TEST", actions, SubscribeOptions{})
 
 	ob := &OrderBook{}
 	ob.init("TEST", &exchange_engine)
@@ -64,7 +66,7 @@ func TestOrderBookFillAskSide(t *testing.T) {
 func TestOrderBookFillBidSide(t *testing.T) {
 	actions := make(chan *Action, ChanSize)
 	var exchange_engine Exchange
-	exchange_engine.Init("TEST", actions)
+	exchange_engine.Init("TEST", actions, SubscribeOptions{})
 
 	ob := &OrderBook{}
 	ob.init("TEST", &exchange_engine)
@@ -86,7 +88,7 @@ func TestOrderBookFillBidSide(t *testing.T) {
 func TestOrderBookInsertIntoBook(t *testing.T) {
 	actions := make(chan *Action, ChanSize)
 	var exchange_engine Exchange
-	exchange_engine.Init("TEST", actions)
+	exchange_engine.Init("TEST", actions, SubscribeOptions{})
 
 	ob := &OrderBook{}
 	ob.init("TEST", &exchange_engine)
@@ -97,7 +99,106 @@ func TestOrderBookInsertIntoBook(t *testing.T) {
 	if ob.bids.Len() != 1 {
 		t.Errorf("Expected 1 bid order in the order book, got %d", ob.bids.Len())
 	}
-	if ob.exchange.orderIDMap[1] != order {
+	if ob.exchange.orderIDMap[1].order != order {
 		t.Errorf("Expected orderIDMap to contain the order")
 	}
 }
+
+func TestOrderBookMarketLimitPrice(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange_engine Exchange
+	exchange_engine.Init("TEST", actions, SubscribeOptions{})
+
+	ob := &OrderBook{}
+	ob.init("TEST", &exchange_engine)
+
+	if price := ob.marketLimitPrice(Bid, 5); price != MaxPrice {
+		t.Errorf("Expected unprotected bid limit of %d with an empty book, got %d", MaxPrice, price)
+	}
+
+	ob.insertIntoBook(&Order{orderID: 1, price: 100, size: 10, side: Ask, trader: 1})
+	ob.insertIntoBook(&Order{orderID: 2, price: 3, size: 10, side: Bid, trader: 1})
+
+	if price := ob.marketLimitPrice(Bid, 5); price != 105 {
+		t.Errorf("Expected protected bid limit of 105, got %d", price)
+	}
+	if price := ob.marketLimitPrice(Ask, 5); price != MinPrice {
+		t.Errorf("Expected protected ask limit to be floored at %d, got %d", MinPrice, price)
+	}
+	if price := ob.marketLimitPrice(Ask, 0); price != MinPrice {
+		t.Errorf("Expected unprotected ask limit of %d, got %d", MinPrice, price)
+	}
+}
+
+func TestOrderBookAvailableLiquidity(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange_engine Exchange
+	exchange_engine.Init("TEST", actions, SubscribeOptions{})
+
+	ob := &OrderBook{}
+	ob.init("TEST", &exchange_engine)
+
+	ob.insertIntoBook(&Order{orderID: 1, price: 100, size: 10, side: Ask, trader: 1})
+	ob.insertIntoBook(&Order{orderID: 2, price: 101, size: 10, side: Ask, trader: 1})
+	ob.insertIntoBook(&Order{orderID: 3, price: 102, size: 10, side: Ask, trader: 1})
+
+	if available := ob.availableLiquidity(&Order{price: 101, size: 50, side: Bid}); available != 20 {
+		t.Errorf("Expected 20 available at or below 101, got %d", available)
+	}
+	if available := ob.availableLiquidity(&Order{price: 102, size: 15, side: Bid}); available < 15 {
+		t.Errorf("Expected at least 15 available at or below 102, got %d", available)
+	}
+	if available := ob.availableLiquidity(&Order{price: 100, size: 10, side: Ask}); available != 0 {
+		t.Errorf("Expected no bid liquidity, got %d", available)
+	}
+}
+
+func TestOrderBookRemoveFromBook(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange_engine Exchange
+	exchange_engine.Init("TEST", actions, SubscribeOptions{})
+
+	ob := &OrderBook{}
+	ob.init("TEST", &exchange_engine)
+
+	first := Order{orderID: 1, price: 100, size: 10, side: Bid, trader: 1}
+	second := Order{orderID: 2, price: 100, size: 10, side: Bid, trader: 1}
+	ob.insertIntoBook(&first)
+	ob.insertIntoBook(&second)
+
+	ob.removeFromBook(exchange_engine.orderIDMap[1])
+	pp := ob.bids.Max().(*PricePoint)
+	if pp.count != 1 || pp.head.order.orderID != 2 || pp.tail != pp.head {
+		t.Errorf("Expected only order 2 to remain at the price point")
+	}
+
+	ob.removeFromBook(exchange_engine.orderIDMap[2])
+	if ob.bids.Len() != 0 {
+		t.Errorf("Expected the empty price point to be removed, got %d", ob.bids.Len())
+	}
+}
+
+func TestPricePointQueue(t *testing.T) {
+	pp := &PricePoint{price: 100}
+	nodes := []*orderNode{{order: Order{orderID: 1}}, {order: Order{orderID: 2}}, {order: Order{orderID: 3}}}
+	for _, node := range nodes {
+		pp.pushBack(node)
+	}
+
+	// Remove the head, the tail and then the last remaining order
+	pp.remove(nodes[0])
+	if pp.head != nodes[1] || nodes[1].prev != nil || pp.count != 2 {
+		t.Errorf("Expected order 2 to be at the head of the queue")
+	}
+	pp.remove(nodes[2])
+	if pp.tail != nodes[1] || nodes[1].next != nil || pp.count != 1 {
+		t.Errorf("Expected order 2 to be at the tail of the queue")
+	}
+	pp.remove(nodes[1])
+	if pp.head != nil || pp.tail != nil || pp.count != 0 {
+		t.Errorf("Expected the queue to be empty")
+	}
+	if nodes[1].level != nil {
+		t.Errorf("Expected the removed order to be unlinked from its price point")
+	}
+}
@@ -0,0 +1,331 @@
+package exchange
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// actionQueue represents a fixed capacity ring buffer of published actions, awaiting delivery to a subscriber
+type actionQueue struct {
+	buffer []*Action
+	head   int // Position of the oldest action
+	count  int // Number of queued actions
+}
+
+// init allocates the ring buffer with the given capacity
+func (q *actionQueue) init(capacity int) {
+	q.buffer = make([]*Action, capacity)
+	q.head = 0
+	q.count = 0
+}
+
+// full reports whether the queue is at capacity
+func (q *actionQueue) full() bool {
+	return q.count == len(q.buffer)
+}
+
+// push adds an action to the back of the queue, which must not be full
+func (q *actionQueue) push(action *Action) {
+	q.buffer[(q.head+q.count)%len(q.buffer)] = action
+	q.count += 1
+}
+
+// pop removes and returns the action at the front of the queue, which must not be empty
+func (q *actionQueue) pop() *Action {
+	action := q.buffer[q.head]
+	q.buffer[q.head] = nil
+	q.head = (q.head + 1) % len(q.buffer)
+	q.count -= 1
+	return action
+}
+
+// clear discards every queued action
+func (q *actionQueue) clear() {
+	for q.count > 0 {
+		q.pop()
+	}
+}
+
+// subscriber represents a consumer of the actions published by the exchange
+// Published actions are queued, and delivered to the subscriber's channel by its own dispatcher goroutine,
+// so that matching is not held up by the consumer (a full queue under SlowConsumerBlock holds up the next command instead)
+type subscriber struct {
+	id        int
+	filter    SubscriptionFilter
+	policy    SlowConsumerPolicy
+	actions   chan *Action
+	owned     bool // Whether the channel was created by Subscribe (and is closed when the subscriber stops)
+	queue     actionQueue
+	inFlight  bool          // Whether the dispatcher holds an action it has not yet delivered
+	closed    bool          // Whether the subscriber has stopped (unsubscribed, disconnected or the exchange closed)
+	done      chan struct{} // Closed when the subscriber stops, to release a dispatcher waiting on a full channel
+	stopped   chan struct{} // Closed when the dispatcher has exited
+	delivered uint64        // Number of actions delivered to the channel
+	dropped   uint64        // Number of actions dropped (SlowConsumerDropOldest)
+	maxDepth  int           // Highest number of actions queued at once
+	mutex     sync.Mutex
+	cond      *sync.Cond // Signalled whenever the queue or the closed flag changes
+}
+
+// newSubscriber creates a subscriber delivering to the given channel, and starts its dispatcher goroutine
+func newSubscriber(id int, filter SubscriptionFilter, policy SlowConsumerPolicy, queueSize int, actions chan *Action, owned bool) *subscriber {
+	sub := &subscriber{
+		id:      id,
+		filter:  filter,
+		policy:  policy,
+		actions: actions,
+		owned:   owned,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	sub.queue.init(queueSize)
+	sub.cond = sync.NewCond(&sub.mutex)
+
+	go sub.dispatch()
+	return sub
+}
+
+// enqueue queues the action for delivery, applying the subscriber's slow consumer policy if the queue is full
+// Returns false if the subscriber should be disconnected
+func (sub *subscriber) enqueue(action *Action) bool {
+	// Lock the subscriber mutex to prevent concurrent access with the dispatcher
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+
+	for !sub.closed && sub.queue.full() {
+		switch sub.policy {
+		case SlowConsumerBlock:
+			// Wait for the dispatcher to make room (or for the subscriber to stop)
+			sub.cond.Wait()
+		case SlowConsumerDropOldest:
+			sub.queue.pop()
+			sub.dropped += 1
+		case SlowConsumerDisconnect:
+			return false
+		}
+	}
+	if sub.closed {
+		return true
+	}
+
+	sub.queue.push(action)
+	sub.maxDepth = max(sub.maxDepth, sub.queue.count)
+	sub.cond.Broadcast()
+	return true
+}
+
+// dispatch delivers the queued actions to the subscriber's channel in order, until the subscriber stops
+// Once stopped, the queued actions that fit in the channel are still delivered, and the rest are discarded
+func (sub *subscriber) dispatch() {
+	for {
+		// Wait for an action to deliver (or for the subscriber to stop)
+		sub.mutex.Lock()
+		for sub.queue.count == 0 && !sub.closed {
+			sub.cond.Wait()
+		}
+		if sub.queue.count == 0 {
+			sub.mutex.Unlock()
+			break
+		}
+		action := sub.queue.pop()
+		closed := sub.closed
+		sub.inFlight = true
+		sub.cond.Broadcast()
+		sub.mutex.Unlock()
+
+		// Deliver the action, without waiting on a full channel once the subscriber has stopped
+		sent := false
+		if closed {
+			select {
+			case sub.actions <- action:
+				sent = true
+			default:
+			}
+		} else {
+			select {
+			case sub.actions <- action:
+				sent = true
+			case <-sub.done:
+			}
+		}
+
+		sub.mutex.Lock()
+		sub.inFlight = false
+		if sent {
+			sub.delivered += 1
+		} else {
+			sub.queue.clear()
+		}
+		sub.cond.Broadcast()
+		sub.mutex.Unlock()
+	}
+
+	if sub.owned {
+		close(sub.actions)
+	}
+	close(sub.stopped)
+}
+
+// stop marks the subscriber as stopped, releasing any publisher or dispatcher waiting on it
+func (sub *subscriber) stop() {
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.done)
+	sub.cond.Broadcast()
+}
+
+// flush waits until every queued action has been delivered to the subscriber's channel
+func (sub *subscriber) flush() {
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+
+	for sub.queue.count > 0 || sub.inFlight {
+		sub.cond.Wait()
+	}
+}
+
+// metrics returns the subscriber's queue depth and delivery counts
+func (sub *subscriber) metrics() SubscriberMetrics {
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+
+	return SubscriberMetrics{
+		ID:            sub.id,
+		QueueDepth:    sub.queue.count,
+		MaxQueueDepth: sub.maxDepth,
+		Delivered:     sub.delivered,
+		Dropped:       sub.dropped,
+	}
+}
+
+// publish stamps the action with the exchange's next sequence number and the current time, and stages it for the consumers
+// The staged actions are delivered once the command publishing them has released the orderbook and exchange mutexes
+// The publish mutex is taken last (after any orderbook or exchange mutex), as it guards nothing but the publishing
+func (ex *Exchange) publish(action *Action) {
+	ex.publishMutex.Lock()
+	defer ex.publishMutex.Unlock()
+
+	ex.sequence += 1
+	action.exchangeSequence = ex.sequence
+	action.timestamp = time.Now().UnixNano()
+	ex.hashAction(action)
+	ex.staged = append(ex.staged, action)
+}
+
+// takeStaged returns (and clears) the actions staged for delivery, along with the subscribers to deliver them to
+func (ex *Exchange) takeStaged() ([]*Action, []*subscriber) {
+	ex.publishMutex.Lock()
+	defer ex.publishMutex.Unlock()
+
+	if len(ex.staged) == 0 {
+		return nil, nil
+	}
+	actions := ex.staged
+	ex.staged = nil
+	return actions, slices.Clone(ex.subscribers)
+}
+
+// deliver queues the actions for every matching subscriber, in the order published, disconnecting the slow consumers
+// A subscriber with a full queue under SlowConsumerBlock holds up the delivery (and so the next command), but not readers
+// of the exchange, as no orderbook or exchange mutex is held. The deliver mutex must be held by the caller
+func (ex *Exchange) deliver(actions []*Action, subscribers []*subscriber) {
+	var disconnected []*subscriber
+	for _, sub := range subscribers {
+		for _, action := range actions {
+			if sub.filter.matches(action) && !sub.enqueue(action) {
+				disconnected = append(disconnected, sub)
+				break
+			}
+		}
+	}
+	if len(disconnected) == 0 {
+		return
+	}
+
+	// Remove the disconnected subscribers
+	ex.publishMutex.Lock()
+	defer ex.publishMutex.Unlock()
+	for _, sub := range disconnected {
+		sub.stop()
+		ex.removeSubscriber(sub)
+		ex.disconnects += 1
+	}
+}
+
+// deliverStaged delivers the actions staged so far. The command mutex must be held by the caller
+func (ex *Exchange) deliverStaged() {
+	ex.deliverMutex.Lock()
+	defer ex.deliverMutex.Unlock()
+
+	ex.deliver(ex.takeStaged())
+}
+
+// Flush waits until every action published so far has been delivered to the consumers' channels
+// Flush blocks while a consumer's channel is full, so must not be called from the goroutine reading it
+func (ex *Exchange) Flush() {
+	// Deliver any actions still staged, and then take the current subscribers, waiting for them without holding any mutex
+	ex.commandMutex.Lock()
+	ex.deliverStaged()
+	ex.commandMutex.Unlock()
+
+	ex.publishMutex.Lock()
+	subscribers := append([]*subscriber(nil), ex.subscribers...)
+	ex.publishMutex.Unlock()
+
+	for _, sub := range subscribers {
+		sub.flush()
+	}
+}
+
+// Close delivers every action published so far and then stops every consumer, closing the subscription channels
+// The actions channel given to Init is not closed, as it is owned by the caller. Actions published after Close are discarded
+func (ex *Exchange) Close() {
+	ex.Flush()
+
+	ex.publishMutex.Lock()
+	subscribers := ex.subscribers
+	ex.subscribers = nil
+	ex.publishMutex.Unlock()
+
+	for _, sub := range subscribers {
+		sub.stop()
+		<-sub.stopped
+	}
+}
+
+// SubscriberMetrics represents the publishing queue of a single consumer
+// The actions channel given to Init has an ID of 0; subscriptions are numbered from 1 in the order subscribed
+type SubscriberMetrics struct {
+	ID            int
+	QueueDepth    int    // Number of actions queued, awaiting delivery
+	MaxQueueDepth int    // Highest number of actions queued at once
+	Delivered     uint64 // Number of actions delivered to the channel
+	Dropped       uint64 // Number of actions dropped as the queue was full (SlowConsumerDropOldest)
+}
+
+// PublishMetrics represents the state of the publishing layer of the exchange
+type PublishMetrics struct {
+	Published    uint64 // Number of actions published
+	Disconnected uint64 // Number of subscribers disconnected as slow consumers
+	Subscribers  []SubscriberMetrics
+}
+
+// PublishMetrics returns the number of actions published, and the queue depth and drops of each current consumer
+func (ex *Exchange) PublishMetrics() PublishMetrics {
+	// Lock the publish mutex to take a consistent view of the counters and subscribers
+	ex.publishMutex.Lock()
+	metrics := PublishMetrics{Published: ex.sequence, Disconnected: ex.disconnects}
+	subscribers := append([]*subscriber(nil), ex.subscribers...)
+	ex.publishMutex.Unlock()
+
+	for _, sub := range subscribers {
+		metrics.Subscribers = append(metrics.Subscribers, sub.metrics())
+	}
+	return metrics
+}
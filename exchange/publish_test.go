@@ -0,0 +1,81 @@
+package exchange
+
+import (
+	"testing"
+)
+
+func TestActionQueue(t *testing.T) {
+	var q actionQueue
+	q.init(2)
+
+	first, second, third := &Action{}, &Action{}, &Action{}
+	q.push(first)
+	q.push(second)
+	if !q.full() || q.count != 2 {
+		t.Fatalf("Expected the queue to be full")
+	}
+
+	// Popping makes room, and the ring wraps around
+	if q.pop() != first {
+		t.Errorf("Expected the oldest action first")
+	}
+	q.push(third)
+	if q.pop() != second || q.pop() != third || q.count != 0 {
+		t.Errorf("Expected the actions in the order pushed")
+	}
+
+	q.push(first)
+	q.clear()
+	if q.count != 0 || q.buffer[0] != nil || q.buffer[1] != nil {
+		t.Errorf("Expected the queue to be cleared")
+	}
+}
+
+func TestExchange_PublishMetrics(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+	exchange.Subscribe(SubscriptionFilter{Symbols: []string{"MSFT"}}, SubscribeOptions{})
+
+	exchange.Limit("AAPL", 100, 10, Bid, 1)
+	exchange.Limit("AAPL", 101, 10, Ask, 1)
+	exchange.Flush()
+
+	// The actions channel receives every action, and the subscription none of them
+	metrics := exchange.PublishMetrics()
+	if metrics.Published != 6 || len(metrics.Subscribers) != 2 {
+		t.Fatalf("Expected 6 actions published to 2 consumers, got %+v", metrics)
+	}
+	if metrics.Subscribers[0] != (SubscriberMetrics{ID: 0, MaxQueueDepth: metrics.Subscribers[0].MaxQueueDepth, Delivered: 6}) {
+		t.Errorf("Expected all 6 actions delivered to the actions channel, got %+v", metrics.Subscribers[0])
+	}
+	if metrics.Subscribers[1] != (SubscriberMetrics{ID: 1}) {
+		t.Errorf("Expected nothing delivered to the MSFT subscription, got %+v", metrics.Subscribers[1])
+	}
+}
+
+func TestExchange_Close(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+	subscription, _ := exchange.Subscribe(SubscriptionFilter{}, SubscribeOptions{})
+
+	exchange.Limit("AAPL", 100, 10, Bid, 1)
+	exchange.Close()
+
+	// Everything published before closing is delivered, and the subscription channel is closed
+	var received int
+	for range subscription {
+		received += 1
+	}
+	if received != 3 || len(actions) != 3 {
+		t.Errorf("Expected 3 actions delivered to each consumer, got %d and %d", received, len(actions))
+	}
+
+	// Later actions are discarded
+	exchange.Limit("AAPL", 100, 10, Bid, 1)
+	exchange.Flush()
+	if len(actions) != 3 {
+		t.Errorf("Expected no actions after closing, got %d", len(actions))
+	}
+}
@@ -0,0 +1,555 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"time"
+)
+
+// RaftState represents the role of a node in the Raft cluster
+type RaftState uint8
+
+// Define the Raft node states
+const (
+	RaftFollower RaftState = iota
+	RaftCandidate
+	RaftLeader
+)
+
+// String returns a human readable representation of the Raft state
+func (state RaftState) String() string {
+	switch state {
+	case RaftFollower:
+		return "FOLLOWER"
+	case RaftCandidate:
+		return "CANDIDATE"
+	case RaftLeader:
+		return "LEADER"
+	default:
+		return fmt.Sprintf("RaftState(%d)", uint8(state))
+	}
+}
+
+// ErrNotLeader is returned when a command is proposed to a node that is not the leader
+var ErrNotLeader = errors.New("exchange: raft node is not the leader")
+
+// ErrRaftTimeout is returned when a command is not committed by the cluster within the tick limit (eg. no quorum)
+var ErrRaftTimeout = errors.New("exchange: raft command not committed")
+
+// RaftOptions configures the timing (in ticks) of a Raft cluster
+// A zero ElectionTicks uses 10, a zero HeartbeatTicks uses 2, and a zero CommitTicks uses 20 × ElectionTicks
+type RaftOptions struct {
+	ElectionTicks  int   // Minimum ticks without hearing from a leader before a follower stands for election (randomised up to twice this)
+	HeartbeatTicks int   // Ticks between the leader's heartbeats (AppendEntries)
+	CommitTicks    int   // Ticks the cluster's order entry waits for a command to be committed
+	Seed           int64 // Seed for the randomised election timeouts, so a run is reproducible
+}
+
+// raftEntry represents an entry in the Raft log: a command, agreed in the given term
+type raftEntry struct {
+	term    uint64
+	command Command
+	noop    bool // Entry appended by a new leader to commit the entries of earlier terms (not applied to the exchange)
+}
+
+// raftMessageType represents the type of a Raft RPC (request or reply)
+type raftMessageType uint8
+
+// Define the Raft RPCs
+const (
+	raftRequestVote raftMessageType = iota
+	raftRequestVoteReply
+	raftAppendEntries
+	raftAppendEntriesReply
+)
+
+// raftMessage represents a Raft RPC, sent between nodes over the simulated network
+type raftMessage struct {
+	messageType  raftMessageType
+	from         int
+	to           int
+	term         uint64
+	lastLogIndex uint64      // Candidate's last log index (RequestVote)
+	lastLogTerm  uint64      // Candidate's last log term (RequestVote)
+	prevLogIndex uint64      // Index of the entry before the entries (AppendEntries)
+	prevLogTerm  uint64      // Term of the entry before the entries (AppendEntries)
+	entries      []raftEntry // Entries to append (AppendEntries)
+	leaderCommit uint64      // Leader's commit index (AppendEntries)
+	success      bool        // Vote granted (RequestVoteReply), or entries appended (AppendEntriesReply)
+	matchIndex   uint64      // Last index known to match the leader's log, or the follower's log length on failure (AppendEntriesReply)
+}
+
+// raftNetwork represents a simulated network between the nodes, delivering the messages sent in one tick on the next
+// Messages between nodes in different partitions are dropped
+type raftNetwork struct {
+	queue     []raftMessage
+	partition map[int]int // Partition of each node (nodes not in the map are in partition 0)
+}
+
+// send queues a message for delivery on the next tick
+func (network *raftNetwork) send(message raftMessage) {
+	network.queue = append(network.queue, message)
+}
+
+// connected returns whether messages can be delivered between the nodes
+func (network *raftNetwork) connected(from int, to int) bool {
+	return network.partition[from] == network.partition[to]
+}
+
+// RaftNode represents a node of the Raft cluster, applying the commands committed to the log to its own exchange
+type RaftNode struct {
+	id       int
+	peers    []int
+	network  *raftNetwork
+	exchange *Exchange
+	options  RaftOptions
+	random   *rand.Rand
+
+	// Raft state
+	state       RaftState
+	currentTerm uint64
+	votedFor    int // Node voted for in the current term (-1 if none)
+	leaderID    int // Leader of the current term, as far as the node knows (-1 if unknown)
+	log         []raftEntry
+	commitIndex uint64
+	lastApplied uint64
+
+	// Candidate and leader state
+	votes      map[int]bool
+	nextIndex  map[int]uint64
+	matchIndex map[int]uint64
+
+	// Timers, in ticks
+	electionElapsed  int
+	electionTimeout  int
+	heartbeatElapsed int
+
+	// Results of the commands proposed to this node, by log index (kept if the entry is still of the proposing term once applied)
+	proposals map[uint64]uint64
+	results   map[uint64]commandResult
+}
+
+// ID returns the ID of the node in the cluster
+func (node *RaftNode) ID() int {
+	return node.id
+}
+
+// State returns the node's current role in the cluster
+func (node *RaftNode) State() RaftState {
+	return node.state
+}
+
+// Term returns the node's current term
+func (node *RaftNode) Term() uint64 {
+	return node.currentTerm
+}
+
+// CommitIndex returns the index of the last log entry known by the node to be committed
+func (node *RaftNode) CommitIndex() uint64 {
+	return node.commitIndex
+}
+
+// Exchange returns the node's exchange, to which the committed commands are applied
+// Commands must only be sent to the exchange through the cluster, so every node processes the same commands
+func (node *RaftNode) Exchange() *Exchange {
+	return node.exchange
+}
+
+// Propose appends the command to the leader's log, returning its log index
+// The command takes effect once committed, when each node applies it to its exchange
+func (node *RaftNode) Propose(cmd Command) (uint64, error) {
+	if node.state != RaftLeader {
+		return 0, ErrNotLeader
+	}
+	// The leader stamps the command, so each node processes it at the same time
+	if cmd.Time == 0 {
+		cmd.Time = time.Now().UnixNano()
+	}
+	node.log = append(node.log, raftEntry{term: node.currentTerm, command: cmd})
+	index := node.lastIndex()
+	node.matchIndex[node.id] = index
+	node.proposals[index] = node.currentTerm
+	node.broadcastAppend()
+	return index, nil
+}
+
+// lastIndex returns the index of the last entry in the log (the log starts with a sentinel entry at index 0)
+func (node *RaftNode) lastIndex() uint64 {
+	return uint64(len(node.log) - 1)
+}
+
+// tick advances the node's timers by one tick, standing for election or sending heartbeats when they expire
+func (node *RaftNode) tick() {
+	if node.state == RaftLeader {
+		node.heartbeatElapsed += 1
+		if node.heartbeatElapsed >= node.options.HeartbeatTicks {
+			node.broadcastAppend()
+		}
+		return
+	}
+
+	node.electionElapsed += 1
+	if node.electionElapsed >= node.electionTimeout {
+		node.campaign()
+	}
+}
+
+// resetElectionTimer restarts the election timer, with a new randomised timeout
+func (node *RaftNode) resetElectionTimer() {
+	node.electionElapsed = 0
+	node.electionTimeout = node.options.ElectionTicks + node.random.Intn(node.options.ElectionTicks)
+}
+
+// becomeFollower steps down to follower in the given term
+func (node *RaftNode) becomeFollower(term uint64, leaderID int) {
+	if term > node.currentTerm {
+		node.currentTerm = term
+		node.votedFor = -1
+	}
+	node.state = RaftFollower
+	node.leaderID = leaderID
+	node.resetElectionTimer()
+}
+
+// campaign stands for election in a new term, requesting the votes of the other nodes
+func (node *RaftNode) campaign() {
+	node.currentTerm += 1
+	node.state = RaftCandidate
+	node.votedFor = node.id
+	node.leaderID = -1
+	node.votes = map[int]bool{node.id: true}
+	node.resetElectionTimer()
+
+	for _, peer := range node.peers {
+		node.network.send(raftMessage{
+			messageType:  raftRequestVote,
+			from:         node.id,
+			to:           peer,
+			term:         node.currentTerm,
+			lastLogIndex: node.lastIndex(),
+			lastLogTerm:  node.log[node.lastIndex()].term,
+		})
+	}
+	node.checkElection()
+}
+
+// checkElection makes the candidate the leader once it holds the votes of a majority
+func (node *RaftNode) checkElection() {
+	if node.state != RaftCandidate || len(node.votes)*2 <= len(node.peers)+1 {
+		return
+	}
+
+	node.state = RaftLeader
+	node.leaderID = node.id
+	node.nextIndex = make(map[int]uint64)
+	node.matchIndex = map[int]uint64{}
+	for _, peer := range node.peers {
+		node.nextIndex[peer] = node.lastIndex() + 1
+		node.matchIndex[peer] = 0
+	}
+
+	// Append a no-op entry, so the entries of earlier terms are committed along with it
+	node.log = append(node.log, raftEntry{term: node.currentTerm, noop: true})
+	node.matchIndex[node.id] = node.lastIndex()
+	node.broadcastAppend()
+}
+
+// broadcastAppend sends each follower the entries it is missing (or a heartbeat, if none)
+func (node *RaftNode) broadcastAppend() {
+	node.heartbeatElapsed = 0
+	for _, peer := range node.peers {
+		next := node.nextIndex[peer]
+		node.network.send(raftMessage{
+			messageType:  raftAppendEntries,
+			from:         node.id,
+			to:           peer,
+			term:         node.currentTerm,
+			prevLogIndex: next - 1,
+			prevLogTerm:  node.log[next-1].term,
+			entries:      slices.Clone(node.log[next:]),
+			leaderCommit: node.commitIndex,
+		})
+	}
+	node.advanceCommit()
+}
+
+// step processes a message received from another node
+func (node *RaftNode) step(message raftMessage) {
+	// A message from a later term makes the node a follower in that term
+	if message.term > node.currentTerm {
+		node.becomeFollower(message.term, -1)
+	}
+
+	switch message.messageType {
+	case raftRequestVote:
+		node.handleRequestVote(message)
+	case raftRequestVoteReply:
+		if node.state == RaftCandidate && message.term == node.currentTerm && message.success {
+			node.votes[message.from] = true
+			node.checkElection()
+		}
+	case raftAppendEntries:
+		node.handleAppendEntries(message)
+	case raftAppendEntriesReply:
+		node.handleAppendEntriesReply(message)
+	}
+}
+
+// handleRequestVote grants the vote to a candidate whose log is at least as up to date, once per term
+func (node *RaftNode) handleRequestVote(message raftMessage) {
+	lastTerm := node.log[node.lastIndex()].term
+	upToDate := message.lastLogTerm > lastTerm || (message.lastLogTerm == lastTerm && message.lastLogIndex >= node.lastIndex())
+	granted := message.term == node.currentTerm && upToDate && (node.votedFor == -1 || node.votedFor == message.from)
+	if granted {
+		node.votedFor = message.from
+		node.resetElectionTimer()
+	}
+
+	node.network.send(raftMessage{
+		messageType: raftRequestVoteReply,
+		from:        node.id,
+		to:          message.from,
+		term:        node.currentTerm,
+		success:     granted,
+	})
+}
+
+// handleAppendEntries appends the leader's entries (replacing any conflicting entries), and applies the committed entries
+func (node *RaftNode) handleAppendEntries(message raftMessage) {
+	reply := raftMessage{
+		messageType: raftAppendEntriesReply,
+		from:        node.id,
+		to:          message.from,
+		term:        node.currentTerm,
+	}
+
+	// Reject a leader from an earlier term, or entries that do not follow on from the log
+	if message.term < node.currentTerm {
+		node.network.send(reply)
+		return
+	}
+	node.becomeFollower(message.term, message.from)
+	if message.prevLogIndex > node.lastIndex() || node.log[message.prevLogIndex].term != message.prevLogTerm {
+		reply.matchIndex = min(message.prevLogIndex, node.lastIndex()+1) - 1
+		node.network.send(reply)
+		return
+	}
+
+	// Append the entries, truncating the log at the first conflicting entry
+	for i, entry := range message.entries {
+		index := message.prevLogIndex + 1 + uint64(i)
+		if index <= node.lastIndex() {
+			if node.log[index].term == entry.term {
+				continue
+			}
+			node.log = node.log[:index]
+		}
+		node.log = append(node.log, entry)
+	}
+
+	reply.success = true
+	reply.matchIndex = message.prevLogIndex + uint64(len(message.entries))
+	if message.leaderCommit > node.commitIndex {
+		node.commitIndex = min(message.leaderCommit, reply.matchIndex)
+		node.applyCommitted()
+	}
+	node.network.send(reply)
+}
+
+// handleAppendEntriesReply records the follower's progress, retrying from an earlier entry if its log did not match
+func (node *RaftNode) handleAppendEntriesReply(message raftMessage) {
+	if node.state != RaftLeader || message.term != node.currentTerm {
+		return
+	}
+	if !message.success {
+		node.nextIndex[message.from] = max(1, min(node.nextIndex[message.from]-1, message.matchIndex+1))
+		return
+	}
+	if message.matchIndex > node.matchIndex[message.from] {
+		node.matchIndex[message.from] = message.matchIndex
+	}
+	node.nextIndex[message.from] = node.matchIndex[message.from] + 1
+	node.advanceCommit()
+}
+
+// advanceCommit commits the latest entry of the current term held by a majority, and applies the committed entries
+func (node *RaftNode) advanceCommit() {
+	for index := node.lastIndex(); index > node.commitIndex; index-- {
+		if node.log[index].term != node.currentTerm {
+			break
+		}
+		replicated := 0
+		for _, match := range node.matchIndex {
+			if match >= index {
+				replicated += 1
+			}
+		}
+		if replicated*2 > len(node.peers)+1 {
+			node.commitIndex = index
+			break
+		}
+	}
+	node.applyCommitted()
+}
+
+// applyCommitted applies the committed entries to the node's exchange, in log order
+// The exchange sequences each command, so every node assigns the same sequence numbers, OrderIDs and actions
+func (node *RaftNode) applyCommitted() {
+	for node.lastApplied < node.commitIndex {
+		node.lastApplied += 1
+		entry := node.log[node.lastApplied]
+		if entry.noop {
+			continue
+		}
+
+		cmd := entry.command
+		result := node.exchange.execute(&cmd)
+		if node.proposals[node.lastApplied] == entry.term {
+			node.results[node.lastApplied] = result
+		}
+		delete(node.proposals, node.lastApplied)
+	}
+}
+
+// RaftCluster represents an in-process Raft cluster of exchanges, connected by a simulated network
+// Order entry is agreed via the replicated log before being applied to each node's exchange, so any node can take over as leader
+// The cluster is driven by Tick, and is not safe for concurrent use
+type RaftCluster struct {
+	nodes   []*RaftNode
+	network *raftNetwork
+	options RaftOptions
+}
+
+// NewRaftCluster creates a cluster of the given number of nodes, each with its own exchange
+func NewRaftCluster(size int, options RaftOptions) *RaftCluster {
+	if options.ElectionTicks <= 0 {
+		options.ElectionTicks = 10
+	}
+	if options.HeartbeatTicks <= 0 {
+		options.HeartbeatTicks = 2
+	}
+	if options.CommitTicks <= 0 {
+		options.CommitTicks = 20 * options.ElectionTicks
+	}
+
+	cluster := &RaftCluster{
+		network: &raftNetwork{partition: make(map[int]int)},
+		options: options,
+	}
+	for id := 0; id < size; id++ {
+		node := &RaftNode{
+			id:        id,
+			network:   cluster.network,
+			exchange:  &Exchange{},
+			options:   options,
+			random:    rand.New(rand.NewSource(options.Seed + int64(id))),
+			votedFor:  -1,
+			leaderID:  -1,
+			log:       []raftEntry{{}},
+			proposals: make(map[uint64]uint64),
+			results:   make(map[uint64]commandResult),
+		}
+		for peer := 0; peer < size; peer++ {
+			if peer != id {
+				node.peers = append(node.peers, peer)
+			}
+		}
+		node.exchange.Init(fmt.Sprintf("Raft node %d", id), nil, SubscribeOptions{})
+		node.resetElectionTimer()
+		cluster.nodes = append(cluster.nodes, node)
+	}
+	return cluster
+}
+
+// Nodes returns the nodes of the cluster, in ID order
+func (cluster *RaftCluster) Nodes() []*RaftNode {
+	return cluster.nodes
+}
+
+// Tick advances every node's timers by one tick, and then delivers the messages sent since the last tick
+func (cluster *RaftCluster) Tick() {
+	for _, node := range cluster.nodes {
+		node.tick()
+	}
+
+	// Messages sent while delivering are delivered on the next tick
+	queue := cluster.network.queue
+	cluster.network.queue = nil
+	for _, message := range queue {
+		if cluster.network.connected(message.from, message.to) {
+			cluster.nodes[message.to].step(message)
+		}
+	}
+}
+
+// Leader returns the leader of the latest term (nil if no node is leader)
+func (cluster *RaftCluster) Leader() *RaftNode {
+	var leader *RaftNode
+	for _, node := range cluster.nodes {
+		if node.state == RaftLeader && (leader == nil || node.currentTerm > leader.currentTerm) {
+			leader = node
+		}
+	}
+	return leader
+}
+
+// Partition splits the network into the given groups of node IDs; nodes only receive messages from their own group
+// Nodes not listed are isolated together in a further group
+func (cluster *RaftCluster) Partition(groups ...[]int) {
+	cluster.network.partition = make(map[int]int)
+	for _, node := range cluster.nodes {
+		cluster.network.partition[node.id] = len(groups) + 1
+	}
+	for group, ids := range groups {
+		for _, id := range ids {
+			cluster.network.partition[id] = group + 1
+		}
+	}
+}
+
+// Heal reconnects every node of the network
+func (cluster *RaftCluster) Heal() {
+	cluster.network.partition = make(map[int]int)
+}
+
+// Limit submits a limit order to the cluster's leader, returning the OrderID assigned once the order is committed and applied
+func (cluster *RaftCluster) Limit(symbol string, price Price, size Size, side Side, trader TraderID) (OrderID, error) {
+	result := cluster.propose(Command{
+		Type:    CommandSubmit,
+		Request: OrderRequest{Symbol: symbol, Price: price, Size: size, Side: side, Trader: trader},
+	})
+	return result.orderID, result.err
+}
+
+// Cancel cancels the order via the cluster's leader, once the cancel is committed and applied
+func (cluster *RaftCluster) Cancel(orderID OrderID) error {
+	return cluster.propose(Command{Type: CommandCancel, OrderID: orderID}).err
+}
+
+// propose proposes the command to the leader (waiting for one to be elected), and ticks until it is applied on the leader
+// Returns ErrRaftTimeout if the command is not applied within CommitTicks (eg. the leader has lost its quorum),
+// in which case it may still be committed later, if it reached a majority of the nodes
+func (cluster *RaftCluster) propose(cmd Command) commandResult {
+	for ticks := 0; ticks < cluster.options.CommitTicks; ticks++ {
+		leader := cluster.Leader()
+		if leader == nil {
+			cluster.Tick()
+			continue
+		}
+
+		index, err := leader.Propose(cmd)
+		if err != nil {
+			return commandResult{err: err}
+		}
+		for ; ticks < cluster.options.CommitTicks; ticks++ {
+			if result, ok := leader.results[index]; ok {
+				delete(leader.results, index)
+				return result
+			}
+			cluster.Tick()
+		}
+	}
+	return commandResult{err: ErrRaftTimeout}
+}
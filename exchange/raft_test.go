@@ -0,0 +1,130 @@
+package exchange
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// tickUntil ticks the cluster until the condition holds, failing the test if it does not within the limit
+func tickUntil(t *testing.T, cluster *RaftCluster, limit int, condition func() bool) {
+	t.Helper()
+	for i := 0; i < limit; i++ {
+		if condition() {
+			return
+		}
+		cluster.Tick()
+	}
+	t.Fatalf("Expected the condition to hold within %d ticks", limit)
+}
+
+// converged returns whether every node has applied the same commands, publishing the same actions
+func converged(cluster *RaftCluster) bool {
+	nodes := cluster.Nodes()
+	for _, node := range nodes[1:] {
+		if node.lastApplied != nodes[0].lastApplied || node.Exchange().ActionHash() != nodes[0].Exchange().ActionHash() {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRaftCluster_Replication(t *testing.T) {
+	cluster := NewRaftCluster(3, RaftOptions{Seed: 1})
+
+	bid, err := cluster.Limit("AAPL", 100, 10, Bid, 1)
+	if err != nil || bid != 1 {
+		t.Fatalf("Expected the order to be committed as OrderID 1, got %d (%v)", bid, err)
+	}
+	cluster.Limit("AAPL", 101, 5, Bid, 2)
+	cluster.Limit("AAPL", 100, 8, Ask, 3)
+	if err := cluster.Cancel(bid); err != nil {
+		t.Errorf("Expected the cancel to be committed, got %v", err)
+	}
+	var rejectErr *RejectError
+	if err := cluster.Cancel(bid); !errors.As(err, &rejectErr) || rejectErr.Reason != RejectAlreadyCancelled {
+		t.Errorf("Expected the second cancel to be rejected, got %v", err)
+	}
+
+	// There is a single leader, and every node processes the same commands to the same books
+	leaders := 0
+	for _, node := range cluster.Nodes() {
+		if node.State() == RaftLeader {
+			leaders += 1
+		}
+	}
+	if leaders != 1 {
+		t.Errorf("Expected a single leader, got %d", leaders)
+	}
+	tickUntil(t, cluster, 10, func() bool { return converged(cluster) })
+	want := cluster.Leader().Exchange().Orders("AAPL")
+	for _, node := range cluster.Nodes() {
+		if got := node.Exchange().Orders("AAPL"); !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected node %d to have the orders %+v, got %+v", node.ID(), want, got)
+		}
+	}
+}
+
+func TestRaftCluster_LeaderPartition(t *testing.T) {
+	cluster := NewRaftCluster(3, RaftOptions{Seed: 2})
+	cluster.Limit("AAPL", 100, 10, Bid, 1)
+	tickUntil(t, cluster, 10, func() bool { return converged(cluster) })
+
+	// Isolate the leader: a command proposed to it cannot be committed without a majority
+	old := cluster.Leader()
+	var majority []int
+	for _, node := range cluster.Nodes() {
+		if node != old {
+			majority = append(majority, node.ID())
+		}
+	}
+	cluster.Partition(majority, []int{old.ID()})
+	if _, err := old.Propose(Command{Type: CommandSubmit, Request: OrderRequest{Symbol: "AAPL", Price: 90, Size: 1, Side: Bid, Trader: 9}}); err != nil {
+		t.Fatalf("Expected the isolated leader to accept the proposal, got %v", err)
+	}
+	committed := old.CommitIndex()
+
+	// The majority elects a new leader, and carries on committing commands
+	tickUntil(t, cluster, 100, func() bool { return cluster.Leader() != old })
+	orderID, err := cluster.Limit("AAPL", 101, 5, Bid, 2)
+	if err != nil || orderID != 2 {
+		t.Errorf("Expected the new leader to commit OrderID 2, got %d (%v)", orderID, err)
+	}
+	if old.CommitIndex() != committed {
+		t.Errorf("Expected the isolated leader not to commit its proposal")
+	}
+
+	// Once healed, the old leader steps down, discards its uncommitted proposal and catches up
+	cluster.Heal()
+	tickUntil(t, cluster, 100, func() bool { return old.State() == RaftFollower && converged(cluster) })
+	if _, ok := old.Exchange().OrderStatus(3); ok {
+		t.Errorf("Expected the uncommitted order not to be applied")
+	}
+	if !reflect.DeepEqual(old.Exchange().Orders("AAPL"), cluster.Leader().Exchange().Orders("AAPL")) {
+		t.Errorf("Expected the old leader's books to match the new leader's")
+	}
+}
+
+func TestRaftCluster_NoQuorum(t *testing.T) {
+	cluster := NewRaftCluster(3, RaftOptions{Seed: 3})
+	cluster.Limit("AAPL", 100, 10, Bid, 1)
+	tickUntil(t, cluster, 10, func() bool { return converged(cluster) })
+
+	// With every node isolated, nothing can be committed
+	cluster.Partition([]int{0}, []int{1}, []int{2})
+	if _, err := cluster.Limit("AAPL", 101, 5, Bid, 2); !errors.Is(err, ErrRaftTimeout) {
+		t.Errorf("Expected the order not to be committed without a quorum, got %v", err)
+	}
+	for _, node := range cluster.Nodes() {
+		if _, ok := node.Exchange().OrderStatus(2); ok {
+			t.Errorf("Expected node %d not to apply the uncommitted order", node.ID())
+		}
+	}
+
+	// Once healed, a leader is elected and order entry resumes
+	cluster.Heal()
+	if orderID, err := cluster.Limit("AAPL", 102, 5, Bid, 2); err != nil {
+		t.Errorf("Expected the order to be committed once healed, got %d (%v)", orderID, err)
+	}
+	tickUntil(t, cluster, 10, func() bool { return converged(cluster) })
+}
@@ -0,0 +1,180 @@
+package exchange
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Define the FNV-1a (64 bit) parameters used for the action hash
+const (
+	hashOffset uint64 = 14695981039346656037
+	hashPrime  uint64 = 1099511628211
+)
+
+// ReplayError is returned when replaying a journal does not reproduce the recorded exchange
+type ReplayError struct {
+	Sequence uint64 // Sequence number of the command at which the replay diverged
+	Reason   string
+}
+
+// Error returns a string representation of the replay error
+func (e *ReplayError) Error() string {
+	return fmt.Sprintf("exchange: replay diverged at command %d: %s", e.Sequence, e.Reason)
+}
+
+// ActionHash returns the running hash of every action published by the exchange (excluding their timestamps)
+// Two exchanges that processed the same commands have published the same actions if their hashes match
+func (ex *Exchange) ActionHash() uint64 {
+	ex.publishMutex.Lock()
+	defer ex.publishMutex.Unlock()
+
+	return ex.actionHash
+}
+
+// hashAction adds the action to the running action hash. The publish mutex must be held by the caller
+// Every field of the action is hashed except its timestamp, which differs between a live run and its replay
+func (ex *Exchange) hashAction(action *Action) {
+	buf := ex.hashBuf[:0]
+	buf = append(buf, uint8(action.action_type))
+	buf = binary.LittleEndian.AppendUint64(buf, action.exchangeSequence)
+	buf = binary.LittleEndian.AppendUint64(buf, action.sequence)
+	buf = appendOrder(buf, &action.order)
+	buf = appendOrder(buf, &action.cross_order)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(action.fill_size))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(action.fill_price))
+	buf = append(buf, uint8(action.reason))
+	// The risk rule is only hashed when set, so the hashes journalled before risk checks existed still verify
+	if action.rule != "" {
+		buf = appendString(buf, action.rule)
+	}
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(action.cancelled))
+
+	// The market data carried by the action
+	buf = appendString(buf, action.bbo.Symbol)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(action.bbo.BidPrice))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(action.bbo.BidSize))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(action.bbo.AskPrice))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(action.bbo.AskSize))
+	buf = appendString(buf, action.update.Symbol)
+	buf = append(buf, uint8(action.update.Side), uint8(action.update.Update))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(action.update.Price))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(action.update.Size))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(action.update.Orders))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(action.trade.TradeID))
+	buf = appendString(buf, action.trade.Symbol)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(action.trade.Price))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(action.trade.Size))
+	buf = append(buf, uint8(action.trade.AggressorSide))
+
+	// FNV-1a over the encoded action, continuing from the previous actions
+	hash := ex.actionHash
+	for _, b := range buf {
+		hash ^= uint64(b)
+		hash *= hashPrime
+	}
+	ex.actionHash = hash
+	ex.hashBuf = buf
+}
+
+// appendOrder appends the binary encoding of an order's fields to buf (for the action hash, and snapshots)
+func appendOrder(buf []byte, order *Order) []byte {
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(order.orderID))
+	buf = appendString(buf, order.symbol)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(order.price))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(order.size))
+	buf = append(buf, uint8(order.side), uint8(order.tif))
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(order.trader))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(order.expiry))
+	buf = appendString(buf, order.clientOrderID)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(order.original))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(order.filled))
+	return binary.LittleEndian.AppendUint64(buf, order.notional)
+}
+
+// Replay processes the commands recorded in a journal (read from r) again, rebuilding the orderbooks, OrderIDs and actions
+// The replayed actions are published as usual, and the action hash recorded after each command is checked against them
+// Commands the exchange has already processed (eg. before a snapshot it was restored from) are skipped
+// A partially written final record is ignored, as its command never took effect (and is truncated by OpenJournal)
+// Returns a *ReplayError on divergence
+func (ex *Exchange) Replay(r io.Reader) error {
+	// Lock the command mutex, so the replayed commands are not interleaved with new ones
+	ex.commandMutex.Lock()
+	defer ex.commandMutex.Unlock()
+
+	if ex.journalErr != nil {
+		return ex.journalErr
+	}
+
+	// The replayed commands are already in the journal, so are not journalled (or replicated) again
+	journal, primary := ex.journal, ex.primary
+	ex.journal, ex.primary = nil, nil
+	defer func() { ex.journal, ex.primary = journal, primary }()
+
+	reader := NewJournalReader(r)
+	for {
+		cmd, hash, err := reader.next()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("exchange: replay: %w", err)
+		}
+
+		if hash != nil {
+			err = ex.checkHash(hash)
+		} else {
+			_, err = ex.replayCommand(&cmd)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// checkHash checks the actions published so far against the hash recorded after the same command
+// The command mutex must be held by the caller
+func (ex *Exchange) checkHash(hash *journalHash) error {
+	if hash.sequence < ex.commandSequence {
+		return nil
+	}
+	if hash.sequence > ex.commandSequence {
+		return &ReplayError{Sequence: hash.sequence, Reason: "action hash recorded for a command not processed"}
+	}
+	if replayed := ex.ActionHash(); replayed != hash.hash {
+		return &ReplayError{
+			Sequence: hash.sequence,
+			Reason:   fmt.Sprintf("action hash %016x, recorded %016x", replayed, hash.hash),
+		}
+	}
+	return nil
+}
+
+// replayCommand processes a recorded command again, returning false if it was skipped as already processed
+// The command mutex must be held by the caller
+func (ex *Exchange) replayCommand(cmd *Command) (bool, error) {
+	// Skip the commands already processed, and stop at a gap in the sequence
+	if cmd.Sequence <= ex.commandSequence {
+		return false, nil
+	}
+	if cmd.Sequence != ex.commandSequence+1 {
+		return false, &ReplayError{
+			Sequence: cmd.Sequence,
+			Reason:   fmt.Sprintf("expected command %d", ex.commandSequence+1),
+		}
+	}
+
+	// Process the command as it was originally (at its recorded time), checking it is assigned the same OrderID
+	recorded := cmd.OrderID
+	ex.commandSequence = cmd.Sequence
+	result := ex.apply(cmd)
+	ex.recordHash(cmd.Sequence)
+	ex.deliverStaged()
+	if (cmd.Type == CommandSubmit || cmd.Type == CommandMarket) && result.orderID != recorded {
+		return true, &ReplayError{
+			Sequence: cmd.Sequence,
+			Reason:   fmt.Sprintf("assigned OrderID %d, recorded %d", result.orderID, recorded),
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,228 @@
+package exchange
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// recordSession runs a trading session on a journalled exchange, returning the journal path and the exchange
+func recordSession(t *testing.T, actions chan *Action) (string, *Exchange) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "exchange.journal")
+	journal, err := OpenJournal(path, JournalOptions{Sync: SyncBatch})
+	if err != nil {
+		t.Fatalf("Expected to open the journal, got %v", err)
+	}
+
+	exchange := &Exchange{}
+	exchange.Init("Live Exchange", actions, SubscribeOptions{})
+	exchange.SetJournal(journal)
+
+	now := time.Now()
+	exchange.SetMarketProtection(5)
+	bid, _ := exchange.Limit("AAPL", 100, 10, Bid, 1)
+	exchange.Limit("AAPL", 101, 5, Bid, 2)
+	exchange.Limit("AAPL", 103, 7, Ask, 3)
+	exchange.Submit(OrderRequest{Symbol: "AAPL", Price: 104, Size: 4, Side: Ask, Trader: 3, TimeInForce: GTD, ExpireAt: now.Add(time.Hour)})
+	exchange.Submit(OrderRequest{Symbol: "MSFT", Price: 50, Size: 20, Side: Bid, Trader: 4, TimeInForce: DAY, ClientOrderID: "m1"})
+	exchange.Limit("AAPL", 0, 10, Bid, 1)
+	exchange.Market("AAPL", 8, Bid, 4)
+	exchange.Modify(bid, 102, 6)
+	exchange.Limit("AAPL", 101, 3, Ask, 2)
+	exchange.ModifyByClientOrderID(4, "m1", 51, 10)
+	exchange.Cancel(bid)
+	exchange.ExpireOrders(now.Add(2 * time.Hour))
+	exchange.EndSession()
+	exchange.CancelAll(2)
+
+	if err := journal.Close(); err != nil {
+		t.Fatalf("Expected to close the journal, got %v", err)
+	}
+	return path, exchange
+}
+
+// replayJournal replays the journal file into the exchange
+func replayJournal(t *testing.T, exchange *Exchange, path string) error {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Expected to open the journal, got %v", err)
+	}
+	defer file.Close()
+
+	return exchange.Replay(file)
+}
+
+// replayedAction is the part of an action compared between a live run and its replay (all but the timestamp)
+func replayedAction(action *Action) Action {
+	compared := *action
+	compared.timestamp = 0
+	return compared
+}
+
+func TestReplay_Rebuild(t *testing.T) {
+	live_actions := make(chan *Action, ChanSize)
+	path, live := recordSession(t, live_actions)
+
+	replay_actions := make(chan *Action, ChanSize)
+	var replayed Exchange
+	replayed.Init("Replayed Exchange", replay_actions, SubscribeOptions{})
+	if err := replayJournal(t, &replayed, path); err != nil {
+		t.Fatalf("Expected the replay to succeed, got %v", err)
+	}
+
+	// The replay publishes the same actions, in the same order
+	want := drainActions(live, live_actions)
+	got := drainActions(&replayed, replay_actions)
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d replayed actions, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(replayedAction(got[i]), replayedAction(want[i])) {
+			t.Errorf("Expected replayed action %d to be %v, got %v", i, want[i], got[i])
+		}
+	}
+	if replayed.ActionHash() != live.ActionHash() {
+		t.Errorf("Expected the action hash %x, got %x", live.ActionHash(), replayed.ActionHash())
+	}
+
+	// The orderbooks and orders are rebuilt identically
+	for _, symbol := range []string{"AAPL", "MSFT"} {
+		if !reflect.DeepEqual(replayed.Orders(symbol), live.Orders(symbol)) {
+			t.Errorf("Expected the %v orders %+v, got %+v", symbol, live.Orders(symbol), replayed.Orders(symbol))
+		}
+	}
+	for orderID := OrderID(1); orderID <= 8; orderID++ {
+		want_status, want_ok := live.OrderStatus(orderID)
+		got_status, got_ok := replayed.OrderStatus(orderID)
+		if got_ok != want_ok || got_status != want_status {
+			t.Errorf("Expected the status of order %d to be %+v, got %+v", orderID, want_status, got_status)
+		}
+	}
+
+	// New orders continue from the same OrderID
+	live_id, _ := live.Limit("AAPL", 100, 1, Bid, 1)
+	replayed_id, _ := replayed.Limit("AAPL", 100, 1, Bid, 1)
+	if replayed_id != live_id {
+		t.Errorf("Expected the next OrderID to be %d, got %d", live_id, replayed_id)
+	}
+}
+
+func TestReplay_SkipsProcessedCommands(t *testing.T) {
+	path, live := recordSession(t, nil)
+
+	// Replaying the journal a second time skips every command, as they have already been processed
+	var replayed Exchange
+	replayed.Init("Replayed Exchange", nil, SubscribeOptions{})
+	for i := 0; i < 2; i++ {
+		if err := replayJournal(t, &replayed, path); err != nil {
+			t.Fatalf("Expected replay %d to succeed, got %v", i+1, err)
+		}
+	}
+	if replayed.ActionHash() != live.ActionHash() {
+		t.Errorf("Expected the action hash %x, got %x", live.ActionHash(), replayed.ActionHash())
+	}
+}
+
+func TestReplay_Divergence(t *testing.T) {
+	path, _ := recordSession(t, nil)
+
+	// Rewrite the journal with the price of the second command changed, keeping the recorded action hashes
+	tampered := filepath.Join(t.TempDir(), "tampered.journal")
+	journal, err := OpenJournal(tampered, JournalOptions{Sync: SyncBatch})
+	if err != nil {
+		t.Fatalf("Expected to open the journal, got %v", err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Expected to open the journal, got %v", err)
+	}
+	reader := NewJournalReader(file)
+	for {
+		cmd, hash, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Expected to read the journal, got %v", err)
+		}
+		if hash != nil {
+			journal.appendHash(hash.sequence, hash.hash)
+			continue
+		}
+		if cmd.Sequence == 2 {
+			cmd.Request.Price = 99
+		}
+		journal.Append(&cmd)
+	}
+	file.Close()
+	journal.Close()
+
+	var replayed Exchange
+	replayed.Init("Replayed Exchange", nil, SubscribeOptions{})
+	err = replayJournal(t, &replayed, tampered)
+	var replayErr *ReplayError
+	if !errors.As(err, &replayErr) || replayErr.Sequence != 2 {
+		t.Errorf("Expected the replay to diverge at command 2, got %v", err)
+	}
+}
+
+func TestReplay_TornRecord(t *testing.T) {
+	path, _ := recordSession(t, nil)
+
+	// Truncate the journal part way through its final record, as if the exchange stopped while writing it
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Expected to stat the journal, got %v", err)
+	}
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatalf("Expected to truncate the journal, got %v", err)
+	}
+
+	var replayed Exchange
+	replayed.Init("Replayed Exchange", nil, SubscribeOptions{})
+	if err := replayJournal(t, &replayed, path); err != nil {
+		t.Errorf("Expected the torn final record to be ignored, got %v", err)
+	}
+	if replayed.commandSequence != 15 {
+		t.Errorf("Expected 15 commands to be replayed, got %d", replayed.commandSequence)
+	}
+
+	// Restart on the journal: the torn record is truncated, so the new commands can be replayed after the old ones
+	journal, err := OpenJournal(path, JournalOptions{})
+	if err != nil {
+		t.Fatalf("Expected to reopen the journal, got %v", err)
+	}
+	replayed.SetJournal(journal)
+	orderID, err := replayed.Limit("AAPL", 99, 5, Bid, 3)
+	if err != nil {
+		t.Fatalf("Expected the order to be accepted after the restart, got %v", err)
+	}
+	journal.Close()
+
+	var restarted Exchange
+	restarted.Init("Restarted Exchange", nil, SubscribeOptions{})
+	if err := replayJournal(t, &restarted, path); err != nil {
+		t.Fatalf("Expected the journal to replay after the restart, got %v", err)
+	}
+	if status, ok := restarted.OrderStatus(orderID); restarted.commandSequence != 16 || !ok || status.State != OrderNew {
+		t.Errorf("Expected the order accepted after the restart to be replayed, got %d commands and %+v", restarted.commandSequence, status)
+	}
+}
+
+func TestReplay_OlderJournal(t *testing.T) {
+	// A session journalled before the risk checks and price collars existed (version 1 commands) still verifies
+	var replayed Exchange
+	replayed.Init("Replayed Exchange", nil, SubscribeOptions{})
+	if err := replayJournal(t, &replayed, filepath.Join("testdata", "journal_v1.journal")); err != nil {
+		t.Fatalf("Expected the older journal to replay, got %v", err)
+	}
+	if replayed.commandSequence != 15 {
+		t.Errorf("Expected 15 commands to be replayed, got %d", replayed.commandSequence)
+	}
+}
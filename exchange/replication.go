@@ -0,0 +1,497 @@
+package exchange
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ReplicationOptions configures the heartbeats and failure detection between a primary and its backup
+// A zero HeartbeatInterval uses 50ms, and a zero FailureTimeout uses 500ms
+type ReplicationOptions struct {
+	HeartbeatInterval time.Duration // Interval between the heartbeats sent by the primary (the action hash of its last command)
+	FailureTimeout    time.Duration // Time without hearing from the other side (or a write blocking) before it is considered failed
+}
+
+// withDefaults returns the options, with the zero values replaced by their defaults
+func (options ReplicationOptions) withDefaults() ReplicationOptions {
+	if options.HeartbeatInterval <= 0 {
+		options.HeartbeatInterval = 50 * time.Millisecond
+	}
+	if options.FailureTimeout <= 0 {
+		options.FailureTimeout = 500 * time.Millisecond
+	}
+	return options
+}
+
+// replicationMaxSnapshot bounds the snapshot length read from the primary, so a corrupted length is not allocated
+// It also bounds the backlog of commands buffered for a backup catching up, so a backup that cannot keep up is dropped
+const replicationMaxSnapshot = 1 << 30
+
+// replicationChunk is the size of each write (and read) of a snapshot, the deadline being extended after each one
+// so a large snapshot can take longer than the FailureTimeout in total, as long as it keeps moving
+const replicationChunk = 64 << 10
+
+// Primary replicates the sequenced commands of an exchange to a hot standby backup, over a TCP connection
+// A backup connecting is sent a snapshot of the exchange, followed by every later command (and the action hash after it)
+// The commands processed while the snapshot is sent are buffered, and sent once it has been, until the backup catches up
+// From then on, each command is only processed by the primary once the backup has processed it, so no accepted order
+// is lost on failover
+type Primary struct {
+	exchange *Exchange
+	listener net.Listener
+	options  ReplicationOptions
+	conn     net.Conn        // Connection to the current backup (nil if none), guarded by the exchange's command mutex
+	joining  net.Conn        // Connection to a backup catching up since its snapshot (nil if none), also guarded by it
+	backlog  []backlogRecord // Records buffered for the joining backup, not yet sent
+	queued   int             // Size of the backlog, in bytes
+	buf      []byte          // Reused encoding buffer
+	err      error           // Reason the last backup was dropped
+	stop     chan struct{}
+	stopped  sync.WaitGroup
+}
+
+// backlogRecord represents an encoded record buffered for a joining backup
+type backlogRecord struct {
+	data     []byte
+	sequence uint64 // Sequence number of the command, to check the backup's acknowledgement (zero for a hash record)
+}
+
+// StartPrimary replicates the exchange's commands to the backup that connects to the listener
+// A backup connecting later replaces the current backup. If the backup fails, the primary carries on without one
+func (ex *Exchange) StartPrimary(listener net.Listener, options ReplicationOptions) *Primary {
+	p := &Primary{
+		exchange: ex,
+		listener: listener,
+		options:  options.withDefaults(),
+		stop:     make(chan struct{}),
+	}
+
+	// Lock the command mutex, so the primary is not attached part way through a command
+	ex.commandMutex.Lock()
+	ex.primary = p
+	ex.commandMutex.Unlock()
+
+	p.stopped.Add(2)
+	go p.accept()
+	go p.heartbeat()
+	return p
+}
+
+// accept attaches each backup that connects, until the listener is closed
+func (p *Primary) accept() {
+	defer p.stopped.Done()
+
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		p.attach(conn)
+	}
+}
+
+// attach sends a snapshot of the exchange to a newly connected backup, and then replicates every later command to it
+// The snapshot (and the commands processed while it is sent) are sent without holding the command mutex,
+// so the exchange carries on processing commands while the backup catches up
+func (p *Primary) attach(conn net.Conn) {
+	// Lock the command mutex, so the snapshot is taken between commands and every later command is buffered
+	ex := p.exchange
+	ex.commandMutex.Lock()
+	select {
+	case <-p.stop:
+		ex.commandMutex.Unlock()
+		conn.Close()
+		return
+	default:
+	}
+
+	// Replace the current backup (if any)
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+	p.dropJoining(nil)
+	p.joining = conn
+	snapshot := ex.snapshot()
+	ex.commandMutex.Unlock()
+
+	// Send the snapshot, prefixed by its length
+	buf := binary.LittleEndian.AppendUint64(nil, uint64(len(snapshot)))
+	buf = append(buf, snapshot...)
+	if err := writeChunks(conn, buf, p.options.FailureTimeout); err != nil {
+		p.failJoining(conn, err)
+		return
+	}
+
+	// Send the buffered commands until there are none left, and then replicate every later command as it is processed
+	for {
+		ex.commandMutex.Lock()
+		if p.joining != conn {
+			// The backup was replaced, dropped or the primary closed
+			ex.commandMutex.Unlock()
+			return
+		}
+		backlog := p.backlog
+		p.backlog, p.queued = nil, 0
+		if len(backlog) == 0 {
+			p.joining = nil
+			p.conn = conn
+			ex.commandMutex.Unlock()
+			return
+		}
+		ex.commandMutex.Unlock()
+
+		for _, record := range backlog {
+			if err := p.sendRecord(conn, record); err != nil {
+				p.failJoining(conn, err)
+				return
+			}
+		}
+	}
+}
+
+// sendRecord sends a buffered record to a joining backup, waiting until the backup has processed it (if a command)
+func (p *Primary) sendRecord(conn net.Conn, record backlogRecord) error {
+	if err := writeChunks(conn, record.data, p.options.FailureTimeout); err != nil {
+		return err
+	}
+	if record.sequence == 0 {
+		return nil
+	}
+	return awaitAck(conn, record.sequence, p.options.FailureTimeout)
+}
+
+// buffer adds the encoded buffer to the backlog of the joining backup (if any), dropping the backup if the backlog is too large
+// The command mutex must be held by the caller
+func (p *Primary) buffer(sequence uint64) {
+	if p.joining == nil {
+		return
+	}
+	p.backlog = append(p.backlog, backlogRecord{data: slices.Clone(p.buf), sequence: sequence})
+	p.queued += len(p.buf)
+	if p.queued > replicationMaxSnapshot {
+		p.dropJoining(fmt.Errorf("backlog of %d bytes exceeds the maximum of %d", p.queued, replicationMaxSnapshot))
+	}
+}
+
+// failJoining drops the joining backup after a failure to send to it, unless it has since been replaced
+func (p *Primary) failJoining(conn net.Conn, err error) {
+	p.exchange.commandMutex.Lock()
+	defer p.exchange.commandMutex.Unlock()
+
+	if p.joining == conn {
+		p.dropJoining(err)
+	}
+	conn.Close()
+}
+
+// dropJoining disconnects the joining backup (if any), recording the failure (if any)
+// The command mutex must be held by the caller
+func (p *Primary) dropJoining(err error) {
+	if p.joining == nil {
+		return
+	}
+	p.joining.Close()
+	p.joining = nil
+	p.backlog, p.queued = nil, 0
+	if err != nil {
+		p.err = fmt.Errorf("exchange: backup failed: %w", err)
+	}
+}
+
+// heartbeat sends the action hash of the last command to the backup every interval, until the primary is closed
+// The backup detects a failed primary when the heartbeats stop, and checks its actions against the hash
+func (p *Primary) heartbeat() {
+	defer p.stopped.Done()
+
+	ticker := time.NewTicker(p.options.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ex := p.exchange
+			ex.commandMutex.Lock()
+			p.replicateHash(ex.commandSequence, ex.ActionHash())
+			ex.commandMutex.Unlock()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// replicate sends the command to the backup (if any), waiting until the backup has processed it
+// The command mutex must be held by the caller
+func (p *Primary) replicate(cmd *Command) {
+	if p.conn == nil && p.joining == nil {
+		return
+	}
+	p.buf = appendRecord(p.buf[:0], journalCommandRecord, func(buf []byte) []byte {
+		return appendCommand(buf, cmd)
+	})
+	p.buffer(cmd.Sequence)
+	if p.conn == nil || !p.send() {
+		return
+	}
+
+	// Wait for the backup to acknowledge the command
+	if err := awaitAck(p.conn, cmd.Sequence, p.options.FailureTimeout); err != nil {
+		p.drop(err)
+	}
+}
+
+// awaitAck waits for the backup to acknowledge processing the command with the given sequence number
+func awaitAck(conn net.Conn, sequence uint64, timeout time.Duration) error {
+	var ack [8]byte
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	if _, err := io.ReadFull(conn, ack[:]); err != nil {
+		return err
+	}
+	if acked := binary.LittleEndian.Uint64(ack[:]); acked != sequence {
+		return fmt.Errorf("acknowledged command %d, expected %d", acked, sequence)
+	}
+	return nil
+}
+
+// replicateHash sends the action hash after the command with the given sequence number to the backup (if any)
+// The command mutex must be held by the caller
+func (p *Primary) replicateHash(sequence uint64, hash uint64) {
+	if p.conn == nil && p.joining == nil {
+		return
+	}
+	p.buf = appendRecord(p.buf[:0], journalHashRecord, func(buf []byte) []byte {
+		return appendHashBody(buf, sequence, hash)
+	})
+	p.buffer(0)
+	if p.conn != nil {
+		p.send()
+	}
+}
+
+// send writes the encoded buffer to the backup, dropping the backup if a write fails (or blocks for too long)
+// The command mutex must be held by the caller
+func (p *Primary) send() bool {
+	if err := writeChunks(p.conn, p.buf, p.options.FailureTimeout); err != nil {
+		p.drop(err)
+		return false
+	}
+	return true
+}
+
+// writeChunks writes buf to the connection in chunks, extending the write deadline before each one
+func writeChunks(conn net.Conn, buf []byte, timeout time.Duration) error {
+	for len(buf) > 0 {
+		n := min(len(buf), replicationChunk)
+		conn.SetWriteDeadline(time.Now().Add(timeout))
+		if _, err := conn.Write(buf[:n]); err != nil {
+			return err
+		}
+		buf = buf[n:]
+	}
+	return nil
+}
+
+// drop disconnects the backup after a failure. The command mutex must be held by the caller
+func (p *Primary) drop(err error) {
+	p.conn.Close()
+	p.conn = nil
+	p.err = fmt.Errorf("exchange: backup failed: %w", err)
+}
+
+// Connected returns whether a backup is currently connected to the primary
+func (p *Primary) Connected() bool {
+	p.exchange.commandMutex.Lock()
+	defer p.exchange.commandMutex.Unlock()
+
+	return p.conn != nil
+}
+
+// Err returns the reason the last backup was dropped by the primary, or nil
+func (p *Primary) Err() error {
+	p.exchange.commandMutex.Lock()
+	defer p.exchange.commandMutex.Unlock()
+
+	return p.err
+}
+
+// Close stops replicating the exchange's commands, closing the listener and disconnecting the backup
+func (p *Primary) Close() error {
+	select {
+	case <-p.stop:
+		return nil
+	default:
+		close(p.stop)
+	}
+	err := p.listener.Close()
+
+	// Lock the command mutex, so the primary is not detached part way through a command
+	ex := p.exchange
+	ex.commandMutex.Lock()
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+	p.dropJoining(nil)
+	if ex.primary == p {
+		ex.primary = nil
+	}
+	ex.commandMutex.Unlock()
+
+	p.stopped.Wait()
+	return err
+}
+
+// Backup follows a primary exchange as a hot standby, processing the same commands so it can be promoted on failure
+// The backup's exchange must not be sent commands until it is promoted
+type Backup struct {
+	exchange *Exchange
+	conn     net.Conn
+	options  ReplicationOptions
+	done     chan struct{} // Closed once the backup has stopped following the primary
+	err      error         // Reason the backup stopped following the primary
+}
+
+// StartBackup connects to the primary at addr, replaces the exchange's state with the primary's snapshot,
+// and then follows the primary's commands until the primary fails (or the backup is promoted)
+func (ex *Exchange) StartBackup(addr string, options ReplicationOptions) (*Backup, error) {
+	options = options.withDefaults()
+	conn, err := net.DialTimeout("tcp", addr, options.FailureTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return ex.startBackup(conn, options)
+}
+
+// startBackup replaces the exchange's state with the snapshot read from the primary's connection, and then follows it
+func (ex *Exchange) startBackup(conn net.Conn, options ReplicationOptions) (*Backup, error) {
+	// Restore the exchange from the primary's snapshot
+	reader := bufio.NewReader(conn)
+	snapshot, err := readSnapshot(conn, reader, options.FailureTimeout)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	ex.commandMutex.Lock()
+	err = ex.restore(snapshot)
+	ex.commandMutex.Unlock()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	b := &Backup{
+		exchange: ex,
+		conn:     conn,
+		options:  options,
+		done:     make(chan struct{}),
+	}
+	go b.follow(NewJournalReader(reader))
+	return b, nil
+}
+
+// readSnapshot reads the length-prefixed snapshot sent by the primary, extending the read deadline as each chunk arrives
+// The buffer grows with the data received, so a corrupted length fails once the primary stops sending rather than being allocated
+func readSnapshot(conn net.Conn, reader io.Reader, timeout time.Duration) ([]byte, error) {
+	var length [8]byte
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	if _, err := io.ReadFull(reader, length[:]); err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint64(length[:])
+	if size > replicationMaxSnapshot {
+		return nil, fmt.Errorf("exchange: snapshot length %d exceeds the maximum of %d", size, replicationMaxSnapshot)
+	}
+
+	snapshot := make([]byte, 0, min(size, replicationChunk))
+	for uint64(len(snapshot)) < size {
+		n := int(min(size-uint64(len(snapshot)), replicationChunk))
+		snapshot = slices.Grow(snapshot, n)
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		if _, err := io.ReadFull(reader, snapshot[len(snapshot):len(snapshot)+n]); err != nil {
+			return nil, err
+		}
+		snapshot = snapshot[:len(snapshot)+n]
+	}
+	return snapshot, nil
+}
+
+// follow processes the commands sent by the primary, acknowledging each once processed, and checks the action hashes
+// Stops once the primary fails (nothing is received within the failure timeout), or the backup diverges from it
+func (b *Backup) follow(reader *JournalReader) {
+	defer close(b.done)
+
+	ex := b.exchange
+	var ack [8]byte
+	for {
+		b.conn.SetReadDeadline(time.Now().Add(b.options.FailureTimeout))
+		cmd, hash, err := reader.next()
+		if err != nil {
+			b.err = fmt.Errorf("exchange: primary failed: %w", err)
+			b.conn.Close()
+			return
+		}
+
+		// Lock the command mutex, as the commands are processed as if replayed from the primary's journal
+		ex.commandMutex.Lock()
+		if hash != nil {
+			err = ex.checkHash(hash)
+		} else if applied, replayErr := ex.replayCommand(&cmd); replayErr != nil {
+			err = replayErr
+		} else if !applied {
+			// The primary only sends commands after its snapshot, so the backup has processed a command of its own
+			err = &ReplayError{Sequence: cmd.Sequence, Reason: "command already processed by the backup"}
+		}
+		ex.commandMutex.Unlock()
+		if err != nil {
+			b.err = err
+			b.conn.Close()
+			return
+		}
+
+		// Acknowledge the command, so the primary can process it
+		if hash == nil {
+			binary.LittleEndian.PutUint64(ack[:], cmd.Sequence)
+			b.conn.SetWriteDeadline(time.Now().Add(b.options.FailureTimeout))
+			if _, err := b.conn.Write(ack[:]); err != nil {
+				b.err = fmt.Errorf("exchange: primary failed: %w", err)
+				b.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// Done returns a channel that is closed once the backup has stopped following the primary (eg. the primary has failed)
+func (b *Backup) Done() <-chan struct{} {
+	return b.done
+}
+
+// Err returns the reason the backup stopped following the primary (once Done is closed): the primary failed, or a *ReplayError
+func (b *Backup) Err() error {
+	select {
+	case <-b.done:
+		return b.err
+	default:
+		return nil
+	}
+}
+
+// Promote stops following the primary, so the backup's exchange can accept commands itself
+// A backup that diverged from the primary is not promoted, and its *ReplayError is returned
+func (b *Backup) Promote() error {
+	b.conn.Close()
+	<-b.done
+
+	var replayErr *ReplayError
+	if errors.As(b.err, &replayErr) {
+		return b.err
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package exchange
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testReplication is fast enough failure detection for the tests
+var testReplication = ReplicationOptions{HeartbeatInterval: 10 * time.Millisecond, FailureTimeout: 200 * time.Millisecond}
+
+// startReplication starts a primary exchange (with some orders already sent) and a backup following it
+func startReplication(t *testing.T) (*Exchange, *Primary, *Exchange, *Backup) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected to listen, got %v", err)
+	}
+
+	primary := &Exchange{}
+	primary.Init("Primary Exchange", nil, SubscribeOptions{})
+	primary.Limit("AAPL", 100, 10, Bid, 1)
+	primary.Limit("AAPL", 102, 5, Ask, 2)
+	replication := primary.StartPrimary(listener, testReplication)
+	t.Cleanup(func() { replication.Close() })
+
+	backup := &Exchange{}
+	backup.Init("Backup Exchange", nil, SubscribeOptions{})
+	following, err := backup.StartBackup(listener.Addr().String(), testReplication)
+	if err != nil {
+		t.Fatalf("Expected the backup to connect, got %v", err)
+	}
+	t.Cleanup(func() { following.Promote() })
+
+	// Wait for the primary to attach the backup
+	for deadline := time.Now().Add(time.Second); !replication.Connected(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the backup to be attached")
+		}
+	}
+	return primary, replication, backup, following
+}
+
+func TestReplication_Follow(t *testing.T) {
+	primary, replication, backup, following := startReplication(t)
+
+	// The backup processes every command before the primary does
+	primary.Limit("AAPL", 101, 3, Bid, 3)
+	primary.Limit("AAPL", 100, 8, Ask, 4)
+	primary.Submit(OrderRequest{Symbol: "MSFT", Price: 50, Size: 5, Side: Bid, Trader: 5, ClientOrderID: "m"})
+	primary.CancelByClientOrderID(5, "m")
+
+	if !reflect.DeepEqual(backup.Orders("AAPL"), primary.Orders("AAPL")) {
+		t.Errorf("Expected the backup orders %+v, got %+v", primary.Orders("AAPL"), backup.Orders("AAPL"))
+	}
+	if backup.ActionHash() != primary.ActionHash() {
+		t.Errorf("Expected the backup action hash %x, got %x", primary.ActionHash(), backup.ActionHash())
+	}
+
+	// The heartbeats keep an idle backup following the primary, checking the action hashes agree
+	time.Sleep(3 * testReplication.FailureTimeout)
+	select {
+	case <-following.Done():
+		t.Fatalf("Expected the backup to keep following an idle primary, got %v", following.Err())
+	default:
+	}
+	if err := replication.Err(); err != nil {
+		t.Errorf("Expected the backup not to be dropped, got %v", err)
+	}
+}
+
+func TestReplication_Failover(t *testing.T) {
+	primary, replication, backup, following := startReplication(t)
+	last, _ := primary.Limit("AAPL", 99, 1, Bid, 3)
+
+	// The primary fails, and the backup detects the missing heartbeats
+	replication.Close()
+	select {
+	case <-following.Done():
+	case <-time.After(5 * testReplication.FailureTimeout):
+		t.Fatalf("Expected the backup to detect the failed primary")
+	}
+	if following.Err() == nil {
+		t.Errorf("Expected the reason the backup stopped following")
+	}
+
+	// Once promoted, the backup carries on from the primary's last order
+	if err := following.Promote(); err != nil {
+		t.Fatalf("Expected the backup to be promoted, got %v", err)
+	}
+	if status, ok := backup.OrderStatus(last); !ok || status.State != OrderNew {
+		t.Errorf("Expected the primary's last order to be working on the backup, got %+v", status)
+	}
+	next, err := backup.Limit("AAPL", 102, 5, Bid, 4)
+	if err != nil || next != last+1 {
+		t.Errorf("Expected the promoted backup to assign OrderID %d, got %d (%v)", last+1, next, err)
+	}
+}
+
+func TestReplication_Divergence(t *testing.T) {
+	primary, replication, backup, following := startReplication(t)
+
+	// A command processed by the backup alone makes it diverge from the primary
+	backup.Limit("AAPL", 90, 1, Bid, 9)
+	primary.Limit("AAPL", 99, 1, Bid, 3)
+
+	select {
+	case <-following.Done():
+	case <-time.After(5 * testReplication.FailureTimeout):
+		t.Fatalf("Expected the backup to stop following the primary")
+	}
+	var replayErr *ReplayError
+	if err := following.Promote(); !errors.As(err, &replayErr) {
+		t.Errorf("Expected the diverged backup not to be promoted, got %v", err)
+	}
+
+	// The primary drops the backup, and carries on without it
+	if replication.Connected() || replication.Err() == nil {
+		t.Errorf("Expected the primary to drop the diverged backup")
+	}
+	if _, err := primary.Limit("AAPL", 98, 1, Bid, 3); err != nil {
+		t.Errorf("Expected the primary to carry on without the backup, got %v", err)
+	}
+}
+
+// pipeListener is a listener accepting the connections passed to it (eg. one end of a net.Pipe)
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (listener *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-listener.conns:
+		return conn, nil
+	case <-listener.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (listener *pipeListener) Close() error {
+	listener.once.Do(func() { close(listener.closed) })
+	return nil
+}
+
+func (listener *pipeListener) Addr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func TestReplication_SlowAttach(t *testing.T) {
+	listener := &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+	primary := &Exchange{}
+	primary.Init("Primary Exchange", nil, SubscribeOptions{})
+	primary.Limit("AAPL", 100, 10, Bid, 1)
+	replication := primary.StartPrimary(listener, testReplication)
+	defer replication.Close()
+
+	// A backup connects, but does not read its snapshot yet (the pipe has no buffer, so the primary's write waits)
+	primaryEnd, backupEnd := net.Pipe()
+	listener.conns <- primaryEnd
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		primary.commandMutex.Lock()
+		joining := replication.joining != nil
+		primary.commandMutex.Unlock()
+		if joining {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the primary to start sending the snapshot")
+		}
+	}
+
+	// Orders are processed while the snapshot is sent, without waiting for the backup
+	start := time.Now()
+	if _, err := primary.Limit("AAPL", 101, 5, Bid, 2); err != nil || time.Since(start) >= testReplication.FailureTimeout/2 {
+		t.Errorf("Expected the order to be processed without waiting for the snapshot, took %v (%v)", time.Since(start), err)
+	}
+
+	// Once the backup reads the snapshot, it is sent the buffered order, and then follows the primary
+	backup := &Exchange{}
+	backup.Init("Backup Exchange", nil, SubscribeOptions{})
+	following, err := backup.startBackup(backupEnd, testReplication)
+	if err != nil {
+		t.Fatalf("Expected the backup to read the snapshot, got %v", err)
+	}
+	defer following.Promote()
+	for deadline := time.Now().Add(time.Second); !replication.Connected(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the backup to catch up, got %v", replication.Err())
+		}
+	}
+	primary.Limit("AAPL", 99, 1, Bid, 3)
+	if !reflect.DeepEqual(backup.Orders("AAPL"), primary.Orders("AAPL")) || backup.ActionHash() != primary.ActionHash() {
+		t.Errorf("Expected the backup orders %+v, got %+v", primary.Orders("AAPL"), backup.Orders("AAPL"))
+	}
+}
+
+func TestReplication_SnapshotLength(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected to listen, got %v", err)
+	}
+	defer listener.Close()
+
+	// A primary sending a corrupted snapshot length
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	}()
+
+	var backup Exchange
+	backup.Init("Backup Exchange", nil, SubscribeOptions{})
+	if _, err := backup.StartBackup(listener.Addr().String(), testReplication); err == nil {
+		t.Errorf("Expected the corrupted snapshot length to be refused")
+	}
+}
+
+func TestReplication_SlowSnapshot(t *testing.T) {
+	primary, backup := net.Pipe()
+	defer primary.Close()
+	defer backup.Close()
+
+	// A snapshot of several chunks, taking longer in total than the timeout, but with each chunk arriving within it
+	timeout := 100 * time.Millisecond
+	snapshot := make([]byte, 8*replicationChunk)
+	go func() {
+		var length [8]byte
+		binary.LittleEndian.PutUint64(length[:], uint64(len(snapshot)))
+		primary.Write(length[:])
+		for i := 0; i < len(snapshot); i += replicationChunk {
+			time.Sleep(timeout / 4)
+			primary.Write(snapshot[i : i+replicationChunk])
+		}
+	}()
+
+	got, err := readSnapshot(backup, backup, timeout)
+	if err != nil || len(got) != len(snapshot) {
+		t.Errorf("Expected the whole snapshot of %d bytes, got %d (%v)", len(snapshot), len(got), err)
+	}
+}
@@ -0,0 +1,393 @@
+package exchange
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+)
+
+// Define the names of the built-in risk rules, reported with a RejectRiskLimit rejection
+const (
+	RiskRuleMaxOrderSize     = "max order size"
+	RiskRuleMaxNotional      = "max notional"
+	RiskRuleMaxOpenOrders    = "max open orders"
+	RiskRuleMaxGrossExposure = "max gross exposure"
+	RiskRuleMaxNetExposure   = "max net exposure"
+)
+
+// RiskLimits represents the pre-trade limits of a trader. A zero limit is not checked
+// Exposures are per symbol, in shares: the filled position plus the working orders (and the incoming order)
+type RiskLimits struct {
+	MaxOrderSize     Size   // Largest order size
+	MaxNotional      uint64 // Largest order notional (price × size; market orders at the worst price they may trade at)
+	MaxOpenOrders    int    // Most working orders, across all symbols
+	MaxGrossExposure uint64 // Largest |position| + working bids + working asks
+	MaxNetExposure   uint64 // Largest position if every working order on one side filled
+}
+
+// Exposure represents a trader's position and working orders in a symbol
+type Exposure struct {
+	Position   int64  // Filled position (bought minus sold)
+	OpenBids   uint64 // Remaining size of the working bids
+	OpenAsks   uint64 // Remaining size of the working asks
+	OpenOrders int    // Working orders of the trader, across all symbols
+}
+
+// gross returns the gross exposure: the position and every working order, regardless of side
+func (exposure Exposure) gross() uint64 {
+	position := exposure.Position
+	if position < 0 {
+		position = -position
+	}
+	return uint64(position) + exposure.OpenBids + exposure.OpenAsks
+}
+
+// RiskCheck represents an incoming order being checked by the risk rules, with the trader's limits and exposure before it
+type RiskCheck struct {
+	Symbol   string
+	Side     Side
+	Price    Price // Limit price (for market orders, the worst price the order may trade at)
+	Size     Size
+	Trader   TraderID
+	Limits   RiskLimits
+	Exposure Exposure
+}
+
+// RiskRule represents a named pre-trade check; an order failing the check is rejected with the rule's name
+type RiskRule interface {
+	Name() string
+	Check(check RiskCheck) bool // Returns whether the order passes the check
+}
+
+// riskRuleFunc is a risk rule implemented by a function
+type riskRuleFunc struct {
+	name  string
+	check func(check RiskCheck) bool
+}
+
+// Name returns the name of the risk rule
+func (rule riskRuleFunc) Name() string {
+	return rule.name
+}
+
+// Check returns whether the order passes the risk rule
+func (rule riskRuleFunc) Check(check RiskCheck) bool {
+	return rule.check(check)
+}
+
+// NewRiskRule creates a risk rule with the given name, that passes orders for which check returns true
+func NewRiskRule(name string, check func(check RiskCheck) bool) RiskRule {
+	return riskRuleFunc{name: name, check: check}
+}
+
+// DefaultRiskRules returns the built-in risk rules, checking each of the RiskLimits (in the order they are declared)
+func DefaultRiskRules() []RiskRule {
+	return []RiskRule{
+		NewRiskRule(RiskRuleMaxOrderSize, func(check RiskCheck) bool {
+			return check.Limits.MaxOrderSize == 0 || check.Size <= check.Limits.MaxOrderSize
+		}),
+		NewRiskRule(RiskRuleMaxNotional, func(check RiskCheck) bool {
+			return check.Limits.MaxNotional == 0 || uint64(check.Price)*uint64(check.Size) <= check.Limits.MaxNotional
+		}),
+		NewRiskRule(RiskRuleMaxOpenOrders, func(check RiskCheck) bool {
+			return check.Limits.MaxOpenOrders == 0 || check.Exposure.OpenOrders < check.Limits.MaxOpenOrders
+		}),
+		NewRiskRule(RiskRuleMaxGrossExposure, func(check RiskCheck) bool {
+			return check.Limits.MaxGrossExposure == 0 || check.Exposure.gross()+uint64(check.Size) <= check.Limits.MaxGrossExposure
+		}),
+		NewRiskRule(RiskRuleMaxNetExposure, func(check RiskCheck) bool {
+			if check.Limits.MaxNetExposure == 0 {
+				return true
+			}
+			// The worst case position on the order's side: every working order on that side (and the order) filling
+			if check.Side == Bid {
+				return check.Exposure.Position+int64(check.Exposure.OpenBids)+int64(check.Size) <= int64(check.Limits.MaxNetExposure)
+			}
+			return -check.Exposure.Position+int64(check.Exposure.OpenAsks)+int64(check.Size) <= int64(check.Limits.MaxNetExposure)
+		}),
+	}
+}
+
+// riskKey identifies a trader's exposure in a symbol
+type riskKey struct {
+	trader TraderID
+	symbol string
+}
+
+// RiskManager represents the pre-trade risk layer, checking each incoming order against its trader's limits (set on the exchange)
+// It tracks each trader's positions and working orders from the orders processed by the exchange it is attached to
+// The same risk rules must be attached to an exchange replaying (or following) another, so it makes the same decisions
+type RiskManager struct {
+	rules      []RiskRule
+	exposures  map[riskKey]*Exposure
+	openOrders map[TraderID]int
+	mutex      sync.Mutex
+}
+
+// NewRiskManager creates a risk manager applying the given rules (DefaultRiskRules if none) to every trader, with no limits set
+func NewRiskManager(rules ...RiskRule) *RiskManager {
+	if len(rules) == 0 {
+		rules = DefaultRiskRules()
+	}
+	return &RiskManager{
+		rules:      rules,
+		exposures:  make(map[riskKey]*Exposure),
+		openOrders: make(map[TraderID]int),
+	}
+}
+
+// Exposure returns the trader's current position and working orders in the symbol
+func (rm *RiskManager) Exposure(trader TraderID, symbol string) Exposure {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	return rm.current(trader, symbol)
+}
+
+// current returns a copy of the trader's exposure in the symbol. The risk manager mutex must be held by the caller
+func (rm *RiskManager) current(trader TraderID, symbol string) Exposure {
+	exposure := Exposure{OpenOrders: rm.openOrders[trader]}
+	if tracked, ok := rm.exposures[riskKey{trader, symbol}]; ok {
+		exposure.Position, exposure.OpenBids, exposure.OpenAsks = tracked.Position, tracked.OpenBids, tracked.OpenAsks
+	}
+	return exposure
+}
+
+// check runs the rules against the incoming order with its trader's limits, returning the name of the first failing rule (empty if it passes)
+// For an amendment, the working order it replaces is excluded from the trader's exposure
+func (rm *RiskManager) check(order *Order, replaced *Order, limits RiskLimits) string {
+	if rm == nil {
+		return ""
+	}
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	check := RiskCheck{
+		Symbol:   order.symbol,
+		Side:     order.side,
+		Price:    order.price,
+		Size:     order.size,
+		Trader:   order.trader,
+		Limits:   limits,
+		Exposure: rm.current(order.trader, order.symbol),
+	}
+	if replaced != nil {
+		check.Exposure.OpenOrders -= 1
+		if replaced.side == Bid {
+			check.Exposure.OpenBids -= uint64(replaced.size)
+		} else {
+			check.Exposure.OpenAsks -= uint64(replaced.size)
+		}
+	}
+
+	for _, rule := range rm.rules {
+		if !rule.Check(check) {
+			return rule.Name()
+		}
+	}
+	return ""
+}
+
+// exposure returns the tracked exposure of the order's trader in its symbol, creating it if needed
+// The risk manager mutex must be held by the caller
+func (rm *RiskManager) exposure(order *Order) *Exposure {
+	key := riskKey{order.trader, order.symbol}
+	tracked, ok := rm.exposures[key]
+	if !ok {
+		tracked = &Exposure{}
+		rm.exposures[key] = tracked
+	}
+	return tracked
+}
+
+// addOpen adds (or with a negative size, removes) working size on the order's side. The risk manager mutex must be held by the caller
+func (rm *RiskManager) addOpen(order *Order, size int64) {
+	tracked := rm.exposure(order)
+	if order.side == Bid {
+		tracked.OpenBids = uint64(int64(tracked.OpenBids) + size)
+	} else {
+		tracked.OpenAsks = uint64(int64(tracked.OpenAsks) + size)
+	}
+}
+
+// accept records an accepted order as working
+func (rm *RiskManager) accept(order *Order) {
+	if rm == nil {
+		return
+	}
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	rm.openOrders[order.trader] += 1
+	rm.addOpen(order, int64(order.size))
+}
+
+// fill records a fill of a working order, moving the filled size from working to the position
+func (rm *RiskManager) fill(order *Order, fill_size Size) {
+	if rm == nil {
+		return
+	}
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	rm.addOpen(order, -int64(fill_size))
+	if order.side == Bid {
+		rm.exposure(order).Position += int64(fill_size)
+	} else {
+		rm.exposure(order).Position -= int64(fill_size)
+	}
+}
+
+// amend records a change to the remaining size of a working order
+func (rm *RiskManager) amend(order *Order, newSize Size) {
+	if rm == nil {
+		return
+	}
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	rm.addOpen(order, int64(newSize)-int64(order.size))
+}
+
+// close records that an order is no longer working, releasing its unfilled size
+func (rm *RiskManager) close(order *Order) {
+	if rm == nil {
+		return
+	}
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	rm.openOrders[order.trader] -= 1
+	if rm.openOrders[order.trader] <= 0 {
+		delete(rm.openOrders, order.trader)
+	}
+	rm.addOpen(order, -int64(order.original-order.filled))
+}
+
+// reset replaces the tracked exposures with the given positions and working orders (eg. after a restore)
+func (rm *RiskManager) reset(positions []riskPosition, working map[OrderID]*orderNode) {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	rm.exposures = make(map[riskKey]*Exposure)
+	rm.openOrders = make(map[TraderID]int)
+	for _, position := range positions {
+		rm.exposures[riskKey{position.trader, position.symbol}] = &Exposure{Position: position.position}
+	}
+	for _, node := range working {
+		rm.openOrders[node.order.trader] += 1
+		rm.addOpen(&node.order, int64(node.order.size))
+	}
+}
+
+// riskPosition represents a trader's filled position in a symbol, as held in a snapshot
+type riskPosition struct {
+	trader   TraderID
+	symbol   string
+	position int64
+}
+
+// positions returns the non-zero filled positions, in trader and then symbol order
+func (rm *RiskManager) positions() []riskPosition {
+	if rm == nil {
+		return nil
+	}
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	var positions []riskPosition
+	for key, tracked := range rm.exposures {
+		if tracked.Position != 0 {
+			positions = append(positions, riskPosition{key.trader, key.symbol, tracked.Position})
+		}
+	}
+	slices.SortFunc(positions, func(a, b riskPosition) int {
+		if a.trader != b.trader {
+			return cmp.Compare(a.trader, b.trader)
+		}
+		return cmp.Compare(a.symbol, b.symbol)
+	})
+	return positions
+}
+
+// SetRiskManager attaches a pre-trade risk manager to the exchange, checking every incoming order before it is matched
+// The orders already working are tracked from the books; positions are tracked from the fills after it is attached
+// (or restored from a snapshot). A nil risk manager removes the checks
+func (ex *Exchange) SetRiskManager(rm *RiskManager) {
+	// Lock the command mutex, so that no command is part way through processing
+	ex.commandMutex.Lock()
+	defer ex.commandMutex.Unlock()
+
+	if rm != nil {
+		ex.mutex.RLock()
+		rm.reset(nil, ex.orderIDMap)
+		ex.mutex.RUnlock()
+	}
+	ex.risk = rm
+}
+
+// SetDefaultRiskLimits sets the risk limits of the traders without limits of their own
+// The limits are sequenced (and journalled) as a command, and checked by the risk manager attached (if any)
+func (ex *Exchange) SetDefaultRiskLimits(limits RiskLimits) {
+	ex.execute(&Command{Type: CommandSetRiskLimits, Limits: limits})
+}
+
+// SetRiskLimits sets the risk limits of the trader, in place of the default limits
+// The limits are sequenced (and journalled) as a command, and checked by the risk manager attached (if any)
+func (ex *Exchange) SetRiskLimits(trader TraderID, limits RiskLimits) {
+	if trader <= 0 {
+		return
+	}
+	ex.execute(&Command{Type: CommandSetRiskLimits, Request: OrderRequest{Trader: trader}, Limits: limits})
+}
+
+// setRiskLimits sets the risk limits of the trader, or the default limits for trader zero
+func (ex *Exchange) setRiskLimits(trader TraderID, limits RiskLimits) {
+	// Lock the exchange mutex to prevent concurrent access
+	ex.mutex.Lock()
+	defer ex.mutex.Unlock()
+
+	if trader == 0 {
+		ex.riskDefaults = limits
+		return
+	}
+	ex.riskLimits[trader] = limits
+}
+
+// traderRiskLimits returns the risk limits of the trader. The exchange mutex must be held by the caller
+func (ex *Exchange) traderRiskLimits(trader TraderID) RiskLimits {
+	if limits, ok := ex.riskLimits[trader]; ok {
+		return limits
+	}
+	return ex.riskDefaults
+}
+
+// checkRisk runs the pre-trade risk checks on an incoming order, returning the name of the first failing rule (empty if none)
+func (ex *Exchange) checkRisk(order *Order) string {
+	// Lock the exchange mutex (for reading) to look up the trader's limits
+	ex.mutex.RLock()
+	limits := ex.traderRiskLimits(order.trader)
+	ex.mutex.RUnlock()
+
+	return ex.risk.check(order, nil, limits)
+}
+
+// marketRiskPrice returns the worst price a market order may trade at, used to check its notional
+func (ex *Exchange) marketRiskPrice(symbol string, side Side) Price {
+	// Lock the exchange mutex (for reading) to look up the orderbook, without creating it
+	ex.mutex.RLock()
+	ob, exists := ex.orderbooksMap[symbol]
+	ex.mutex.RUnlock()
+	if !exists {
+		if side == Bid {
+			return MaxPrice
+		}
+		return MinPrice
+	}
+
+	// Lock the orderbook mutex (for reading) to prevent concurrent access
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	return ob.marketLimitPrice(side, ex.getMarketProtection())
+}
@@ -0,0 +1,292 @@
+package exchange
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// riskRule returns the name of the risk rule that rejected the order, or empty if it was not rejected by a risk check
+func riskRule(err error) string {
+	var rejectErr *RejectError
+	if !errors.As(err, &rejectErr) || rejectErr.Reason != RejectRiskLimit {
+		return ""
+	}
+	return rejectErr.Rule
+}
+
+func TestRiskManager_Rules(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits RiskLimits
+		rule   string
+	}{
+		{"order size", RiskLimits{MaxOrderSize: 5}, RiskRuleMaxOrderSize},
+		{"notional", RiskLimits{MaxNotional: 1000}, RiskRuleMaxNotional},
+		{"open orders", RiskLimits{MaxOpenOrders: 2}, RiskRuleMaxOpenOrders},
+		{"gross exposure", RiskLimits{MaxGrossExposure: 25}, RiskRuleMaxGrossExposure},
+		{"net exposure", RiskLimits{MaxNetExposure: 15}, RiskRuleMaxNetExposure},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actions := make(chan *Action, ChanSize)
+			var exchange Exchange
+			exchange.Init("Test Exchange", actions, SubscribeOptions{})
+			rm := NewRiskManager()
+			exchange.SetDefaultRiskLimits(test.limits)
+			exchange.SetRiskManager(rm)
+
+			// The working orders pass every limit (other than the order size, for the 10 bid)
+			if _, err := exchange.Limit("AAPL", 100, 5, Ask, 1); err != nil {
+				t.Fatalf("Expected the first order to pass, got %v", err)
+			}
+			exchange.Limit("AAPL", 90, 10, Bid, 1)
+			drainOrderActions(&exchange, actions)
+
+			// A further bid of 11 at 99 fails the limit under test
+			_, err := exchange.Limit("AAPL", 99, 11, Bid, 1)
+			if got := riskRule(err); got != test.rule {
+				t.Fatalf("Expected the order to fail the %q rule, got %v", test.rule, err)
+			}
+			got := drainOrderActions(&exchange, actions)
+			if len(got) != 1 || got[0].action_type != ActionOrderReject || got[0].RejectReason() != RejectRiskLimit || got[0].RiskRule() != test.rule {
+				t.Fatalf("Expected a risk reject action for the %q rule, got %v", test.rule, got)
+			}
+
+			// A rejected order is not tracked as working
+			if exposure := rm.Exposure(1, "AAPL"); exposure.OpenBids+exposure.OpenAsks > 15 {
+				t.Errorf("Expected the rejected order not to be tracked, got %+v", exposure)
+			}
+		})
+	}
+}
+
+func TestRiskManager_Amend(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+	rm := NewRiskManager()
+	exchange.SetDefaultRiskLimits(RiskLimits{MaxOrderSize: 10, MaxNotional: 1000, MaxOpenOrders: 1})
+	exchange.SetRiskManager(rm)
+
+	bid, _ := exchange.Limit("AAPL", 100, 5, Bid, 1)
+	drainOrderActions(&exchange, actions)
+
+	// Raising the size or price is checked as if the amended order were new, before the book is touched
+	if err := exchange.Modify(bid, 100, 100000); riskRule(err) != RiskRuleMaxOrderSize {
+		t.Errorf("Expected the size increase to fail the order size limit, got %v", err)
+	}
+	got := drainOrderActions(&exchange, actions)
+	if len(got) != 1 || got[0].action_type != ActionReplaceReject || got[0].RejectReason() != RejectRiskLimit || got[0].RiskRule() != RiskRuleMaxOrderSize {
+		t.Errorf("Expected a risk replace reject action, got %v", got)
+	}
+	if err := exchange.Modify(bid, 250, 5); riskRule(err) != RiskRuleMaxNotional {
+		t.Errorf("Expected the price increase to fail the notional limit, got %v", err)
+	}
+	if got := rm.Exposure(1, "AAPL"); got != (Exposure{OpenBids: 5, OpenOrders: 1}) {
+		t.Errorf("Expected the rejected amendments not to be tracked, got %+v", got)
+	}
+	if orders := exchange.Orders("AAPL").Bids; len(orders) != 1 || orders[0].Price != 100 || orders[0].Size != 5 {
+		t.Errorf("Expected the order to rest unchanged, got %+v", orders)
+	}
+
+	// An amendment within the limits passes, the order it replaces not counting towards the open orders
+	if err := exchange.Modify(bid, 100, 10); err != nil {
+		t.Errorf("Expected the amendment within the limits to be accepted, got %v", err)
+	}
+	if got := rm.Exposure(1, "AAPL"); got != (Exposure{OpenBids: 10, OpenOrders: 1}) {
+		t.Errorf("Expected the amended bid to be working 10, got %+v", got)
+	}
+}
+
+func TestRiskManager_TraderLimits(t *testing.T) {
+	var exchange Exchange
+	exchange.Init("Test Exchange", nil, SubscribeOptions{})
+	rm := NewRiskManager()
+	exchange.SetDefaultRiskLimits(RiskLimits{MaxOrderSize: 10})
+	exchange.SetRiskLimits(2, RiskLimits{MaxOrderSize: 100})
+	exchange.SetRiskManager(rm)
+
+	// Trader 1 has the default limits, and trader 2 their own
+	if _, err := exchange.Limit("AAPL", 100, 50, Bid, 1); riskRule(err) != RiskRuleMaxOrderSize {
+		t.Errorf("Expected the default limit to reject the order, got %v", err)
+	}
+	if _, err := exchange.Limit("AAPL", 100, 50, Bid, 2); err != nil {
+		t.Errorf("Expected the trader's own limit to accept the order, got %v", err)
+	}
+}
+
+func TestRiskManager_Exposure(t *testing.T) {
+	var exchange Exchange
+	exchange.Init("Test Exchange", nil, SubscribeOptions{})
+	rm := NewRiskManager()
+	exchange.SetRiskManager(rm)
+
+	bid, _ := exchange.Limit("AAPL", 100, 10, Bid, 1)
+	exchange.Limit("AAPL", 105, 6, Ask, 1)
+	if got := rm.Exposure(1, "AAPL"); got != (Exposure{OpenBids: 10, OpenAsks: 6, OpenOrders: 2}) {
+		t.Errorf("Expected two working orders, got %+v", got)
+	}
+
+	// A partial fill moves the filled size from working to the position, for both traders
+	exchange.Limit("AAPL", 100, 4, Ask, 2)
+	if got := rm.Exposure(1, "AAPL"); got != (Exposure{Position: 4, OpenBids: 6, OpenAsks: 6, OpenOrders: 2}) {
+		t.Errorf("Expected a long position of 4, got %+v", got)
+	}
+	if got := rm.Exposure(2, "AAPL"); got != (Exposure{Position: -4}) {
+		t.Errorf("Expected a short position of 4, got %+v", got)
+	}
+
+	// Amending and then cancelling the bid releases its remaining size
+	exchange.Modify(bid, 100, 2)
+	if got := rm.Exposure(1, "AAPL"); got.OpenBids != 2 {
+		t.Errorf("Expected the amended bid to be working 2, got %+v", got)
+	}
+	exchange.Cancel(bid)
+	if got := rm.Exposure(1, "AAPL"); got != (Exposure{Position: 4, OpenAsks: 6, OpenOrders: 1}) {
+		t.Errorf("Expected only the ask working, got %+v", got)
+	}
+
+	// A market order filled in full is never working
+	exchange.Market("AAPL", 6, Bid, 3)
+	if got := rm.Exposure(1, "AAPL"); got != (Exposure{Position: -2}) {
+		t.Errorf("Expected a short position of 2, got %+v", got)
+	}
+	if got := rm.Exposure(3, "AAPL"); got != (Exposure{Position: 6}) {
+		t.Errorf("Expected a long position of 6, got %+v", got)
+	}
+}
+
+func TestRiskManager_CustomRule(t *testing.T) {
+	var exchange Exchange
+	exchange.Init("Test Exchange", nil, SubscribeOptions{})
+	roundLots := NewRiskRule("round lots", func(check RiskCheck) bool {
+		return check.Size%100 == 0
+	})
+	exchange.SetRiskManager(NewRiskManager(roundLots))
+
+	if _, err := exchange.Limit("AAPL", 100, 150, Bid, 1); riskRule(err) != "round lots" {
+		t.Errorf("Expected the odd lot to be rejected, got %v", err)
+	}
+	if _, err := exchange.Limit("AAPL", 100, 200, Bid, 1); err != nil {
+		t.Errorf("Expected the round lot to be accepted, got %v", err)
+	}
+
+	// Only the given rules are applied
+	if _, err := exchange.Limit("AAPL", 100, 100000, Bid, 1); err != nil {
+		t.Errorf("Expected no limits to be checked, got %v", err)
+	}
+}
+
+func TestRiskManager_MarketNotional(t *testing.T) {
+	var exchange Exchange
+	exchange.Init("Test Exchange", nil, SubscribeOptions{})
+	exchange.SetMarketProtection(2)
+	exchange.Limit("AAPL", 100, 10, Ask, 1)
+	exchange.Limit("AAPL", 110, 10, Ask, 1)
+
+	rm := NewRiskManager()
+	exchange.SetDefaultRiskLimits(RiskLimits{MaxNotional: 1100})
+	exchange.SetRiskManager(rm)
+
+	// The market order is checked at the worst price it may trade at: 2 ticks through the best ask
+	if _, err := exchange.Market("AAPL", 11, Bid, 2); riskRule(err) != RiskRuleMaxNotional {
+		t.Errorf("Expected the market order to fail the notional limit, got %v", err)
+	}
+	if _, err := exchange.Market("AAPL", 10, Bid, 2); err != nil {
+		t.Errorf("Expected the market order to pass the notional limit, got %v", err)
+	}
+}
+
+func TestRiskManager_SetRiskManager(t *testing.T) {
+	var exchange Exchange
+	exchange.Init("Test Exchange", nil, SubscribeOptions{})
+	exchange.Limit("AAPL", 100, 10, Bid, 1)
+	exchange.Limit("MSFT", 50, 5, Ask, 1)
+
+	// The orders already working are tracked once the risk manager is attached
+	rm := NewRiskManager()
+	exchange.SetDefaultRiskLimits(RiskLimits{MaxOpenOrders: 2})
+	exchange.SetRiskManager(rm)
+	if got := rm.Exposure(1, "AAPL"); got != (Exposure{OpenBids: 10, OpenOrders: 2}) {
+		t.Errorf("Expected the working bid to be tracked, got %+v", got)
+	}
+	if _, err := exchange.Limit("AAPL", 99, 1, Bid, 1); riskRule(err) != RiskRuleMaxOpenOrders {
+		t.Errorf("Expected the third order to be rejected, got %v", err)
+	}
+
+	// Removing the risk manager removes the checks
+	exchange.SetRiskManager(nil)
+	if _, err := exchange.Limit("AAPL", 99, 1, Bid, 1); err != nil {
+		t.Errorf("Expected the order to be accepted without risk checks, got %v", err)
+	}
+}
+
+func TestRiskManager_LimitsReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "exchange.journal")
+	journal, err := OpenJournal(path, JournalOptions{Sync: SyncEveryRecord})
+	if err != nil {
+		t.Fatalf("Expected to open the journal, got %v", err)
+	}
+	var live Exchange
+	live.Init("Live Exchange", nil, SubscribeOptions{})
+	live.SetRiskManager(NewRiskManager())
+	live.SetJournal(journal)
+
+	// The limits changed part way through the session apply to the orders after them
+	live.Limit("AAPL", 100, 50, Bid, 1)
+	live.SetDefaultRiskLimits(RiskLimits{MaxOrderSize: 10})
+	live.SetRiskLimits(2, RiskLimits{MaxOrderSize: 100})
+	if _, err := live.Limit("AAPL", 100, 50, Bid, 1); riskRule(err) != RiskRuleMaxOrderSize {
+		t.Errorf("Expected the new default limit to reject the order, got %v", err)
+	}
+	live.Limit("AAPL", 100, 50, Ask, 2)
+	journal.Close()
+
+	// The limit changes are journalled, so the replay makes the same decisions
+	var replayed Exchange
+	replayed.Init("Replayed Exchange", nil, SubscribeOptions{})
+	replayed.SetRiskManager(NewRiskManager())
+	if err := replayJournal(t, &replayed, path); err != nil {
+		t.Fatalf("Expected the journal to replay, got %v", err)
+	}
+	if replayed.ActionHash() != live.ActionHash() || replayed.traderRiskLimits(2) != (RiskLimits{MaxOrderSize: 100}) {
+		t.Errorf("Expected the replay to match the live exchange")
+	}
+}
+
+func TestRiskManager_Snapshot(t *testing.T) {
+	var live Exchange
+	live.Init("Live Exchange", nil, SubscribeOptions{})
+	live.SetRiskManager(NewRiskManager())
+	live.SetDefaultRiskLimits(RiskLimits{MaxOrderSize: 1000})
+	live.SetRiskLimits(3, RiskLimits{MaxOpenOrders: 50})
+	tradeSession(&live, 1)
+
+	var snapshot bytes.Buffer
+	if err := live.Snapshot(&snapshot); err != nil {
+		t.Fatalf("Expected to take a snapshot, got %v", err)
+	}
+
+	// The positions and working orders are restored with the snapshot
+	var restored Exchange
+	restored.Init("Restored Exchange", nil, SubscribeOptions{})
+	rm := NewRiskManager()
+	restored.SetRiskManager(rm)
+	if err := restored.Restore(&snapshot); err != nil {
+		t.Fatalf("Expected to restore the snapshot, got %v", err)
+	}
+	for trader := TraderID(1); trader <= 4; trader++ {
+		for _, symbol := range []string{"AAPL", "MSFT"} {
+			if got, want := rm.Exposure(trader, symbol), live.risk.Exposure(trader, symbol); got != want {
+				t.Errorf("Expected trader %d's %v exposure %+v, got %+v", trader, symbol, want, got)
+			}
+		}
+	}
+
+	// The limits are restored with the snapshot, whether or not a risk manager is attached
+	if restored.riskDefaults != (RiskLimits{MaxOrderSize: 1000}) || restored.traderRiskLimits(3) != (RiskLimits{MaxOpenOrders: 50}) || len(restored.riskLimits) != 1 {
+		t.Errorf("Expected the risk limits to be restored, got %+v and %+v", restored.riskDefaults, restored.riskLimits)
+	}
+}
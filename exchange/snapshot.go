@@ -0,0 +1,394 @@
+package exchange
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"slices"
+
+	"github.com/google/btree"
+)
+
+// snapshotMagic identifies a snapshot, and is written at the start of it
+const snapshotMagic = "EXSS"
+
+// snapshotVersion is the version of the binary snapshot encoding, written after the magic
+const snapshotVersion uint8 = 4
+
+// snapshotOldestVersion is the oldest snapshot version still restored. The sections added since are restored as zero values:
+// version 2 added the traders' positions, version 3 the price collars and last trade prices, and version 4 the risk limits
+const snapshotOldestVersion uint8 = 1
+
+// ErrSnapshotCorrupt is returned when restoring from a snapshot that is truncated, corrupted or not a snapshot
+var ErrSnapshotCorrupt = errors.New("exchange: snapshot corrupt")
+
+// Snapshot writes the state of the exchange at a point between commands to w, in a versioned binary format
+// The snapshot holds every orderbook (price levels in time priority, and the last trade price), the closed orders,
+// the sequence numbers, the price collars, the risk limits and the traders' positions tracked by the risk manager (if one is attached)
+// Restarting from the latest snapshot and replaying the journal after it reproduces the exchange
+func (ex *Exchange) Snapshot(w io.Writer) error {
+	// Lock the command mutex, so the snapshot is taken between commands
+	ex.commandMutex.Lock()
+	defer ex.commandMutex.Unlock()
+
+	_, err := w.Write(ex.snapshot())
+	return err
+}
+
+// snapshot returns the encoded snapshot of the exchange. The command mutex must be held by the caller
+func (ex *Exchange) snapshot() []byte {
+	buf := []byte(snapshotMagic)
+	buf = append(buf, snapshotVersion)
+
+	// The sequence numbers and counters
+	ex.mutex.RLock()
+	buf = binary.LittleEndian.AppendUint64(buf, ex.commandSequence)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(ex.currentOrderID))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(ex.currentTradeID))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(ex.protection))
+	ex.publishMutex.Lock()
+	buf = binary.LittleEndian.AppendUint64(buf, ex.sequence)
+	buf = binary.LittleEndian.AppendUint64(buf, ex.actionHash)
+	ex.publishMutex.Unlock()
+
+	// The price collars, in symbol order
+	symbols := make([]string, 0, len(ex.collars))
+	for symbol := range ex.collars {
+		symbols = append(symbols, symbol)
+	}
+	slices.Sort(symbols)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(symbols)))
+	for _, symbol := range symbols {
+		collar := ex.collars[symbol]
+		buf = appendString(buf, symbol)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(collar.Ticks))
+		buf = binary.LittleEndian.AppendUint32(buf, collar.BasisPoints)
+		buf = append(buf, uint8(collar.Reference))
+		buf = appendBool(buf, collar.Clip)
+	}
+
+	// The closed orders, in the order they are retained (so the oldest is forgotten first after a restore)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(ex.closedRing)))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(ex.closedNext))
+	for _, orderID := range ex.closedRing {
+		buf = appendOrderStatus(buf, ex.closedOrders[orderID])
+	}
+
+	// Take the orderbooks in symbol order, so the same state is always written identically
+	books := make([]*OrderBook, 0, len(ex.orderbooksMap))
+	for _, ob := range ex.orderbooksMap {
+		books = append(books, ob)
+	}
+	ex.mutex.RUnlock()
+	slices.SortFunc(books, func(a, b *OrderBook) int {
+		return cmp.Compare(a.symbol, b.symbol)
+	})
+
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(books)))
+	for _, ob := range books {
+		buf = ob.appendSnapshot(buf)
+	}
+
+	// The traders' positions (their working orders are restored from the orderbooks)
+	positions := ex.risk.positions()
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(positions)))
+	for _, position := range positions {
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(position.trader))
+		buf = appendString(buf, position.symbol)
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(position.position))
+	}
+
+	// The default risk limits, and then the traders' own limits in trader order
+	ex.mutex.RLock()
+	buf = appendRiskLimits(buf, ex.riskDefaults)
+	traders := make([]TraderID, 0, len(ex.riskLimits))
+	for trader := range ex.riskLimits {
+		traders = append(traders, trader)
+	}
+	slices.Sort(traders)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(traders)))
+	for _, trader := range traders {
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(trader))
+		buf = appendRiskLimits(buf, ex.riskLimits[trader])
+	}
+	ex.mutex.RUnlock()
+
+	// Checksum the whole snapshot, so a corrupted file is not restored
+	return binary.LittleEndian.AppendUint32(buf, crc32.Checksum(buf, journalTable))
+}
+
+// appendSnapshot appends the orderbook's state to buf: its bids and then asks, each in ascending price order
+func (ob *OrderBook) appendSnapshot(buf []byte) []byte {
+	// Lock the orderbook mutex (for reading) to prevent concurrent access
+	ob.mutex.RLock()
+	defer ob.mutex.RUnlock()
+
+	buf = appendString(buf, ob.symbol)
+	buf = binary.LittleEndian.AppendUint64(buf, ob.sequence)
+	buf = appendString(buf, ob.lastBBO.Symbol)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(ob.lastBBO.BidPrice))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(ob.lastBBO.BidSize))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(ob.lastBBO.AskPrice))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(ob.lastBBO.AskSize))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(ob.lastTrade))
+
+	for _, tree := range []*btree.BTree{ob.bids, ob.asks} {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(tree.Len()))
+		tree.Ascend(func(item btree.Item) bool {
+			pp := item.(*PricePoint)
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(pp.price))
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(pp.count))
+
+			// The resting orders, in time priority
+			for node := pp.head; node != nil; node = node.next {
+				buf = appendOrder(buf, &node.order)
+			}
+			return true
+		})
+	}
+	return buf
+}
+
+// Restore replaces the state of the exchange with the snapshot read from r
+// The subscribers (and actions channel) are kept, and no actions are published for the restored orders
+// Once restored, the journal tail after the snapshot can be replayed to bring the exchange up to date
+func (ex *Exchange) Restore(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	// Lock the command mutex, so the state is not replaced part way through a command
+	ex.commandMutex.Lock()
+	defer ex.commandMutex.Unlock()
+
+	return ex.restore(data)
+}
+
+// restore replaces the state of the exchange with the encoded snapshot. The command mutex must be held by the caller
+func (ex *Exchange) restore(data []byte) error {
+	// Check the magic, version and checksum, before decoding anything
+	if len(data) < len(snapshotMagic)+1+4 || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return ErrSnapshotCorrupt
+	}
+	version := data[len(snapshotMagic)]
+	if version < snapshotOldestVersion || version > snapshotVersion {
+		return fmt.Errorf("exchange: unsupported snapshot version %d", version)
+	}
+	body, checksum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.Checksum(body, journalTable) != checksum {
+		return ErrSnapshotCorrupt
+	}
+
+	// Decode into fresh state, only replacing the exchange's state once the whole snapshot is decoded
+	d := decoder{buf: body[len(snapshotMagic)+1:]}
+	restored := &Exchange{
+		orderbooksMap:  make(map[string]*OrderBook, EstNumSymbols),
+		orderIDMap:     make(map[OrderID]*orderNode, EstNumOrders),
+		closedOrders:   make(map[OrderID]OrderStatus, ClosedOrderRetention),
+		clientOrderIDs: make(map[TraderID]map[string]OrderID),
+		collars:        make(map[string]PriceCollar),
+		riskLimits:     make(map[TraderID]RiskLimits),
+	}
+	commandSequence := d.uint64()
+	restored.currentOrderID = OrderID(d.uint64())
+	restored.currentTradeID = TradeID(d.uint64())
+	restored.protection = Price(d.uint32())
+	sequence := d.uint64()
+	actionHash := d.uint64()
+
+	// The price collars
+	collars := 0
+	if version >= 3 {
+		collars = int(d.uint32())
+	}
+	for i := 0; i < collars && d.err == nil; i++ {
+		symbol := d.string()
+		restored.collars[symbol] = PriceCollar{
+			Ticks:       Price(d.uint32()),
+			BasisPoints: d.uint32(),
+			Reference:   CollarReference(d.uint8()),
+			Clip:        d.bool(),
+		}
+	}
+
+	// The closed orders
+	closed := int(d.uint32())
+	restored.closedNext = int(d.uint32())
+	if d.err != nil || closed > int(ClosedOrderRetention) || restored.closedNext >= max(closed, 1) {
+		return ErrSnapshotCorrupt
+	}
+	restored.closedRing = make([]OrderID, 0, ClosedOrderRetention)
+	for i := 0; i < closed && d.err == nil; i++ {
+		status := decodeOrderStatus(&d)
+		restored.closedRing = append(restored.closedRing, status.OrderID)
+		restored.closedOrders[status.OrderID] = status
+	}
+
+	// The orderbooks, relinking their resting orders into the orderIDMap and client order ID index
+	books := int(d.uint32())
+	for i := 0; i < books && d.err == nil; i++ {
+		if !restored.restoreOrderBook(&d, ex, version) {
+			return ErrSnapshotCorrupt
+		}
+	}
+
+	// The traders' positions
+	var positions []riskPosition
+	count := 0
+	if version >= 2 {
+		count = int(d.uint32())
+	}
+	for i := 0; i < count && d.err == nil; i++ {
+		positions = append(positions, riskPosition{
+			trader:   TraderID(d.uint16()),
+			symbol:   d.string(),
+			position: int64(d.uint64()),
+		})
+	}
+
+	// The risk limits
+	limits := 0
+	if version >= 4 {
+		restored.riskDefaults = decodeRiskLimits(&d)
+		limits = int(d.uint32())
+	}
+	for i := 0; i < limits && d.err == nil; i++ {
+		trader := TraderID(d.uint16())
+		restored.riskLimits[trader] = decodeRiskLimits(&d)
+	}
+	if d.err != nil || len(d.buf) != 0 {
+		return ErrSnapshotCorrupt
+	}
+
+	// Replace the exchange's state with the restored state
+	ex.mutex.Lock()
+	ex.orderbooksMap = restored.orderbooksMap
+	ex.orderIDMap = restored.orderIDMap
+	ex.currentOrderID = restored.currentOrderID
+	ex.currentTradeID = restored.currentTradeID
+	ex.protection = restored.protection
+	ex.collars = restored.collars
+	ex.riskDefaults = restored.riskDefaults
+	ex.riskLimits = restored.riskLimits
+	ex.closedOrders = restored.closedOrders
+	ex.closedRing = restored.closedRing
+	ex.closedNext = restored.closedNext
+	ex.clientOrderIDs = restored.clientOrderIDs
+	ex.commandSequence = commandSequence
+	if ex.risk != nil {
+		ex.risk.reset(positions, ex.orderIDMap)
+	}
+	ex.mutex.Unlock()
+
+	ex.publishMutex.Lock()
+	ex.sequence = sequence
+	ex.actionHash = actionHash
+	ex.publishMutex.Unlock()
+	return nil
+}
+
+// restoreOrderBook decodes an orderbook (in the given snapshot version) into the restored state, owned by the exchange ex
+// Returns false if the orderbook is inconsistent (eg. a duplicate OrderID, or an order at the wrong price)
+func (restored *Exchange) restoreOrderBook(d *decoder, ex *Exchange, version uint8) bool {
+	ob := new(OrderBook)
+	ob.init(d.string(), ex)
+	ob.sequence = d.uint64()
+	ob.lastBBO = BBO{
+		Symbol:   d.string(),
+		BidPrice: Price(d.uint32()),
+		BidSize:  Size(d.uint32()),
+		AskPrice: Price(d.uint32()),
+		AskSize:  Size(d.uint32()),
+	}
+	if version >= 3 {
+		ob.lastTrade = Price(d.uint32())
+	}
+	if _, exists := restored.orderbooksMap[ob.symbol]; exists {
+		return false
+	}
+	restored.orderbooksMap[ob.symbol] = ob
+
+	for _, side := range []Side{Bid, Ask} {
+		levels := int(d.uint32())
+		for i := 0; i < levels && d.err == nil; i++ {
+			pp := &PricePoint{price: Price(d.uint32())}
+			count := int(d.uint32())
+			if count == 0 || ob.sideTree(side).Has(pp) {
+				return false
+			}
+			for j := 0; j < count && d.err == nil; j++ {
+				node := &orderNode{order: decodeOrder(d)}
+				order := &node.order
+				if order.symbol != ob.symbol || order.side != side || order.price != pp.price {
+					return false
+				}
+				if _, exists := restored.orderIDMap[order.orderID]; exists {
+					return false
+				}
+				pp.pushBack(node)
+				restored.orderIDMap[order.orderID] = node
+				if order.clientOrderID != "" {
+					if restored.clientOrderIDs[order.trader] == nil {
+						restored.clientOrderIDs[order.trader] = make(map[string]OrderID)
+					}
+					restored.clientOrderIDs[order.trader][order.clientOrderID] = order.orderID
+				}
+			}
+			ob.sideTree(side).ReplaceOrInsert(pp)
+		}
+	}
+	return d.err == nil
+}
+
+// decodeOrder decodes an order encoded by appendOrder
+func decodeOrder(d *decoder) Order {
+	return Order{
+		orderID:       OrderID(d.uint64()),
+		symbol:        d.string(),
+		price:         Price(d.uint32()),
+		size:          Size(d.uint32()),
+		side:          Side(d.uint8()),
+		tif:           TimeInForce(d.uint8()),
+		trader:        TraderID(d.uint16()),
+		expiry:        int64(d.uint64()),
+		clientOrderID: d.string(),
+		original:      Size(d.uint32()),
+		filled:        Size(d.uint32()),
+		notional:      d.uint64(),
+	}
+}
+
+// appendOrderStatus appends the binary encoding of a closed order's status to buf
+func appendOrderStatus(buf []byte, status OrderStatus) []byte {
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(status.OrderID))
+	buf = appendString(buf, status.Symbol)
+	buf = append(buf, uint8(status.Side))
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(status.Trader))
+	buf = appendString(buf, status.ClientOrderID)
+	buf = append(buf, uint8(status.State))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(status.OriginalSize))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(status.FilledSize))
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(status.AvgFillPrice))
+	return binary.LittleEndian.AppendUint32(buf, uint32(status.RemainingSize))
+}
+
+// decodeOrderStatus decodes a closed order's status encoded by appendOrderStatus
+func decodeOrderStatus(d *decoder) OrderStatus {
+	return OrderStatus{
+		OrderID:       OrderID(d.uint64()),
+		Symbol:        d.string(),
+		Side:          Side(d.uint8()),
+		Trader:        TraderID(d.uint16()),
+		ClientOrderID: d.string(),
+		State:         OrderState(d.uint8()),
+		OriginalSize:  Size(d.uint32()),
+		FilledSize:    Size(d.uint32()),
+		AvgFillPrice:  math.Float64frombits(d.uint64()),
+		RemainingSize: Size(d.uint32()),
+	}
+}
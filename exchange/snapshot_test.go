@@ -0,0 +1,222 @@
+package exchange
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// tradeSession sends a mix of orders, amendments and cancels to the exchange
+func tradeSession(exchange *Exchange, trader TraderID) {
+	bid, _ := exchange.Limit("AAPL", 100, 10, Bid, trader)
+	exchange.Limit("AAPL", 100, 5, Bid, trader+1)
+	exchange.Limit("AAPL", 103, 7, Ask, trader+2)
+	exchange.Submit(OrderRequest{Symbol: "MSFT", Price: 50, Size: 20, Side: Bid, Trader: trader, TimeInForce: GTD, ExpireAt: time.Now().Add(time.Hour), ClientOrderID: "m"})
+	exchange.Limit("MSFT", 50, 4, Ask, trader+1)
+	exchange.Market("AAPL", 3, Bid, trader+3)
+	exchange.Modify(bid, 101, 8)
+	exchange.Limit("AAPL", 101, 2, Ask, trader+2)
+}
+
+func TestSnapshot_RestoreAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "exchange.journal")
+	journal, err := OpenJournal(path, JournalOptions{Sync: SyncBatch})
+	if err != nil {
+		t.Fatalf("Expected to open the journal, got %v", err)
+	}
+
+	var live Exchange
+	live.Init("Live Exchange", nil, SubscribeOptions{})
+	live.SetJournal(journal)
+	tradeSession(&live, 1)
+
+	// Snapshot part way through the session, then carry on trading
+	var snapshot bytes.Buffer
+	if err := live.Snapshot(&snapshot); err != nil {
+		t.Fatalf("Expected to take a snapshot, got %v", err)
+	}
+	tradeSession(&live, 10)
+	live.CancelAll(11)
+	journal.Close()
+
+	// Restart from the snapshot, replaying the journal tail after it
+	var restarted Exchange
+	restarted.Init("Restarted Exchange", nil, SubscribeOptions{})
+	if err := restarted.Restore(&snapshot); err != nil {
+		t.Fatalf("Expected to restore the snapshot, got %v", err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Expected to open the journal, got %v", err)
+	}
+	defer file.Close()
+	if err := restarted.Replay(file); err != nil {
+		t.Fatalf("Expected to replay the journal tail, got %v", err)
+	}
+
+	if restarted.ActionHash() != live.ActionHash() {
+		t.Errorf("Expected the action hash %x, got %x", live.ActionHash(), restarted.ActionHash())
+	}
+	for _, symbol := range []string{"AAPL", "MSFT"} {
+		if !reflect.DeepEqual(restarted.Orders(symbol), live.Orders(symbol)) {
+			t.Errorf("Expected the %v orders %+v, got %+v", symbol, live.Orders(symbol), restarted.Orders(symbol))
+		}
+		if restarted.BBO(symbol) != live.BBO(symbol) {
+			t.Errorf("Expected the %v BBO %+v, got %+v", symbol, live.BBO(symbol), restarted.BBO(symbol))
+		}
+	}
+
+	// Snapshots of the two exchanges are identical
+	var live_snapshot, restarted_snapshot bytes.Buffer
+	live.Snapshot(&live_snapshot)
+	restarted.Snapshot(&restarted_snapshot)
+	if !bytes.Equal(live_snapshot.Bytes(), restarted_snapshot.Bytes()) {
+		t.Errorf("Expected the snapshots of the live and restarted exchanges to be identical")
+	}
+}
+
+func TestSnapshot_Restore(t *testing.T) {
+	var live Exchange
+	live.Init("Live Exchange", nil, SubscribeOptions{})
+	tradeSession(&live, 1)
+
+	var snapshot bytes.Buffer
+	if err := live.Snapshot(&snapshot); err != nil {
+		t.Fatalf("Expected to take a snapshot, got %v", err)
+	}
+
+	actions := make(chan *Action, ChanSize)
+	var restored Exchange
+	restored.Init("Restored Exchange", actions, SubscribeOptions{})
+	restored.Limit("GOOGL", 100, 1, Bid, 1)
+	if err := restored.Restore(&snapshot); err != nil {
+		t.Fatalf("Expected to restore the snapshot, got %v", err)
+	}
+	drainActions(&restored, actions)
+
+	// The restored state replaces the exchange's previous state, with the time priority of each level kept
+	if depth := restored.Depth("GOOGL", 1); len(depth.Bids) != 0 {
+		t.Errorf("Expected the previous state to be replaced, got %+v", depth)
+	}
+	if !reflect.DeepEqual(restored.Orders("AAPL"), live.Orders("AAPL")) {
+		t.Errorf("Expected the AAPL orders %+v, got %+v", live.Orders("AAPL"), restored.Orders("AAPL"))
+	}
+	for orderID := OrderID(1); orderID <= 8; orderID++ {
+		want_status, want_ok := live.OrderStatus(orderID)
+		got_status, got_ok := restored.OrderStatus(orderID)
+		if got_ok != want_ok || got_status != want_status {
+			t.Errorf("Expected the status of order %d to be %+v, got %+v", orderID, want_status, got_status)
+		}
+	}
+
+	// Resting orders can be cancelled by their client order ID, and new orders continue the OrderIDs and sequences
+	if err := restored.CancelByClientOrderID(1, "m"); err != nil {
+		t.Errorf("Expected to cancel the restored order by its client order ID, got %v", err)
+	}
+	live.CancelByClientOrderID(1, "m")
+	live_id, _ := live.Limit("AAPL", 103, 4, Bid, 5)
+	restored_id, _ := restored.Limit("AAPL", 103, 4, Bid, 5)
+	if restored_id != live_id {
+		t.Errorf("Expected the next OrderID to be %d, got %d", live_id, restored_id)
+	}
+	if restored.ActionHash() != live.ActionHash() {
+		t.Errorf("Expected the action hash %x, got %x", live.ActionHash(), restored.ActionHash())
+	}
+	executions := 0
+	for _, action := range drainActions(&restored, actions) {
+		if action.Type() == ActionExecute {
+			executions += 1
+			if action.CrossOrder().OrderID() != 3 {
+				t.Errorf("Expected the execution against the restored ask (order 3), got %v", action)
+			}
+		}
+	}
+	if executions != 1 {
+		t.Errorf("Expected 1 execution against the restored book, got %d", executions)
+	}
+}
+
+func TestSnapshot_Corrupt(t *testing.T) {
+	var live Exchange
+	live.Init("Live Exchange", nil, SubscribeOptions{})
+	tradeSession(&live, 1)
+
+	var snapshot bytes.Buffer
+	live.Snapshot(&snapshot)
+	data := snapshot.Bytes()
+
+	var restored Exchange
+	restored.Init("Restored Exchange", nil, SubscribeOptions{})
+
+	// A flipped byte, a truncated snapshot and something that is not a snapshot are not restored
+	flipped := bytes.Clone(data)
+	flipped[len(flipped)/2] ^= 0xFF
+	for name, corrupt := range map[string][]byte{
+		"flipped":   flipped,
+		"truncated": data[:len(data)-10],
+		"garbage":   []byte("not a snapshot"),
+	} {
+		if err := restored.Restore(bytes.NewReader(corrupt)); !errors.Is(err, ErrSnapshotCorrupt) {
+			t.Errorf("Expected a %v snapshot to be corrupt, got %v", name, err)
+		}
+	}
+
+	// An unknown version is refused
+	future := bytes.Clone(data)
+	future[len(snapshotMagic)] = snapshotVersion + 1
+	if err := restored.Restore(bytes.NewReader(future)); err == nil || errors.Is(err, ErrSnapshotCorrupt) {
+		t.Errorf("Expected an unsupported version error, got %v", err)
+	}
+	if len(restored.Orders("AAPL").Bids) != 0 {
+		t.Errorf("Expected nothing to be restored from a corrupt snapshot")
+	}
+}
+
+func TestSnapshot_RestoreOlderVersions(t *testing.T) {
+	// The same trading session, as snapshotted by each older version (with a risk manager attached, from version 2)
+	for _, test := range []struct {
+		version  uint8
+		exposure Exposure // Trader 1's AAPL exposure once restored (positions were added in version 2)
+	}{
+		{1, Exposure{OpenBids: 6, OpenOrders: 2}},
+		{2, Exposure{Position: 2, OpenBids: 6, OpenOrders: 2}},
+	} {
+		data, err := os.ReadFile(filepath.Join("testdata", fmt.Sprintf("snapshot_v%d.bin", test.version)))
+		if err != nil {
+			t.Fatalf("Expected to read the version %d snapshot, got %v", test.version, err)
+		}
+
+		var restored Exchange
+		restored.Init("Restored Exchange", nil, SubscribeOptions{})
+		rm := NewRiskManager()
+		restored.SetRiskManager(rm)
+		if err := restored.Restore(bytes.NewReader(data)); err != nil {
+			t.Fatalf("Expected to restore the version %d snapshot, got %v", test.version, err)
+		}
+
+		var live Exchange
+		live.Init("Live Exchange", nil, SubscribeOptions{})
+		tradeSession(&live, 1)
+		for _, symbol := range []string{"AAPL", "MSFT"} {
+			if !reflect.DeepEqual(restored.Orders(symbol), live.Orders(symbol)) {
+				t.Errorf("Expected the version %d %v orders %+v, got %+v", test.version, symbol, live.Orders(symbol), restored.Orders(symbol))
+			}
+		}
+		if got := rm.Exposure(1, "AAPL"); got != test.exposure {
+			t.Errorf("Expected the version %d exposure %+v, got %+v", test.version, test.exposure, got)
+		}
+		if _, _, ok := restored.PriceBand("AAPL"); ok {
+			t.Errorf("Expected no price collar from a version %d snapshot", test.version)
+		}
+
+		// The restored exchange carries on from the snapshot
+		if orderID, _ := restored.Limit("AAPL", 90, 1, Bid, 1); orderID != live.currentOrderID+1 {
+			t.Errorf("Expected the next OrderID to be %d, got %d", live.currentOrderID+1, orderID)
+		}
+	}
+}
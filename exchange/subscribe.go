@@ -0,0 +1,96 @@
+package exchange
+
+import "slices"
+
+// SlowConsumerPolicy represents how the exchange treats a subscriber whose queue is full
+type SlowConsumerPolicy uint8
+
+// Define the slow consumer policies of a subscription
+const (
+	SlowConsumerBlock      SlowConsumerPolicy = iota // Wait for the queue to have room, holding up order entry, but not queries (no actions are lost)
+	SlowConsumerDropOldest                           // Drop the oldest queued action for the subscriber only, and carry on
+	SlowConsumerDisconnect                           // Unsubscribe the subscriber, closing its channel
+)
+
+// SubscriptionFilter selects the actions delivered to a subscriber
+// Empty fields match any action: any symbol, any trader and any action type
+type SubscriptionFilter struct {
+	Symbols []string     // Only deliver actions in these symbols
+	Traders []TraderID   // Only deliver actions for orders of these traders (either side of an execution)
+	Types   []ActionType // Only deliver actions of these types
+}
+
+// matches reports whether the action is selected by the filter
+func (filter *SubscriptionFilter) matches(action *Action) bool {
+	if len(filter.Types) > 0 && !slices.Contains(filter.Types, action.action_type) {
+		return false
+	}
+	if len(filter.Symbols) > 0 && !slices.Contains(filter.Symbols, action.order.symbol) {
+		return false
+	}
+	if len(filter.Traders) > 0 {
+		// Executions involve the traders of both orders; other actions only the trader of their order (if any)
+		crossTrader := action.action_type == ActionExecute && slices.Contains(filter.Traders, action.cross_order.trader)
+		if !crossTrader && (action.order.trader == 0 || !slices.Contains(filter.Traders, action.order.trader)) {
+			return false
+		}
+	}
+	return true
+}
+
+// SubscribeOptions configures the buffering of a subscription
+// Published actions are queued (up to QueueSize) and then delivered to the subscriber's channel (of capacity BufferSize)
+// The Policy applies once the queue is full. A zero BufferSize uses ChanSize, and a zero QueueSize uses QueueSize
+type SubscribeOptions struct {
+	BufferSize int
+	QueueSize  int
+	Policy     SlowConsumerPolicy
+}
+
+// Subscribe registers a new consumer of the actions published by the exchange, selected by the filter
+// Actions are delivered in the order published, alongside the actions channel given to Init
+// Returns the subscriber's channel and a function to unsubscribe, which closes the channel
+// Actions already queued for the subscriber are delivered on unsubscribe if they fit in the channel, otherwise discarded
+func (ex *Exchange) Subscribe(filter SubscriptionFilter, options SubscribeOptions) (<-chan *Action, func()) {
+	if options.BufferSize <= 0 {
+		options.BufferSize = int(ChanSize)
+	}
+	if options.QueueSize <= 0 {
+		options.QueueSize = int(QueueSize)
+	}
+
+	// Copy the filter, so later changes by the caller do not affect the subscription
+	filter = SubscriptionFilter{
+		Symbols: slices.Clone(filter.Symbols),
+		Traders: slices.Clone(filter.Traders),
+		Types:   slices.Clone(filter.Types),
+	}
+
+	// Lock the publish mutex so the subscriber only receives whole commands' actions, from the next ones delivered
+	ex.publishMutex.Lock()
+	ex.subscriberID += 1
+	sub := newSubscriber(ex.subscriberID, filter, options.Policy, options.QueueSize, make(chan *Action, options.BufferSize), true)
+	ex.subscribers = append(ex.subscribers, sub)
+	ex.publishMutex.Unlock()
+
+	unsubscribe := func() {
+		// Release any publisher blocked on the subscriber before waiting for the publish mutex
+		sub.stop()
+
+		ex.publishMutex.Lock()
+		ex.removeSubscriber(sub)
+		ex.publishMutex.Unlock()
+
+		// Wait for the dispatcher to deliver what it can and close the channel
+		<-sub.stopped
+	}
+	return sub.actions, unsubscribe
+}
+
+// removeSubscriber removes the subscriber from the exchange, if it has not already been removed
+// The publish mutex must be held by the caller
+func (ex *Exchange) removeSubscriber(sub *subscriber) {
+	if index := slices.Index(ex.subscribers, sub); index >= 0 {
+		ex.subscribers = slices.Delete(ex.subscribers, index, index+1)
+	}
+}
@@ -0,0 +1,219 @@
+package exchange
+
+import (
+	"testing"
+	"time"
+)
+
+// receiveAll returns the actions published so far, once the exchange has delivered them to a subscriber's channel
+func receiveAll(exchange *Exchange, actions <-chan *Action) []*Action {
+	exchange.Flush()
+
+	var received []*Action
+	for {
+		select {
+		case action, ok := <-actions:
+			if !ok {
+				return received
+			}
+			received = append(received, action)
+		default:
+			return received
+		}
+	}
+}
+
+func TestExchange_SubscribeFilters(t *testing.T) {
+	var exchange Exchange
+	exchange.Init("Test Exchange", nil, SubscribeOptions{})
+
+	all, _ := exchange.Subscribe(SubscriptionFilter{}, SubscribeOptions{})
+	msft, _ := exchange.Subscribe(SubscriptionFilter{Symbols: []string{"MSFT"}}, SubscribeOptions{})
+	trader, _ := exchange.Subscribe(SubscriptionFilter{Traders: []TraderID{2}}, SubscribeOptions{})
+	trades, _ := exchange.Subscribe(SubscriptionFilter{Types: []ActionType{ActionTrade}}, SubscribeOptions{})
+
+	exchange.Limit("AAPL", 100, 10, Bid, 1)
+	exchange.Limit("AAPL", 100, 4, Ask, 2)
+	exchange.Limit("MSFT", 200, 10, Ask, 3)
+
+	received := receiveAll(&exchange, all)
+	if len(received) == 0 {
+		t.Fatalf("Expected every action to be delivered to the unfiltered subscriber")
+	}
+	for i, action := range received {
+		if action.ExchangeSequence() != uint64(i+1) {
+			t.Errorf("Expected the actions in published order, got sequence %d at %d", action.ExchangeSequence(), i)
+		}
+	}
+	for _, action := range receiveAll(&exchange, msft) {
+		if action.Symbol() != "MSFT" {
+			t.Errorf("Expected only MSFT actions, got %v", action)
+		}
+	}
+
+	// Trader 2's subscriber sees its order and the execution (as the Ask side), but no market data
+	received = receiveAll(&exchange, trader)
+	if len(received) != 2 || received[0].Type() != ActionAsk || received[1].Type() != ActionExecute {
+		t.Errorf("Expected trader 2's order and execution, got %v", received)
+	}
+	if received = receiveAll(&exchange, trades); len(received) != 1 || received[0].Trade().Size != 4 {
+		t.Errorf("Expected the single public trade, got %v", received)
+	}
+}
+
+func TestExchange_Unsubscribe(t *testing.T) {
+	actions := make(chan *Action, ChanSize)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{})
+
+	subscription, unsubscribe := exchange.Subscribe(SubscriptionFilter{}, SubscribeOptions{})
+	exchange.Limit("AAPL", 100, 10, Bid, 1)
+	unsubscribe()
+	unsubscribe()
+	exchange.Limit("AAPL", 100, 10, Bid, 1)
+
+	// The buffered actions remain readable, and then the channel is closed
+	if received := receiveAll(&exchange, subscription); len(received) != 3 {
+		t.Errorf("Expected the 3 actions published before unsubscribing, got %d", len(received))
+	}
+	if _, ok := <-subscription; ok {
+		t.Errorf("Expected the subscription channel to be closed")
+	}
+	if len(drainActions(&exchange, actions)) != 6 {
+		t.Errorf("Expected the actions channel to be unaffected by the subscription")
+	}
+}
+
+func TestExchange_SlowConsumerDropOldest(t *testing.T) {
+	var exchange Exchange
+	exchange.Init("Test Exchange", nil, SubscribeOptions{})
+
+	// Nothing is read while the orders are processed, so the queue overflows without holding up the exchange
+	subscription, _ := exchange.Subscribe(SubscriptionFilter{}, SubscribeOptions{BufferSize: 1, QueueSize: 2, Policy: SlowConsumerDropOldest})
+	for price := Price(100); price < 110; price++ {
+		exchange.Limit("AAPL", price, 10, Bid, 1)
+	}
+	published := exchange.PublishMetrics().Published
+
+	// The newest actions are kept, so reading eventually reaches the last action published
+	var received []*Action
+	for len(received) == 0 || received[len(received)-1].ExchangeSequence() != published {
+		received = append(received, <-subscription)
+	}
+	for i := 1; i < len(received); i++ {
+		if received[i].ExchangeSequence() <= received[i-1].ExchangeSequence() {
+			t.Errorf("Expected the actions in published order, got %d after %d", received[i].ExchangeSequence(), received[i-1].ExchangeSequence())
+		}
+	}
+
+	exchange.Flush()
+	metrics := exchange.PublishMetrics().Subscribers[0]
+	if metrics.Dropped == 0 || metrics.Delivered+metrics.Dropped != published || metrics.Delivered != uint64(len(received)) {
+		t.Errorf("Expected every action to be delivered or dropped, got %+v of %d", metrics, published)
+	}
+	if metrics.MaxQueueDepth != 2 || metrics.QueueDepth != 0 {
+		t.Errorf("Expected the queue to have filled and then emptied, got %+v", metrics)
+	}
+}
+
+func TestExchange_SlowConsumerDisconnect(t *testing.T) {
+	var exchange Exchange
+	exchange.Init("Test Exchange", nil, SubscribeOptions{})
+
+	subscription, _ := exchange.Subscribe(SubscriptionFilter{}, SubscribeOptions{BufferSize: 1, QueueSize: 1, Policy: SlowConsumerDisconnect})
+	for price := Price(100); price < 110; price++ {
+		exchange.Limit("AAPL", price, 10, Bid, 1)
+	}
+
+	// The slow subscriber receives what it was sent before being disconnected, and then its channel is closed
+	var received []*Action
+	for action := range subscription {
+		received = append(received, action)
+	}
+	if len(received) == 0 || len(received) > 3 {
+		t.Errorf("Expected at most the channel, in flight and queued actions, got %d", len(received))
+	}
+	if metrics := exchange.PublishMetrics(); metrics.Disconnected != 1 || len(metrics.Subscribers) != 0 {
+		t.Errorf("Expected the subscriber to be disconnected, got %+v", metrics)
+	}
+}
+
+func TestExchange_SlowConsumerBlockQueries(t *testing.T) {
+	actions := make(chan *Action)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{QueueSize: 1, Policy: SlowConsumerBlock})
+
+	// The actions channel is not read, so the delivery of the first order's actions waits for room in its queue
+	done := make(chan bool)
+	go func() {
+		exchange.Limit("AAPL", 100, 10, Bid, 1)
+		done <- true
+	}()
+	for deadline := time.Now().Add(time.Second); exchange.PublishMetrics().Published < 3; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the order's actions to be published")
+		}
+	}
+
+	// The exchange can still be queried while the delivery waits
+	queried := make(chan BBO)
+	go func() {
+		queried <- exchange.BBO("AAPL")
+	}()
+	select {
+	case top := <-queried:
+		if top.BidPrice != 100 {
+			t.Errorf("Expected the bid at 100, got %+v", top)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the query not to wait for the blocked consumer")
+	}
+
+	// Once read, every action is delivered in order
+	for i := 1; i <= 3; i++ {
+		if action := <-actions; action.ExchangeSequence() != uint64(i) {
+			t.Errorf("Expected sequence %d, got %d", i, action.ExchangeSequence())
+		}
+	}
+	<-done
+}
+
+func TestExchange_InitPolicy(t *testing.T) {
+	actions := make(chan *Action, 1)
+	var exchange Exchange
+	exchange.Init("Test Exchange", actions, SubscribeOptions{QueueSize: 1, Policy: SlowConsumerDropOldest})
+
+	// The actions channel is not read, but its policy drops the oldest actions rather than holding up the exchange
+	exchange.Limit("AAPL", 100, 10, Bid, 1)
+	exchange.Limit("AAPL", 101, 10, Ask, 2)
+	if metrics := exchange.PublishMetrics(); metrics.Published != 6 || metrics.Subscribers[0].Dropped == 0 {
+		t.Errorf("Expected actions to be dropped for the actions channel, got %+v", metrics)
+	}
+}
+
+func TestExchange_SlowConsumerBlock(t *testing.T) {
+	var exchange Exchange
+	exchange.Init("Test Exchange", nil, SubscribeOptions{})
+
+	subscription, unsubscribe := exchange.Subscribe(SubscriptionFilter{}, SubscribeOptions{BufferSize: 1, Policy: SlowConsumerBlock})
+
+	// The publisher waits for the subscriber, so every action is received
+	done := make(chan bool)
+	go func() {
+		exchange.Limit("AAPL", 100, 10, Bid, 1)
+		exchange.Limit("AAPL", 100, 10, Ask, 2)
+		done <- true
+	}()
+	var received []*Action
+	for len(received) < 3 {
+		received = append(received, <-subscription)
+	}
+	unsubscribe()
+	<-done
+
+	for i, action := range received {
+		if action.ExchangeSequence() != uint64(i+1) {
+			t.Errorf("Expected no actions to be lost, got sequence %d at %d", action.ExchangeSequence(), i)
+		}
+	}
+}
@@ -2,8 +2,6 @@ module github.com/ejyy/exchange_go
 
 go 1.22.5
 
-require github.com/gammazero/deque v0.2.1
-
 require github.com/google/btree v1.1.2
 
 replace github.com/ejyy/exchange_go/exchange => ./exchange
@@ -16,7 +16,7 @@ func main() {
 	var done_channel = make(chan bool)
 
 	// Initialize the exchange engine
-	exchange_engine.Init("Example exchange", actions)
+	exchange_engine.Init("Example exchange", actions, exchange.SubscribeOptions{})
 
 	// Pre-warm the exchange engine with some example symbols
 	var warming_symbols = []string{"AAPL", "GOOGL"}
@@ -29,23 +29,29 @@ func main() {
 			case action := <-actions:
 				fmt.Printf("Action: %+v\n", action)
 			case <-done_channel:
-				close(actions)
 				return
 			}
 		}
 	}()
 
-	// Send some example orders to the exchange engine
-	exchange_engine.Limit("AAPL", 100, 1000, exchange.Bid, 1)
-	exchange_engine.Limit("AAPL", 100, 1000, exchange.Ask, 2)
+	// Send some example orders to the exchange engine (each returns the assigned OrderID, or a reject error)
+	aapl_bid, _ := exchange_engine.Limit("AAPL", 100, 1000, exchange.Bid, 1)
+	aapl_ask, _ := exchange_engine.Limit("AAPL", 100, 1000, exchange.Ask, 2)
 	exchange_engine.Limit("GOOGL", 100, 1000, exchange.Bid, 3)
 	exchange_engine.Limit("GOOGL", 100, 1000, exchange.Ask, 4)
 
-	// Send some example cancels to the exchange engine
-	exchange_engine.Cancel(1)
-	exchange_engine.Cancel(2)
+	// Send some example cancels to the exchange engine (these are rejected, as the orders have filled)
+	if err := exchange_engine.Cancel(aapl_bid); err != nil {
+		fmt.Println("Cancel rejected:", err)
+	}
+	if err := exchange_engine.Cancel(aapl_ask); err != nil {
+		fmt.Println("Cancel rejected:", err)
+	}
 
-	// Send a done signal to the exchange engine
-	// Note, this will close the actions channel meaning producing a variable number of returned messages
+	// Wait for the exchange engine to deliver every action to the actions channel, then stop it
+	exchange_engine.Close()
+
+	// Send a done signal to the listening goroutine
+	// Note, actions not yet printed are discarded, meaning a variable number of returned messages
 	done_channel <- true
 }